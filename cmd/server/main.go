@@ -8,9 +8,11 @@ import (
 	"syscall"
 	"time"
 
+	"chatroom/internal/broker"
 	"chatroom/internal/config"
 	"chatroom/internal/db"
 	clog "chatroom/internal/log"
+	"chatroom/internal/presence"
 	"chatroom/internal/server"
 	"chatroom/internal/ws"
 
@@ -33,7 +35,29 @@ func main() {
 		log.Fatal().Err(err).Msg("db migrate")
 	}
 
-	hub := ws.NewHub()
+	// 多副本部署时通过 Postgres LISTEN/NOTIFY 互通房间事件。
+	var bk broker.Broker = broker.NewMemory()
+	if cfg.BrokerDriver == "postgres" {
+		pb, err := broker.NewPostgres(gdb, cfg.DatabaseDSN, broker.DefaultChannel)
+		if err != nil {
+			log.Fatal().Err(err).Msg("broker init")
+		}
+		bk = pb
+	}
+	// 在线状态写入数据库，按用户去重并汇总所有实例。
+	tracker := presence.NewTracker(gdb, presence.Options{Heartbeat: time.Duration(cfg.PresenceHeartbeatSeconds) * time.Second})
+	tracker.Start()
+	hub := ws.NewHubWithOptions(ws.HubOptions{
+		Broker:   bk,
+		Presence: tracker,
+		Limits: ws.ConnLimits{
+			PerUser: cfg.WSMaxConnsPerUser,
+			PerIP:   cfg.WSMaxConnsPerIP,
+			PerRoom: cfg.WSMaxConnsPerRoom,
+			Total:   cfg.WSMaxConns,
+		},
+		RoomIdle: time.Duration(cfg.WSRoomIdleSeconds) * time.Second,
+	})
 	r := server.SetupRouter(cfg, gdb, hub)
 
 	srv := &http.Server{
@@ -55,11 +79,19 @@ func main() {
 	sig := <-quit
 	log.Info().Str("signal", sig.String()).Msg("shutting down server")
 
+	// 先排空实时连接：就绪探针转为未就绪，等负载均衡摘除本实例后，客户端收到 server_restarting 错峰重连到其它实例。
+	grace := time.Duration(cfg.WSDrainGraceSeconds) * time.Second
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), grace+time.Duration(cfg.WSDrainTimeoutSeconds)*time.Second)
+	_ = hub.Drain(drainCtx, grace, time.Duration(cfg.WSReconnectDelaySeconds)*time.Second, time.Duration(cfg.WSReconnectJitterSeconds)*time.Second)
+	drainCancel()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	// 关闭 Hub 中所有 RoomHub goroutine。
 	hub.Shutdown()
+	tracker.Stop()
+	_ = bk.Close()
 
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Error().Err(err).Msg("server forced to shutdown")
@@ -87,6 +87,7 @@ export default function App() {
 		const typingTimers = typingTimersRef.current
 		const sock = new ChatSocket({
 			getAccessToken: () => accessRef.current,
+			refreshAccessToken: () => api.refresh(),
 			onStatus: (s, attempt) => {
 				setConnStatus(s)
 				if (s === 'reconnecting' && attempt) {
@@ -143,7 +144,7 @@ export default function App() {
 			}
 			typingTimers.clear()
 		}
-	}, [toast])
+	}, [api, toast])
 
 	useEffect(() => {
 		if (!user || !accessToken) return
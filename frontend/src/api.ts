@@ -27,9 +27,10 @@ export class Api {
     this.callbacks = opts.callbacks ?? {}
   }
 
-  private async refresh(): Promise<boolean> {
+  // refresh 用 refresh token 换取新的 token 对，返回新的 access token，失败时返回 null。
+  async refresh(): Promise<string | null> {
     const refreshToken = this.getRefreshToken()
-    if (!refreshToken) return false
+    if (!refreshToken) return null
 
     const res = await fetch('/api/v1/auth/refresh', {
       method: 'POST',
@@ -37,14 +38,14 @@ export class Api {
       body: JSON.stringify({ refresh_token: refreshToken }),
     })
 
-    if (!res.ok) return false
+    if (!res.ok) return null
 
     const data = (await safeJson<{ access_token: string; refresh_token: string }>(res))
-    if (!data.access_token || !data.refresh_token) return false
+    if (!data.access_token || !data.refresh_token) return null
 
     saveTokens(data.access_token, data.refresh_token)
     this.callbacks.onTokens?.(data.access_token, data.refresh_token)
-    return true
+    return data.access_token
   }
 
   private async request<T>(
@@ -66,14 +67,13 @@ export class Api {
     })
 
     if (authRequired && res.status === 401) {
-      const ok = await this.refresh()
-      if (!ok) {
+      const at = await this.refresh()
+      if (!at) {
         clearAuth()
         this.callbacks.onUnauthorized?.()
         throw new Error('unauthorized')
       }
-      const at = this.getAccessToken()
-      if (at) headers.Authorization = `Bearer ${at}`
+      headers.Authorization = `Bearer ${at}`
       res = await fetch(path, {
         method,
         headers,
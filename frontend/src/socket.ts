@@ -4,11 +4,14 @@ type Outbound =
   | { type: 'ping' }
   | { type: 'typing'; is_typing: boolean }
   | { type: 'message'; content: string }
+  | { type: 'auth'; token: string }
+  | { type: 'reauth'; token: string }
 
 export class ChatSocket {
 	private getAccessToken: () => string
 	private onEvent: (evt: WsEvent) => void
 	private onStatus: (status: ConnectionStatus, attempt?: number) => void
+	private refreshAccessToken?: () => Promise<string | null>
 
   private ws: WebSocket | null = null
   private reconnectAttempts = 0
@@ -19,15 +22,19 @@ export class ChatSocket {
   private heartbeatTimeout: number | null = null
   private messageQueue: Outbound[] = []
   private lastPong = Date.now()
+  // restartDelay 是服务端停服时建议的重连等待毫秒数，只用于下一次重连。
+  private restartDelay: number | null = null
 
   constructor(opts: {
 		getAccessToken: () => string
 		onEvent: (evt: WsEvent) => void
 		onStatus: (status: ConnectionStatus, attempt?: number) => void
+		refreshAccessToken?: () => Promise<string | null>
 	}) {
 		this.getAccessToken = opts.getAccessToken
 		this.onEvent = opts.onEvent
 		this.onStatus = opts.onStatus
+		this.refreshAccessToken = opts.refreshAccessToken
 	}
 
   connect(roomId: number, accessToken: string): void {
@@ -37,7 +44,8 @@ export class ChatSocket {
     this.shouldReconnect = true
 
     const proto = location.protocol === 'https:' ? 'wss:' : 'ws:'
-    const url = `${proto}//${location.host}/ws?room_id=${roomId}&token=${encodeURIComponent(accessToken)}`
+    // 令牌通过首帧 auth 发送，避免出现在 URL 与访问日志中。
+    const url = `${proto}//${location.host}/ws?room_id=${roomId}`
 
     this.onStatus('connecting')
 
@@ -49,6 +57,7 @@ export class ChatSocket {
     }
 
     this.ws.onopen = () => {
+      this.ws?.send(JSON.stringify({ type: 'auth', token: accessToken } satisfies Outbound))
       this.reconnectAttempts = 0
       this.lastPong = Date.now()
       this.onStatus('connected')
@@ -56,7 +65,9 @@ export class ChatSocket {
       this.flushQueue()
     }
 
-    this.ws.onclose = () => {
+    this.ws.onclose = (ev) => {
+      // 4003：被管理员踢出或封禁，重连也会被拒绝。
+      if (ev.code === 4003) this.shouldReconnect = false
       this.stopHeartbeat()
       this.onStatus('disconnected')
       if (this.shouldReconnect) this.scheduleReconnect()
@@ -71,6 +82,15 @@ export class ChatSocket {
         const msg = JSON.parse(ev.data) as unknown
         if (typeof msg === 'object' && msg !== null && 'type' in msg) {
           const t = (msg as { type?: unknown }).type
+          if (t === 'reauth_required') {
+            void this.reauth()
+            return
+          }
+          if (t === 'server_restarting') {
+            const ms = (msg as { reconnect_after_ms?: unknown }).reconnect_after_ms
+            if (typeof ms === 'number') this.restartDelay = ms
+            return
+          }
           if (t === 'pong') {
             this.lastPong = Date.now()
             if (this.heartbeatTimeout) {
@@ -86,6 +106,14 @@ export class ChatSocket {
     }
   }
 
+  // reauth 在令牌即将过期时刷新令牌，并通过 reauth 帧延长当前连接的有效期。
+  private async reauth(): Promise<void> {
+    const token = (await this.refreshAccessToken?.()) ?? this.getAccessToken()
+    if (token && this.ws?.readyState === WebSocket.OPEN) {
+      this.ws.send(JSON.stringify({ type: 'reauth', token } satisfies Outbound))
+    }
+  }
+
   close(clearQueue = true): void {
     this.shouldReconnect = false
     this.stopHeartbeat()
@@ -130,7 +158,8 @@ export class ChatSocket {
       return
     }
 
-    const delay = Math.min(15000, 1000 * Math.pow(1.5, this.reconnectAttempts))
+    const delay = this.restartDelay ?? Math.min(15000, 1000 * Math.pow(1.5, this.reconnectAttempts))
+    this.restartDelay = null
     this.reconnectAttempts++
 
     this.onStatus('reconnecting', this.reconnectAttempts)
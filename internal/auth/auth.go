@@ -107,10 +107,23 @@ func AuthMiddleware(cfg config.Config, db *gorm.DB) gin.HandlerFunc {
 		}
 		c.Set("userID", user.ID)
 		c.Set("user", user)
+		if claims.ExpiresAt != nil {
+			c.Set("tokenExpiresAt", claims.ExpiresAt.Time)
+		}
 		c.Next()
 	}
 }
 
+// GetTokenExpiry 返回当前请求所用访问令牌的过期时间，令牌未设置过期时间时返回零值。
+func GetTokenExpiry(c *gin.Context) time.Time {
+	if v, ok := c.Get("tokenExpiresAt"); ok {
+		if t, ok2 := v.(time.Time); ok2 {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
 // GetUserID 用于在 handler 中快速取得当前登录用户 ID。
 func GetUserID(c *gin.Context) uint {
 	if v, ok := c.Get("userID"); ok {
@@ -0,0 +1,34 @@
+// Package broker 在多个服务实例之间转发房间事件，使连接在不同副本上的用户互相可见。
+package broker
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Envelope 是在实例间传递的一帧房间事件。
+type Envelope struct {
+	// Origin 是发布方实例 ID，订阅方据此忽略自己发出的事件。
+	Origin string          `json:"o"`
+	RoomID uint            `json:"r"`
+	Seq    uint64          `json:"s,omitempty"`
+	Data   json.RawMessage `json:"d"`
+	// Ephemeral 与 Key 透传慢消费者策略所需的帧属性：可丢弃、可按 Key 合并。
+	Ephemeral bool   `json:"e,omitempty"`
+	Key       string `json:"k,omitempty"`
+	// Kick 非零时这不是房间事件，而是把该用户踢出 RoomID（为零时断开其所有连接）的指令，Data 是发给被踢用户的事件。
+	Kick uint `json:"x,omitempty"`
+}
+
+// Handler 处理从 Broker 收到的事件，需要尽快返回。
+type Handler func(Envelope)
+
+// Broker 是房间事件的发布 / 订阅通道。
+type Broker interface {
+	// Publish 把事件发布给所有订阅者（包括发布方自己所在的实例）。
+	Publish(ctx context.Context, env Envelope) error
+	// Subscribe 注册事件处理函数，返回取消订阅的函数。
+	Subscribe(h Handler) (cancel func())
+	// Close 释放底层连接，之后的 Publish 返回 ErrClosed。
+	Close() error
+}
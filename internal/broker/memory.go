@@ -0,0 +1,65 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrClosed 表示 Broker 已关闭。
+var ErrClosed = errors.New("broker closed")
+
+// Memory 是进程内的 Broker，适用于单实例部署与测试。
+type Memory struct {
+	mu       sync.RWMutex
+	handlers map[int]Handler
+	next     int
+	closed   bool
+}
+
+// NewMemory 创建进程内 Broker。
+func NewMemory() *Memory {
+	return &Memory{handlers: make(map[int]Handler)}
+}
+
+// Publish 同步调用所有订阅者。
+func (m *Memory) Publish(_ context.Context, env Envelope) error {
+	m.mu.RLock()
+	if m.closed {
+		m.mu.RUnlock()
+		return ErrClosed
+	}
+	handlers := make([]Handler, 0, len(m.handlers))
+	for _, h := range m.handlers {
+		handlers = append(handlers, h)
+	}
+	m.mu.RUnlock()
+
+	for _, h := range handlers {
+		h(env)
+	}
+	return nil
+}
+
+// Subscribe 注册事件处理函数。
+func (m *Memory) Subscribe(h Handler) func() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := m.next
+	m.next++
+	m.handlers[id] = h
+	return func() {
+		m.mu.Lock()
+		delete(m.handlers, id)
+		m.mu.Unlock()
+	}
+}
+
+// Close 关闭 Broker 并移除所有订阅者。
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	m.handlers = make(map[int]Handler)
+	return nil
+}
@@ -0,0 +1,41 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestMemory_PublishSubscribe(t *testing.T) {
+	m := NewMemory()
+	var got []Envelope
+	cancel := m.Subscribe(func(env Envelope) { got = append(got, env) })
+
+	env := Envelope{Origin: "a", RoomID: 1, Seq: 3, Data: json.RawMessage(`{"type":"message"}`)}
+	if err := m.Publish(context.Background(), env); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if len(got) != 1 || got[0].RoomID != 1 || got[0].Seq != 3 || string(got[0].Data) != `{"type":"message"}` {
+		t.Fatalf("handler got %+v, want published envelope", got)
+	}
+
+	cancel()
+	if err := m.Publish(context.Background(), env); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if len(got) != 1 {
+		t.Errorf("handler called %d times after cancel, want 1", len(got))
+	}
+}
+
+func TestMemory_Close(t *testing.T) {
+	m := NewMemory()
+	m.Subscribe(func(Envelope) { t.Error("handler called after Close") })
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := m.Publish(context.Background(), Envelope{RoomID: 1}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Publish() after Close error = %v, want ErrClosed", err)
+	}
+}
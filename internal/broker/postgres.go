@@ -0,0 +1,209 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultChannel 是未指定时使用的 NOTIFY 通道名。
+	DefaultChannel = "chatroom_events"
+	// maxNotifyPayload 略低于 Postgres 默认的 8000 字节 NOTIFY 载荷上限。
+	maxNotifyPayload = 7900
+	// payloadRetention 是溢出载荷在表中保留的时间，足够所有实例读取。
+	payloadRetention = 5 * time.Minute
+)
+
+// notification 是 NOTIFY 的载荷：要么内联事件，要么引用 broker_payloads 表中的一行。
+type notification struct {
+	Env *Envelope `json:"env,omitempty"`
+	Ref int64     `json:"ref,omitempty"`
+}
+
+// Postgres 通过 LISTEN/NOTIFY 在实例间转发事件，复用现有数据库，不需要额外的消息中间件。
+// 超过 NOTIFY 载荷上限的事件先写入 broker_payloads 表，通知里只携带行 ID。
+type Postgres struct {
+	db      *gorm.DB
+	dsn     string
+	channel string
+
+	mu       sync.RWMutex
+	handlers map[int]Handler
+	next     int
+
+	closed atomic.Bool
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewPostgres 创建 Postgres Broker：db 用于发布与读取溢出载荷，dsn 用于建立独立的 LISTEN 连接。
+func NewPostgres(db *gorm.DB, dsn, channel string) (*Postgres, error) {
+	if channel == "" {
+		channel = DefaultChannel
+	}
+	err := db.Exec(`CREATE UNLOGGED TABLE IF NOT EXISTS broker_payloads (
+		id BIGSERIAL PRIMARY KEY,
+		payload TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`).Error
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Postgres{db: db, dsn: dsn, channel: channel, handlers: make(map[int]Handler), cancel: cancel}
+	p.wg.Add(2)
+	go p.listen(ctx)
+	go p.cleanup(ctx)
+	return p, nil
+}
+
+// Publish 通过 pg_notify 发布事件，过大的事件先落表再发布引用。
+func (p *Postgres) Publish(ctx context.Context, env Envelope) error {
+	if p.closed.Load() {
+		return ErrClosed
+	}
+	payload, err := json.Marshal(notification{Env: &env})
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxNotifyPayload {
+		raw, err := json.Marshal(env)
+		if err != nil {
+			return err
+		}
+		var id int64
+		if err := p.db.WithContext(ctx).Raw(`INSERT INTO broker_payloads (payload) VALUES (?) RETURNING id`, string(raw)).Scan(&id).Error; err != nil {
+			return err
+		}
+		payload, _ = json.Marshal(notification{Ref: id})
+	}
+	return p.db.WithContext(ctx).Exec(`SELECT pg_notify(?, ?)`, p.channel, string(payload)).Error
+}
+
+// Subscribe 注册事件处理函数。
+func (p *Postgres) Subscribe(h Handler) func() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	id := p.next
+	p.next++
+	p.handlers[id] = h
+	return func() {
+		p.mu.Lock()
+		delete(p.handlers, id)
+		p.mu.Unlock()
+	}
+}
+
+// Close 停止监听并等待后台 goroutine 退出，不关闭传入的 *gorm.DB。
+func (p *Postgres) Close() error {
+	if p.closed.Swap(true) {
+		return nil
+	}
+	p.cancel()
+	p.wg.Wait()
+	return nil
+}
+
+// listen 维持一条 LISTEN 连接，断开后按指数退避重连；重连期间的事件会丢失，由客户端续传兜底。
+func (p *Postgres) listen(ctx context.Context) {
+	defer p.wg.Done()
+	backoff := time.Second
+	for {
+		connected, err := p.listenOnce(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			backoff = time.Second
+		}
+		log.Warn().Err(err).Dur("retry_in", backoff).Msg("broker listen")
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+func (p *Postgres) listenOnce(ctx context.Context) (bool, error) {
+	conn, err := pgx.Connect(ctx, p.dsn)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close(context.Background())
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{p.channel}.Sanitize()); err != nil {
+		return false, err
+	}
+	log.Info().Str("channel", p.channel).Msg("broker listening")
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return true, err
+		}
+		p.dispatch(ctx, n.Payload)
+	}
+}
+
+// dispatch 解析通知并调用所有订阅者，必要时从 broker_payloads 读取完整事件。
+func (p *Postgres) dispatch(ctx context.Context, payload string) {
+	var n notification
+	if err := json.Unmarshal([]byte(payload), &n); err != nil {
+		log.Warn().Err(err).Msg("broker decode notification")
+		return
+	}
+	env := n.Env
+	if n.Ref != 0 {
+		var raw string
+		if err := p.db.WithContext(ctx).Raw(`SELECT payload FROM broker_payloads WHERE id = ?`, n.Ref).Scan(&raw).Error; err != nil || raw == "" {
+			log.Warn().Err(err).Int64("ref", n.Ref).Msg("broker load payload")
+			return
+		}
+		env = &Envelope{}
+		if err := json.Unmarshal([]byte(raw), env); err != nil {
+			log.Warn().Err(err).Int64("ref", n.Ref).Msg("broker decode payload")
+			return
+		}
+	}
+	if env == nil {
+		return
+	}
+
+	p.mu.RLock()
+	handlers := make([]Handler, 0, len(p.handlers))
+	for _, h := range p.handlers {
+		handlers = append(handlers, h)
+	}
+	p.mu.RUnlock()
+	for _, h := range handlers {
+		h(*env)
+	}
+}
+
+// cleanup 定期删除已过期的溢出载荷。
+func (p *Postgres) cleanup(ctx context.Context) {
+	defer p.wg.Done()
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cutoff := time.Now().Add(-payloadRetention)
+			if err := p.db.WithContext(ctx).Exec(`DELETE FROM broker_payloads WHERE created_at < ?`, cutoff).Error; err != nil && ctx.Err() == nil {
+				log.Warn().Err(err).Msg("broker cleanup payloads")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"os"
 	"strconv"
+	"strings"
 )
 
 // Config 描述启动服务所需的关键参数。
@@ -14,6 +15,74 @@ type Config struct {
 	Env                   string
 	AccessTokenTTLMinutes int
 	RefreshTokenTTLDays   int
+
+	// 链接预览：是否启用以及单次抓取的超时时间。开启后服务端会抓取用户消息中的任意链接，默认关闭。
+	LinkPreviewEnabled        bool
+	LinkPreviewTimeoutSeconds int
+
+	// WSResumeMaxMessages 是断线续传最多补发的消息条数，超过则要求客户端全量同步。
+	WSResumeMaxMessages int
+	// WSMaxRoomsPerConn 是单个 WebSocket 连接最多同时订阅的房间数。
+	WSMaxRoomsPerConn int
+	// WSIdleSeconds 是连接无操作多久后视为空闲，用户所有连接都空闲时自动显示为 away。
+	WSIdleSeconds int
+	// WSSendQueueSize 是每个连接发送队列的长度。
+	WSSendQueueSize int
+	// WSAsyncPersist 开启异步批量落库：每个房间最多攒 WSPersistBatchSize 条、等待 WSPersistMaxDelayMs 毫秒后一次写入，
+	// 每个房间最多排队 WSPersistQueueSize 条。
+	WSAsyncPersist      bool
+	WSPersistBatchSize  int
+	WSPersistMaxDelayMs int
+	WSPersistQueueSize  int
+	// WSSlowConsumerPolicy 决定发送队列写满时的处理方式：disconnect、drop_oldest、drop_typing 或 coalesce。
+	WSSlowConsumerPolicy string
+	// WSCompressionEnabled 控制是否协商 permessage-deflate，客户端仍可通过 compress=false 单独关闭。
+	WSCompressionEnabled bool
+	// WSCompressionLevel 是 deflate 压缩级别（1-9），WSCompressionMinBytes 以下的帧不压缩。
+	WSCompressionLevel    int
+	WSCompressionMinBytes int
+	// WSQueryTokenEnabled 允许通过 ?token= 查询参数建立实时连接，令牌会出现在访问日志中，仅开发环境默认开启。
+	WSQueryTokenEnabled bool
+	// WSReauthLeadSeconds 是令牌过期前多久向连接下发 reauth_required。
+	WSReauthLeadSeconds int
+	// 上行帧限流：消息、输入提示与其它帧按用户分别限速（每秒速率与突发量），WSRateConn* 限制单个连接的总帧数。
+	// 按用户的额度在每个实例上单独计算，同一用户连接多个实例时总额度随实例数成倍增加。
+	// 消息或连接的速率为 0 时关闭上行限流，输入提示或其它帧的速率为 0 时该类帧不限速。
+	// 一分钟内超限 WSRateMuteAfter 次禁言 WSRateMuteSeconds 秒，被禁言超过 WSRateDisconnectAfter 次后再超限即断开。
+	WSRateMessageRPS      int
+	WSRateMessageBurst    int
+	WSRateTypingRPS       int
+	WSRateTypingBurst     int
+	WSRateOtherRPS        int
+	WSRateOtherBurst      int
+	WSRateConnRPS         int
+	WSRateConnBurst       int
+	WSRateMuteAfter       int
+	WSRateMuteSeconds     int
+	WSRateDisconnectAfter int
+	// 单个实例的实时连接上限：每个用户、每个 IP、每个房间以及全部连接，0 表示不限制。
+	WSMaxConnsPerUser int
+	WSMaxConnsPerIP   int
+	WSMaxConnsPerRoom int
+	WSMaxConns        int
+	// WSRoomIdleSeconds 是房间在本实例上没有连接后保留 RoomHub 的秒数。
+	WSRoomIdleSeconds int
+	// 停服排空：先等待 WSDrainGraceSeconds 秒让负载均衡摘除本实例，再通知连接断开并最多等待
+	// WSDrainTimeoutSeconds 秒，客户端在 WSReconnectDelaySeconds 秒加上不超过 WSReconnectJitterSeconds 秒的随机时长后重连。
+	WSDrainGraceSeconds      int
+	WSDrainTimeoutSeconds    int
+	WSReconnectDelaySeconds  int
+	WSReconnectJitterSeconds int
+
+	// BrokerDriver 选择实例间转发房间事件的方式：memory（单实例）或 postgres（LISTEN/NOTIFY）。
+	BrokerDriver string
+	// PresenceHeartbeatSeconds 是实例上报在线状态的心跳间隔，超过 3 个周期未上报的实例视为下线。
+	PresenceHeartbeatSeconds int
+
+	// ModeratorIDs 是全局管理员的用户 ID，可以处理任何房间并下达全局封禁与禁言。
+	ModeratorIDs []uint
+	// ReportHideThreshold 是自动隐藏消息所需的举报人数，0 表示不自动隐藏。
+	ReportHideThreshold int
 }
 
 func getenv(key, def string) string {
@@ -24,6 +93,43 @@ func getenv(key, def string) string {
 	return v
 }
 
+func getenvInt(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || v <= 0 {
+		return def
+	}
+	return v
+}
+
+// getenvNonNegInt 与 getenvInt 相同但保留 0，用于以 0 表示关闭或不限制的配置。
+func getenvNonNegInt(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || v < 0 {
+		return def
+	}
+	return v
+}
+
+func getenvBool(key string, def bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// getenvUintList 读取逗号分隔的正整数列表，忽略无法解析的项。
+func getenvUintList(key string) []uint {
+	var out []uint
+	for _, part := range strings.Split(os.Getenv(key), ",") {
+		v, err := strconv.ParseUint(strings.TrimSpace(part), 10, 64)
+		if err == nil && v > 0 {
+			out = append(out, uint(v))
+		}
+	}
+	return out
+}
+
 // Load 从环境变量读取配置，并为教学场景准备合理的默认值。
 func Load() Config {
 	port := getenv("APP_PORT", "8080")
@@ -47,6 +153,54 @@ func Load() Config {
 		Env:                   env,
 		AccessTokenTTLMinutes: accessTTL,
 		RefreshTokenTTLDays:   refreshTTL,
+
+		LinkPreviewEnabled:        getenvBool("LINK_PREVIEW_ENABLED", false),
+		LinkPreviewTimeoutSeconds: getenvInt("LINK_PREVIEW_TIMEOUT_SECONDS", 5),
+
+		WSResumeMaxMessages:  getenvInt("WS_RESUME_MAX_MESSAGES", 200),
+		WSMaxRoomsPerConn:    getenvInt("WS_MAX_ROOMS_PER_CONN", 50),
+		WSIdleSeconds:        getenvInt("WS_IDLE_SECONDS", 300),
+		WSSendQueueSize:      getenvInt("WS_SEND_QUEUE_SIZE", 256),
+		WSAsyncPersist:       getenvBool("WS_ASYNC_PERSIST", false),
+		WSPersistBatchSize:   getenvInt("WS_PERSIST_BATCH_SIZE", 100),
+		WSPersistMaxDelayMs:  getenvInt("WS_PERSIST_MAX_DELAY_MS", 20),
+		WSPersistQueueSize:   getenvInt("WS_PERSIST_QUEUE_SIZE", 1000),
+		WSSlowConsumerPolicy: getenv("WS_SLOW_CONSUMER_POLICY", "disconnect"),
+
+		WSCompressionEnabled:  getenvBool("WS_COMPRESSION_ENABLED", true),
+		WSCompressionLevel:    getenvInt("WS_COMPRESSION_LEVEL", 1),
+		WSCompressionMinBytes: getenvInt("WS_COMPRESSION_MIN_BYTES", 512),
+		WSQueryTokenEnabled:   getenvBool("WS_QUERY_TOKEN_ENABLED", env == "dev"),
+		WSReauthLeadSeconds:   getenvInt("WS_REAUTH_LEAD_SECONDS", 60),
+
+		WSRateMessageRPS:      getenvNonNegInt("WS_RATE_MESSAGE_RPS", 5),
+		WSRateMessageBurst:    getenvInt("WS_RATE_MESSAGE_BURST", 10),
+		WSRateTypingRPS:       getenvNonNegInt("WS_RATE_TYPING_RPS", 2),
+		WSRateTypingBurst:     getenvInt("WS_RATE_TYPING_BURST", 5),
+		WSRateOtherRPS:        getenvNonNegInt("WS_RATE_OTHER_RPS", 10),
+		WSRateOtherBurst:      getenvInt("WS_RATE_OTHER_BURST", 20),
+		WSRateConnRPS:         getenvNonNegInt("WS_RATE_CONN_RPS", 20),
+		WSRateConnBurst:       getenvInt("WS_RATE_CONN_BURST", 40),
+		WSRateMuteAfter:       getenvInt("WS_RATE_MUTE_AFTER", 5),
+		WSRateMuteSeconds:     getenvInt("WS_RATE_MUTE_SECONDS", 30),
+		WSRateDisconnectAfter: getenvInt("WS_RATE_DISCONNECT_AFTER", 3),
+
+		WSMaxConnsPerUser: getenvNonNegInt("WS_MAX_CONNS_PER_USER", 20),
+		WSMaxConnsPerIP:   getenvNonNegInt("WS_MAX_CONNS_PER_IP", 200),
+		WSMaxConnsPerRoom: getenvNonNegInt("WS_MAX_CONNS_PER_ROOM", 5000),
+		WSMaxConns:        getenvNonNegInt("WS_MAX_CONNS", 20000),
+		WSRoomIdleSeconds: getenvInt("WS_ROOM_IDLE_SECONDS", 300),
+
+		WSDrainGraceSeconds:      getenvNonNegInt("WS_DRAIN_GRACE_SECONDS", 10),
+		WSDrainTimeoutSeconds:    getenvInt("WS_DRAIN_TIMEOUT_SECONDS", 20),
+		WSReconnectDelaySeconds:  getenvInt("WS_RECONNECT_DELAY_SECONDS", 1),
+		WSReconnectJitterSeconds: getenvInt("WS_RECONNECT_JITTER_SECONDS", 5),
+
+		BrokerDriver:             getenv("BROKER_DRIVER", "memory"),
+		PresenceHeartbeatSeconds: getenvInt("PRESENCE_HEARTBEAT_SECONDS", 10),
+
+		ModeratorIDs:        getenvUintList("MODERATOR_USER_IDS"),
+		ReportHideThreshold: getenvNonNegInt("REPORT_HIDE_THRESHOLD", 3),
 	}
 }
 
@@ -60,5 +214,18 @@ func Validate(cfg Config) error {
 	if cfg.Env != "dev" && cfg.JWTSecret == "dev-secret-change-me" {
 		return errors.New("JWT_SECRET is using the default value")
 	}
+	if cfg.Env == "prod" && cfg.WSQueryTokenEnabled {
+		return errors.New("WS_QUERY_TOKEN_ENABLED must be false in production")
+	}
+	switch cfg.BrokerDriver {
+	case "", "memory", "postgres":
+	default:
+		return errors.New("BROKER_DRIVER must be memory or postgres")
+	}
+	switch cfg.WSSlowConsumerPolicy {
+	case "", "disconnect", "drop_oldest", "drop_typing", "coalesce":
+	default:
+		return errors.New("WS_SLOW_CONSUMER_POLICY must be disconnect, drop_oldest, drop_typing or coalesce")
+	}
 	return nil
 }
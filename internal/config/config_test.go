@@ -13,6 +13,7 @@ func TestLoad_Defaults(t *testing.T) {
 	os.Unsetenv("APP_ENV")
 	os.Unsetenv("ACCESS_TOKEN_TTL_MINUTES")
 	os.Unsetenv("REFRESH_TOKEN_TTL_DAYS")
+	os.Unsetenv("LINK_PREVIEW_ENABLED")
 
 	cfg := Load()
 
@@ -28,6 +29,12 @@ func TestLoad_Defaults(t *testing.T) {
 	if cfg.RefreshTokenTTLDays != 7 {
 		t.Errorf("Load() RefreshTokenTTLDays = %v, want 7", cfg.RefreshTokenTTLDays)
 	}
+	if !cfg.WSQueryTokenEnabled {
+		t.Error("Load() WSQueryTokenEnabled = false, want true in dev")
+	}
+	if cfg.LinkPreviewEnabled {
+		t.Error("Load() LinkPreviewEnabled = true, want opt-in")
+	}
 }
 
 func TestLoad_FromEnv(t *testing.T) {
@@ -37,7 +44,9 @@ func TestLoad_FromEnv(t *testing.T) {
 	os.Setenv("APP_ENV", "prod")
 	os.Setenv("ACCESS_TOKEN_TTL_MINUTES", "30")
 	os.Setenv("REFRESH_TOKEN_TTL_DAYS", "14")
+	os.Setenv("MODERATOR_USER_IDS", "3, 7,x")
 	defer func() {
+		os.Unsetenv("MODERATOR_USER_IDS")
 		os.Unsetenv("APP_PORT")
 		os.Unsetenv("DATABASE_DSN")
 		os.Unsetenv("JWT_SECRET")
@@ -66,6 +75,9 @@ func TestLoad_FromEnv(t *testing.T) {
 	if cfg.RefreshTokenTTLDays != 14 {
 		t.Errorf("Load() RefreshTokenTTLDays = %v, want 14", cfg.RefreshTokenTTLDays)
 	}
+	if len(cfg.ModeratorIDs) != 2 || cfg.ModeratorIDs[0] != 3 || cfg.ModeratorIDs[1] != 7 {
+		t.Errorf("Load() ModeratorIDs = %v, want [3 7]", cfg.ModeratorIDs)
+	}
 }
 
 func TestLoad_InvalidTTL(t *testing.T) {
@@ -87,6 +99,34 @@ func TestLoad_InvalidTTL(t *testing.T) {
 	}
 }
 
+func TestLoad_ZeroDisables(t *testing.T) {
+	os.Setenv("WS_RATE_MESSAGE_RPS", "0")
+	os.Setenv("WS_RATE_CONN_RPS", "-1")
+	os.Setenv("WS_MAX_CONNS_PER_USER", "0")
+	os.Setenv("REPORT_HIDE_THRESHOLD", "0")
+	defer func() {
+		os.Unsetenv("REPORT_HIDE_THRESHOLD")
+		os.Unsetenv("WS_MAX_CONNS_PER_USER")
+		os.Unsetenv("WS_RATE_MESSAGE_RPS")
+		os.Unsetenv("WS_RATE_CONN_RPS")
+	}()
+
+	cfg := Load()
+
+	if cfg.WSRateMessageRPS != 0 {
+		t.Errorf("Load() WSRateMessageRPS = %v, want 0", cfg.WSRateMessageRPS)
+	}
+	if cfg.WSRateConnRPS != 20 {
+		t.Errorf("Load() WSRateConnRPS = %v, want 20 (default)", cfg.WSRateConnRPS)
+	}
+	if cfg.WSMaxConnsPerUser != 0 || cfg.WSMaxConnsPerIP != 200 {
+		t.Errorf("Load() WSMaxConnsPerUser, WSMaxConnsPerIP = %v, %v, want 0, 200", cfg.WSMaxConnsPerUser, cfg.WSMaxConnsPerIP)
+	}
+	if cfg.ReportHideThreshold != 0 {
+		t.Errorf("Load() ReportHideThreshold = %v, want 0", cfg.ReportHideThreshold)
+	}
+}
+
 func TestValidate(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -153,6 +193,39 @@ func TestValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "unknown broker driver",
+			cfg: Config{
+				Port:         "8080",
+				DatabaseDSN:  "postgres://localhost/test",
+				JWTSecret:    "secret",
+				Env:          "dev",
+				BrokerDriver: "redis",
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown slow consumer policy",
+			cfg: Config{
+				Port:                 "8080",
+				DatabaseDSN:          "postgres://localhost/test",
+				JWTSecret:            "secret",
+				Env:                  "dev",
+				WSSlowConsumerPolicy: "block",
+			},
+			wantErr: true,
+		},
+		{
+			name: "query token in prod",
+			cfg: Config{
+				Port:                "8080",
+				DatabaseDSN:         "postgres://localhost/test",
+				JWTSecret:           "secret",
+				Env:                 "prod",
+				WSQueryTokenEnabled: true,
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -32,5 +32,35 @@ func Connect(dsn string) (*gorm.DB, error) {
 
 // Migrate 自动迁移教学环境涉及的全部表结构。
 func Migrate(gdb *gorm.DB) error {
-	return gdb.AutoMigrate(&models.User{}, &models.Room{}, &models.Message{}, &models.RefreshToken{})
+	if err := backfillMessageSeq(gdb); err != nil {
+		return err
+	}
+	return gdb.AutoMigrate(&models.User{}, &models.Room{}, &models.Message{}, &models.RefreshToken{}, &models.LinkPreview{}, &models.PresenceInstance{}, &models.PresenceSession{}, &models.WSTicket{}, &models.Sanction{}, &models.RoomFilter{}, &models.MessageFlag{}, &models.Report{}, &models.ReviewItem{})
+}
+
+// backfillMessageSeq 为引入房间序号之前的历史消息按 id 顺序补齐 seq，
+// 必须在 AutoMigrate 创建 (room_id, seq) 唯一索引之前执行。
+func backfillMessageSeq(gdb *gorm.DB) error {
+	m := gdb.Migrator()
+	if !m.HasTable(&models.Message{}) || m.HasColumn(&models.Message{}, "Seq") {
+		return nil
+	}
+	return gdb.Transaction(func(tx *gorm.DB) error {
+		tm := tx.Migrator()
+		if err := tm.AddColumn(&models.Message{}, "Seq"); err != nil {
+			return err
+		}
+		if !tm.HasColumn(&models.Room{}, "LastSeq") {
+			if err := tm.AddColumn(&models.Room{}, "LastSeq"); err != nil {
+				return err
+			}
+		}
+		if err := tx.Exec(`UPDATE messages SET seq = t.rn FROM (
+			SELECT id, ROW_NUMBER() OVER (PARTITION BY room_id ORDER BY id) AS rn FROM messages
+		) AS t WHERE messages.id = t.id`).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`UPDATE rooms SET last_seq = COALESCE(
+			(SELECT MAX(seq) FROM messages WHERE messages.room_id = rooms.id), 0)`).Error
+	})
 }
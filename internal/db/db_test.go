@@ -0,0 +1,65 @@
+package db
+
+import (
+	"strings"
+	"testing"
+
+	"chatroom/internal/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestMigrate_BackfillsMessageSeq(t *testing.T) {
+	gdb, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		if strings.Contains(err.Error(), "requires cgo") {
+			t.Skipf("skipping db tests in current environment: %v", err)
+		}
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+
+	// 模拟引入 seq 之前的表结构与数据。
+	legacy := []string{
+		`CREATE TABLE rooms (id integer PRIMARY KEY AUTOINCREMENT, name text NOT NULL UNIQUE, owner_id integer NOT NULL, created_at datetime, updated_at datetime)`,
+		`CREATE TABLE messages (id integer PRIMARY KEY AUTOINCREMENT, room_id integer NOT NULL, user_id integer NOT NULL, content text NOT NULL, created_at datetime)`,
+		`INSERT INTO rooms (name, owner_id) VALUES ('a', 1), ('b', 1), ('empty', 1)`,
+		`INSERT INTO messages (room_id, user_id, content) VALUES (1, 1, 'a1'), (2, 1, 'b1'), (1, 1, 'a2'), (1, 1, 'a3'), (2, 1, 'b2')`,
+	}
+	for _, stmt := range legacy {
+		if err := gdb.Exec(stmt).Error; err != nil {
+			t.Fatalf("legacy schema: %v", err)
+		}
+	}
+
+	if err := Migrate(gdb); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+
+	var msgs []models.Message
+	if err := gdb.Order("id asc").Find(&msgs).Error; err != nil {
+		t.Fatalf("load messages: %v", err)
+	}
+	wantSeq := map[string]uint64{"a1": 1, "a2": 2, "a3": 3, "b1": 1, "b2": 2}
+	for _, m := range msgs {
+		if m.Seq != wantSeq[m.Content] {
+			t.Errorf("message %q seq = %d, want %d", m.Content, m.Seq, wantSeq[m.Content])
+		}
+	}
+
+	var rooms []models.Room
+	if err := gdb.Order("id asc").Find(&rooms).Error; err != nil {
+		t.Fatalf("load rooms: %v", err)
+	}
+	wantLast := []uint64{3, 2, 0}
+	for i, r := range rooms {
+		if r.LastSeq != wantLast[i] {
+			t.Errorf("room %q last_seq = %d, want %d", r.Name, r.LastSeq, wantLast[i])
+		}
+	}
+
+	// 再次迁移应当是幂等的。
+	if err := Migrate(gdb); err != nil {
+		t.Fatalf("second Migrate() error = %v", err)
+	}
+}
@@ -0,0 +1,476 @@
+// Package filter 实现消息内容过滤链：屏蔽词、正则规则、链接黑白名单、提及数量与重复字符。
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Action 是过滤器命中后的处理方式。
+type Action string
+
+const (
+	// Allow 放行消息；正则规则命中 allow 时跳过后续过滤器。
+	Allow Action = "allow"
+	// Rewrite 改写消息内容后继续执行后续过滤器。
+	Rewrite Action = "rewrite"
+	// Flag 放行消息，同时标记为待审核。
+	Flag Action = "flag"
+	// Reject 拒绝消息。
+	Reject Action = "reject"
+)
+
+// ErrInvalidConfig 表示过滤配置无法编译，例如正则语法错误或未知的处理方式。
+var ErrInvalidConfig = errors.New("invalid filter config")
+
+// 配置数量上限，避免单个房间的过滤链过长拖慢发送。
+const (
+	MaxWords = 1000
+	MaxRules = 50
+	MaxHosts = 200
+)
+
+// Result 是单个过滤器的结果，Content 仅在 Rewrite 时有效；
+// Action 为 Allow 且 Reason 非空表示白名单规则命中，过滤链跳过剩余的过滤器。
+type Result struct {
+	Action  Action
+	Content string
+	Reason  string
+}
+
+// Filter 检查一条消息内容。
+type Filter interface {
+	Name() string
+	Apply(content string) Result
+}
+
+// Hit 记录一次 flag 命中。
+type Hit struct {
+	Filter string
+	Reason string
+}
+
+// Verdict 是整条过滤链的结果。Action 为 Reject 时 Filter 与 Reason 说明被哪个过滤器拒绝；
+// 其余情况下 Content 是改写后的内容，Flags 是所有标记待审核的命中。
+type Verdict struct {
+	Action  Action
+	Content string
+	Filter  string
+	Reason  string
+	Flags   []Hit
+}
+
+// Chain 按顺序执行一组过滤器，零值表示不过滤。
+type Chain struct {
+	filters []Filter
+}
+
+// NewChain 用给定的过滤器创建过滤链。
+func NewChain(filters ...Filter) *Chain {
+	return &Chain{filters: filters}
+}
+
+// Len 返回过滤器数量。
+func (c *Chain) Len() int {
+	if c == nil {
+		return 0
+	}
+	return len(c.filters)
+}
+
+// Run 依次执行过滤器：rewrite 改写后继续，flag 记录后继续，reject 立即停止，allow 跳过剩余过滤器。
+func (c *Chain) Run(content string) Verdict {
+	v := Verdict{Action: Allow, Content: content}
+	if c == nil {
+		return v
+	}
+	for _, f := range c.filters {
+		r := f.Apply(v.Content)
+		switch r.Action {
+		case Reject:
+			return Verdict{Action: Reject, Content: v.Content, Filter: f.Name(), Reason: r.Reason}
+		case Rewrite:
+			v.Content = r.Content
+			if v.Action == Allow {
+				v.Action = Rewrite
+			}
+		case Flag:
+			v.Flags = append(v.Flags, Hit{Filter: f.Name(), Reason: r.Reason})
+		case Allow:
+			if r.Reason != "" {
+				return finish(v)
+			}
+		}
+	}
+	return finish(v)
+}
+
+func finish(v Verdict) Verdict {
+	if len(v.Flags) > 0 {
+		v.Action = Flag
+	}
+	return v
+}
+
+// Config 是可序列化的过滤配置，每个房间一份，零值表示不过滤。
+type Config struct {
+	Blocklist   *BlocklistConfig `json:"blocklist,omitempty"`
+	Rules       []RuleConfig     `json:"rules,omitempty"`
+	Links       *LinksConfig     `json:"links,omitempty"`
+	MaxMentions *MentionsConfig  `json:"max_mentions,omitempty"`
+	Repeat      *RepeatConfig    `json:"repeat,omitempty"`
+}
+
+// BlocklistConfig 配置屏蔽词；Action 为 rewrite 时用星号遮盖命中的词。
+type BlocklistConfig struct {
+	Words  []string `json:"words"`
+	Action Action   `json:"action"`
+}
+
+// RuleConfig 是一条正则规则；Action 为 rewrite 时用 Replace 替换匹配的部分。
+type RuleConfig struct {
+	Pattern string `json:"pattern"`
+	Action  Action `json:"action"`
+	Replace string `json:"replace,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// LinksConfig 配置链接域名的黑白名单，域名同时匹配其子域名；Allow 非空时只允许名单内的域名。
+type LinksConfig struct {
+	Allow  []string `json:"allow,omitempty"`
+	Deny   []string `json:"deny,omitempty"`
+	Action Action   `json:"action"`
+}
+
+// MentionsConfig 限制一条消息中 @ 提及的数量。
+type MentionsConfig struct {
+	Max    int    `json:"max"`
+	Action Action `json:"action"`
+}
+
+// RepeatConfig 限制同一字符连续出现的次数；Action 为 rewrite 时把过长的重复压缩到 Max 个。
+type RepeatConfig struct {
+	Max    int    `json:"max"`
+	Action Action `json:"action"`
+}
+
+// Compile 校验配置并生成过滤链。执行顺序固定为：重复字符、屏蔽词、正则规则、链接、提及数量，
+// 先压缩刷屏字符可以让后续过滤器看到规整的内容。
+func Compile(cfg Config) (*Chain, error) {
+	var filters []Filter
+	if r := cfg.Repeat; r != nil {
+		if r.Max < 1 {
+			return nil, fmt.Errorf("%w: repeat max must be positive", ErrInvalidConfig)
+		}
+		if err := checkAction(r.Action, Rewrite, Flag, Reject); err != nil {
+			return nil, err
+		}
+		filters = append(filters, repeatFilter{max: r.Max, action: r.Action})
+	}
+	if b := cfg.Blocklist; b != nil {
+		if len(b.Words) > MaxWords {
+			return nil, fmt.Errorf("%w: too many blocked words", ErrInvalidConfig)
+		}
+		if err := checkAction(b.Action, Rewrite, Flag, Reject); err != nil {
+			return nil, err
+		}
+		filters = append(filters, newBlocklist(b.Words, b.Action))
+	}
+	if len(cfg.Rules) > MaxRules {
+		return nil, fmt.Errorf("%w: too many rules", ErrInvalidConfig)
+	}
+	for i, rc := range cfg.Rules {
+		re, err := regexp.Compile(rc.Pattern)
+		if err != nil || rc.Pattern == "" {
+			return nil, fmt.Errorf("%w: rule %d: bad pattern", ErrInvalidConfig, i)
+		}
+		if err := checkAction(rc.Action, Allow, Rewrite, Flag, Reject); err != nil {
+			return nil, err
+		}
+		filters = append(filters, ruleFilter{re: re, action: rc.Action, replace: rc.Replace, reason: rc.Reason})
+	}
+	if l := cfg.Links; l != nil {
+		if len(l.Allow)+len(l.Deny) > MaxHosts {
+			return nil, fmt.Errorf("%w: too many hosts", ErrInvalidConfig)
+		}
+		if err := checkAction(l.Action, Flag, Reject); err != nil {
+			return nil, err
+		}
+		filters = append(filters, linkFilter{allow: normalizeHosts(l.Allow), deny: normalizeHosts(l.Deny), action: l.Action})
+	}
+	if m := cfg.MaxMentions; m != nil {
+		if m.Max < 0 {
+			return nil, fmt.Errorf("%w: max mentions must not be negative", ErrInvalidConfig)
+		}
+		if err := checkAction(m.Action, Flag, Reject); err != nil {
+			return nil, err
+		}
+		filters = append(filters, mentionFilter{max: m.Max, action: m.Action})
+	}
+	return NewChain(filters...), nil
+}
+
+func checkAction(a Action, allowed ...Action) error {
+	for _, x := range allowed {
+		if a == x {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unsupported action %q", ErrInvalidConfig, a)
+}
+
+// leet 把常见的替代字符还原为字母，用于屏蔽词匹配。
+var leet = map[rune]rune{'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '@': 'a', '$': 's', '!': 'i'}
+
+// normalize 把词统一为小写并还原替代字符。
+func normalize(word string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(word) {
+		if m, ok := leet[r]; ok {
+			r = m
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// squeeze 把连续重复的字符压缩为一个，用于识别 "baaad" 这类拉长写法。
+func squeeze(s string) string {
+	var b strings.Builder
+	var last rune = -1
+	for _, r := range s {
+		if r != last {
+			b.WriteRune(r)
+		}
+		last = r
+	}
+	return b.String()
+}
+
+// token 是内容中的一个词及其字节区间；bare 去掉了首尾的替代符号，"hello!" 这类词按 bare 匹配。
+type token struct {
+	start, end int
+	norm, bare string
+}
+
+func newToken(s string, start, end int) token {
+	return token{start: start, end: end, norm: normalize(s[start:end]), bare: normalize(strings.Trim(s[start:end], "@$!"))}
+}
+
+// tokenize 按字母、数字和替代字符切词。
+func tokenize(s string) []token {
+	var toks []token
+	start := -1
+	for i, r := range s {
+		inWord := unicode.IsLetter(r) || unicode.IsDigit(r) || leet[r] != 0
+		switch {
+		case inWord && start < 0:
+			start = i
+		case !inWord && start >= 0:
+			toks = append(toks, newToken(s, start, i))
+			start = -1
+		}
+	}
+	if start >= 0 {
+		toks = append(toks, newToken(s, start, len(s)))
+	}
+	return toks
+}
+
+// blocklist 按词匹配屏蔽词，忽略大小写、替代字符与拉长写法，并识别 "b a d"、"b.a.d" 这类逐字拆开的写法。
+type blocklist struct {
+	words    map[string]bool
+	squeezed map[string]bool
+	action   Action
+}
+
+func newBlocklist(words []string, action Action) blocklist {
+	b := blocklist{words: make(map[string]bool), squeezed: make(map[string]bool), action: action}
+	for _, w := range words {
+		n := normalize(strings.TrimSpace(w))
+		if n == "" {
+			continue
+		}
+		b.words[n] = true
+		b.squeezed[squeeze(n)] = true
+	}
+	return b
+}
+
+func (blocklist) Name() string { return "blocklist" }
+
+// match 先精确匹配；词中有连续重复字符时再按压缩后的形式匹配，避免屏蔽 "ass" 误伤 "as"。
+func (b blocklist) match(norm string) bool {
+	if norm == "" || b.words[norm] {
+		return norm != ""
+	}
+	sq := squeeze(norm)
+	return sq != norm && b.squeezed[sq]
+}
+
+func (b blocklist) Apply(content string) Result {
+	toks := tokenize(content)
+	var hits [][2]int
+	for i := 0; i < len(toks); i++ {
+		if b.match(toks[i].norm) || b.match(toks[i].bare) {
+			hits = append(hits, [2]int{toks[i].start, toks[i].end})
+			continue
+		}
+		// 连续的单字符词拼接后再匹配。
+		j := i
+		var joined strings.Builder
+		for j < len(toks) && utf8.RuneCountInString(toks[j].norm) == 1 && toks[j].bare != "" {
+			joined.WriteString(toks[j].norm)
+			j++
+		}
+		if j-i > 1 && b.match(joined.String()) {
+			hits = append(hits, [2]int{toks[i].start, toks[j-1].end})
+			i = j - 1
+		}
+	}
+	if len(hits) == 0 {
+		return Result{Action: Allow}
+	}
+	if b.action != Rewrite {
+		return Result{Action: b.action, Reason: "blocked word"}
+	}
+	var out strings.Builder
+	last := 0
+	for _, h := range hits {
+		out.WriteString(content[last:h[0]])
+		out.WriteString(strings.Repeat("*", utf8.RuneCountInString(content[h[0]:h[1]])))
+		last = h[1]
+	}
+	out.WriteString(content[last:])
+	return Result{Action: Rewrite, Content: out.String()}
+}
+
+// ruleFilter 是一条正则规则。
+type ruleFilter struct {
+	re      *regexp.Regexp
+	action  Action
+	replace string
+	reason  string
+}
+
+func (ruleFilter) Name() string { return "rule" }
+
+func (f ruleFilter) Apply(content string) Result {
+	if !f.re.MatchString(content) {
+		return Result{Action: Allow}
+	}
+	reason := f.reason
+	if reason == "" {
+		reason = f.re.String()
+	}
+	if f.action == Rewrite {
+		return Result{Action: Rewrite, Content: f.re.ReplaceAllString(content, f.replace)}
+	}
+	return Result{Action: f.action, Reason: reason}
+}
+
+// linkPattern 匹配带协议或以 www. 开头的链接。
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"'()]+`)
+
+// linkFilter 按域名检查消息中的链接。
+type linkFilter struct {
+	allow  []string
+	deny   []string
+	action Action
+}
+
+func (linkFilter) Name() string { return "links" }
+
+func (f linkFilter) Apply(content string) Result {
+	for _, raw := range linkPattern.FindAllString(content, -1) {
+		if !strings.Contains(raw, "://") {
+			raw = "http://" + raw
+		}
+		u, err := url.Parse(raw)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		host := strings.ToLower(u.Hostname())
+		if matchHost(host, f.deny) || (len(f.allow) > 0 && !matchHost(host, f.allow)) {
+			return Result{Action: f.action, Reason: "link to " + host}
+		}
+	}
+	return Result{Action: Allow}
+}
+
+func normalizeHosts(hosts []string) []string {
+	out := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		h = strings.Trim(strings.ToLower(strings.TrimSpace(h)), ".")
+		if h != "" {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// matchHost 判断 host 是否是列表中的域名或其子域名。
+func matchHost(host string, list []string) bool {
+	for _, d := range list {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// mentionPattern 匹配 @用户名，邮箱地址中的 @ 不算提及。
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@\w+`)
+
+// mentionFilter 限制 @ 提及的数量。
+type mentionFilter struct {
+	max    int
+	action Action
+}
+
+func (mentionFilter) Name() string { return "max_mentions" }
+
+func (f mentionFilter) Apply(content string) Result {
+	if n := len(mentionPattern.FindAllStringIndex(content, -1)); n > f.max {
+		return Result{Action: f.action, Reason: fmt.Sprintf("%d mentions", n)}
+	}
+	return Result{Action: Allow}
+}
+
+// repeatFilter 检查同一字符的连续重复，空白字符不计。
+type repeatFilter struct {
+	max    int
+	action Action
+}
+
+func (repeatFilter) Name() string { return "repeat" }
+
+func (f repeatFilter) Apply(content string) Result {
+	var out strings.Builder
+	var last rune = -1
+	run, hit := 0, false
+	for _, r := range content {
+		if r == last && !unicode.IsSpace(r) {
+			run++
+		} else {
+			last, run = r, 1
+		}
+		if run > f.max {
+			hit = true
+			continue
+		}
+		out.WriteRune(r)
+	}
+	if !hit {
+		return Result{Action: Allow}
+	}
+	if f.action == Rewrite {
+		return Result{Action: Rewrite, Content: out.String()}
+	}
+	return Result{Action: f.action, Reason: "repeated characters"}
+}
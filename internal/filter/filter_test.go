@@ -0,0 +1,121 @@
+package filter
+
+import (
+	"errors"
+	"testing"
+)
+
+func mustCompile(t *testing.T, cfg Config) *Chain {
+	t.Helper()
+	c, err := Compile(cfg)
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	return c
+}
+
+func TestBlocklist(t *testing.T) {
+	reject := mustCompile(t, Config{Blocklist: &BlocklistConfig{Words: []string{"Bad", "ass"}, Action: Reject}})
+	tests := []struct {
+		content string
+		want    Action
+	}{
+		{"hello world", Allow},
+		{"this is BAD", Reject},
+		{"b4d idea", Reject},
+		{"baaaad", Reject},
+		{"so b.a.d", Reject},
+		{"b a d", Reject},
+		{"bad!", Reject},
+		{"badge", Allow},
+		{"as far as I know", Allow},
+		{"class", Allow},
+	}
+	for _, tt := range tests {
+		if got := reject.Run(tt.content).Action; got != tt.want {
+			t.Errorf("Run(%q) = %v, want %v", tt.content, got, tt.want)
+		}
+	}
+
+	mask := mustCompile(t, Config{Blocklist: &BlocklistConfig{Words: []string{"bad"}, Action: Rewrite}})
+	v := mask.Run("Bad 你好 b.a.d ok")
+	if v.Action != Rewrite || v.Content != "*** 你好 ***** ok" {
+		t.Errorf("Run() = %+v, want masked rewrite", v)
+	}
+}
+
+func TestRules(t *testing.T) {
+	c := mustCompile(t, Config{Rules: []RuleConfig{
+		{Pattern: `^!admin`, Action: Allow},
+		{Pattern: `\d{11}`, Action: Rewrite, Replace: "[phone]"},
+		{Pattern: `(?i)free money`, Action: Reject, Reason: "scam"},
+	}})
+	if v := c.Run("call 13800138000"); v.Action != Rewrite || v.Content != "call [phone]" {
+		t.Errorf("rewrite = %+v", v)
+	}
+	if v := c.Run("FREE MONEY now"); v.Action != Reject || v.Filter != "rule" || v.Reason != "scam" {
+		t.Errorf("reject = %+v", v)
+	}
+	// allow 规则命中后不再执行后续规则。
+	if v := c.Run("!admin free money"); v.Action != Allow {
+		t.Errorf("allow = %+v", v)
+	}
+}
+
+func TestLinks(t *testing.T) {
+	deny := mustCompile(t, Config{Links: &LinksConfig{Deny: []string{"evil.com"}, Action: Reject}})
+	allow := mustCompile(t, Config{Links: &LinksConfig{Allow: []string{"example.com"}, Action: Flag}})
+	tests := []struct {
+		chain   *Chain
+		content string
+		want    Action
+	}{
+		{deny, "see https://evil.com/x", Reject},
+		{deny, "see http://cdn.EVIL.com", Reject},
+		{deny, "see www.evil.com", Reject},
+		{deny, "see https://notevil.com", Allow},
+		{deny, "evil.com without scheme", Allow},
+		{allow, "docs at https://docs.example.com/a", Allow},
+		{allow, "go to https://other.org", Flag},
+	}
+	for _, tt := range tests {
+		if got := tt.chain.Run(tt.content).Action; got != tt.want {
+			t.Errorf("Run(%q) = %v, want %v", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestMentionsAndRepeat(t *testing.T) {
+	c := mustCompile(t, Config{
+		MaxMentions: &MentionsConfig{Max: 2, Action: Flag},
+		Repeat:      &RepeatConfig{Max: 3, Action: Rewrite},
+	})
+	if v := c.Run("@a @b mail a@b.com"); v.Action != Allow {
+		t.Errorf("two mentions = %+v, want allow", v)
+	}
+	v := c.Run("@a @b @c hiiiiii")
+	if v.Action != Flag || v.Content != "@a @b @c hiii" || len(v.Flags) != 1 || v.Flags[0].Filter != "max_mentions" {
+		t.Errorf("Run() = %+v, want flagged and collapsed", v)
+	}
+	if v := c.Run("wait      what"); v.Action != Allow {
+		t.Errorf("spaces = %+v, want allow", v)
+	}
+}
+
+func TestCompile_Invalid(t *testing.T) {
+	bad := []Config{
+		{Rules: []RuleConfig{{Pattern: "(", Action: Reject}}},
+		{Rules: []RuleConfig{{Pattern: "x", Action: "drop"}}},
+		{Blocklist: &BlocklistConfig{Words: []string{"x"}, Action: Allow}},
+		{Links: &LinksConfig{Deny: []string{"x.com"}, Action: Rewrite}},
+		{Repeat: &RepeatConfig{Max: 0, Action: Reject}},
+	}
+	for i, cfg := range bad {
+		if _, err := Compile(cfg); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("case %d: Compile() error = %v, want ErrInvalidConfig", i, err)
+		}
+	}
+	if c := mustCompile(t, Config{}); c.Len() != 0 || c.Run("x").Action != Allow {
+		t.Error("empty config should not filter")
+	}
+}
@@ -0,0 +1,80 @@
+package markdown
+
+import (
+	"bytes"
+	"errors"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	ghtml "github.com/yuin/goldmark/renderer/html"
+)
+
+// 消息支持的文本格式。
+const (
+	FormatPlain    = "plain"
+	FormatMarkdown = "markdown"
+)
+
+// ErrUnsupportedFormat 表示客户端提交了未知的消息格式。
+var ErrUnsupportedFormat = errors.New("unsupported message format")
+
+var (
+	// md 不开启 WithUnsafe，原始 HTML 会被替换为注释，不会进入输出。
+	md = goldmark.New(
+		goldmark.WithExtensions(extension.GFM),
+		goldmark.WithRendererOptions(ghtml.WithHardWraps()),
+	)
+	policy = newPolicy()
+)
+
+// newPolicy 在 UGC 白名单基础上强制外链安全属性，作为渲染后的第二道防线。
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("class").Matching(bluemonday.SpaceSeparatedTokens).OnElements("code")
+	// 只为 GFM 任务列表放行 checkbox，其它类型的 input（如 password）会被去掉 type 属性。
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.RequireNoFollowOnLinks(true)
+	p.RequireNoReferrerOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	return p
+}
+
+// NormalizeFormat 将客户端传入的格式规范化，空值视为纯文本。
+func NormalizeFormat(format string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", FormatPlain:
+		return FormatPlain, nil
+	case FormatMarkdown, "md":
+		return FormatMarkdown, nil
+	default:
+		return "", ErrUnsupportedFormat
+	}
+}
+
+// Render 把消息内容渲染为经过清洗的 HTML，客户端可直接插入页面。
+func Render(format, content string) (string, error) {
+	format, err := NormalizeFormat(format)
+	if err != nil {
+		return "", err
+	}
+	if format == FormatPlain {
+		return renderPlain(content), nil
+	}
+	var buf bytes.Buffer
+	if err := md.Convert([]byte(content), &buf); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(policy.Sanitize(buf.String())), nil
+}
+
+// renderPlain 转义纯文本并保留换行，保证各端显示一致。
+func renderPlain(content string) string {
+	escaped := html.EscapeString(content)
+	return "<p>" + strings.ReplaceAll(escaped, "\n", "<br>") + "</p>"
+}
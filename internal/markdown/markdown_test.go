@@ -0,0 +1,120 @@
+package markdown
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeFormat(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"", FormatPlain, false},
+		{"plain", FormatPlain, false},
+		{"Markdown", FormatMarkdown, false},
+		{"md", FormatMarkdown, false},
+		{"html", "", true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeFormat(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NormalizeFormat(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("NormalizeFormat(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRender_Plain(t *testing.T) {
+	got, err := Render(FormatPlain, "<b>hi</b>\nthere")
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	want := "<p>&lt;b&gt;hi&lt;/b&gt;<br>there</p>"
+	if got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+}
+
+func TestRender_Markdown(t *testing.T) {
+	got, err := Render(FormatMarkdown, "**bold** and `code`")
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	for _, want := range []string{"<strong>bold</strong>", "<code>code</code>"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Render() = %q, want it to contain %q", got, want)
+		}
+	}
+}
+
+func TestRender_Sanitizes(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		forbidden []string
+	}{
+		{"script tag", "hi <script>alert(1)</script>", []string{"<script", "alert(1)</script>"}},
+		{"event handler", `<img src=x onerror="alert(1)">`, []string{"onerror"}},
+		{"javascript link", "[click](javascript:alert(1))", []string{"javascript:"}},
+		{"iframe", `<iframe src="https://evil.example"></iframe>`, []string{"<iframe"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Render(FormatMarkdown, tt.input)
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			for _, bad := range tt.forbidden {
+				if strings.Contains(got, bad) {
+					t.Errorf("Render() = %q, must not contain %q", got, bad)
+				}
+			}
+		})
+	}
+}
+
+func TestPolicy_RestrictsInputType(t *testing.T) {
+	// 原始 HTML 在 Markdown 渲染阶段就会被丢弃，这里直接检验第二道防线。
+	got := policy.Sanitize(`<input type="password" name="pw"><input type="checkbox" checked="" disabled="">`)
+	if strings.Contains(got, "password") || strings.Contains(got, "name=") {
+		t.Errorf("Sanitize() = %q, must drop non-checkbox input types", got)
+	}
+	if !strings.Contains(got, `type="checkbox"`) {
+		t.Errorf("Sanitize() = %q, want task list checkbox kept", got)
+	}
+}
+
+func TestRender_TaskList(t *testing.T) {
+	got, err := Render(FormatMarkdown, "- [x] done\n- [ ] todo")
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if !strings.Contains(got, `type="checkbox"`) {
+		t.Errorf("Render() = %q, want task list checkboxes", got)
+	}
+}
+
+func TestRender_LinksAreSafe(t *testing.T) {
+	got, err := Render(FormatMarkdown, "[docs](https://example.com)")
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if !strings.Contains(got, `href="https://example.com"`) {
+		t.Errorf("Render() = %q, want link to example.com", got)
+	}
+	if !strings.Contains(got, "nofollow") || !strings.Contains(got, "noreferrer") {
+		t.Errorf("Render() = %q, want rel=nofollow noreferrer", got)
+	}
+}
+
+func TestRender_UnsupportedFormat(t *testing.T) {
+	if _, err := Render("bbcode", "[b]x[/b]"); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Render() error = %v, want ErrUnsupportedFormat", err)
+	}
+}
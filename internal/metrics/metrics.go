@@ -13,10 +13,52 @@ var (
 		Name: "chat_ws_connections",
 		Help: "Current number of active websocket connections",
 	})
+	SseConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "chat_sse_connections",
+		Help: "Current number of active server-sent events connections",
+	})
 	WsMessagesTotal = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "chat_ws_messages_total",
 		Help: "Total number of chat messages sent",
 	})
+	WsSendQueueDepth = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chat_ws_send_queue_depth",
+		Help:    "Depth of a client's send queue when the writer drains it",
+		Buckets: []float64{0, 1, 2, 4, 8, 16, 32, 64, 128, 256, 512},
+	})
+	WsDroppedFrames = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_ws_dropped_frames_total",
+		Help: "Total number of websocket frames dropped or coalesced because of backpressure",
+	}, []string{"reason"})
+	WsSlowConsumerDisconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "chat_ws_slow_consumer_disconnects_total",
+		Help: "Total number of websocket clients disconnected for falling behind",
+	})
+	WsPersistBatchSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chat_ws_persist_batch_size",
+		Help:    "Number of chat messages written per batch by the asynchronous persister",
+		Buckets: []float64{1, 2, 4, 8, 16, 32, 64, 128},
+	})
+	WsRoomHubs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "chat_ws_room_hubs",
+		Help: "Current number of active room hubs on this instance",
+	})
+	WsConnectionsRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_ws_connections_rejected_total",
+		Help: "Total number of realtime connections or room joins rejected by connection limits, by limit (user, ip, room, global)",
+	}, []string{"limit"})
+	WsRateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_ws_rate_limited_total",
+		Help: "Total number of websocket rate limit actions by action (dropped, muted, disconnected)",
+	}, []string{"action"})
+	WsPayloadBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_ws_payload_bytes_total",
+		Help: "Websocket data frame bytes before compression",
+	}, []string{"compressed"})
+	WsWireBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_ws_wire_bytes_total",
+		Help: "Bytes written to websocket connections, including framing and handshake",
+	}, []string{"compressed"})
 	HttpRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "http_requests_total",
 		Help: "Total number of HTTP requests",
@@ -29,7 +71,7 @@ var (
 )
 
 func init() {
-	prometheus.MustRegister(WsConnections, WsMessagesTotal, HttpRequestsTotal, HttpRequestDuration)
+	prometheus.MustRegister(WsConnections, SseConnections, WsMessagesTotal, WsSendQueueDepth, WsDroppedFrames, WsSlowConsumerDisconnects, WsRoomHubs, WsPersistBatchSize, WsConnectionsRejected, WsRateLimited, WsPayloadBytes, WsWireBytes, HttpRequestsTotal, HttpRequestDuration)
 }
 
 // GinMiddleware 统计基础请求指标，供 Prometheus 拉取。
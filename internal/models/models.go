@@ -6,24 +6,58 @@ type User struct {
 	ID           uint   `gorm:"primaryKey"`
 	Username     string `gorm:"uniqueIndex;size:64;not null"`
 	PasswordHash string `gorm:"not null"`
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	// LastSeenAt 是用户最后一次在线的时间，由 presence 心跳与断开连接时更新。
+	LastSeenAt *time.Time
+	// Status 是用户手动设置的状态（available / busy / away），StatusExpiresAt 之后恢复为 available。
+	Status          string `gorm:"size:16;not null;default:available"`
+	StatusText      string `gorm:"size:128"`
+	StatusExpiresAt *time.Time
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
 }
 
 type Room struct {
-	ID        uint   `gorm:"primaryKey"`
-	Name      string `gorm:"uniqueIndex;size:128;not null"`
-	OwnerID   uint   `gorm:"not null"`
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID      uint   `gorm:"primaryKey"`
+	Name    string `gorm:"uniqueIndex;size:128;not null"`
+	OwnerID uint   `gorm:"not null"`
+	// LastSeq 记录房间内最后分配的消息序号，发送消息时在事务内自增。
+	LastSeq uint64 `gorm:"not null;default:0"`
+	// SlowModeSeconds 是普通成员两次发言的最小间隔，0 表示不限制；Announcement 为 true 时只有房主与管理员可以发言。
+	SlowModeSeconds int  `gorm:"not null;default:0"`
+	Announcement    bool `gorm:"not null;default:false"`
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
 }
 
 type Message struct {
-	ID        uint   `gorm:"primaryKey"`
-	RoomID    uint   `gorm:"index:idx_msg_room_id;not null"`
-	UserID    uint   `gorm:"index;not null"`
-	Content   string `gorm:"type:text;not null"`
+	ID     uint `gorm:"primaryKey"`
+	RoomID uint `gorm:"index:idx_msg_room_id;uniqueIndex:idx_msg_room_seq,priority:1;not null"`
+	// Seq 是房间内从 1 开始连续递增的序号，客户端据此发现并补齐缺失的消息。
+	Seq     uint64 `gorm:"uniqueIndex:idx_msg_room_seq,priority:2;not null;default:0"`
+	UserID  uint   `gorm:"index;uniqueIndex:idx_msg_user_client,priority:1;not null"`
+	Content string `gorm:"type:text;not null"`
+	// ClientMsgID 由客户端生成，同一用户内唯一，用于重试时去重；未提供时为 NULL。
+	ClientMsgID *string `gorm:"size:64;uniqueIndex:idx_msg_user_client,priority:2"`
+	// Format 为 plain 或 markdown，ContentHTML 保存服务端渲染并清洗后的 HTML。
+	Format      string `gorm:"size:16;not null;default:plain"`
+	ContentHTML string `gorm:"type:text"`
+	// Hidden 表示消息因举报过多或被管理员移除而隐藏，列表与续传中只保留占位。
+	Hidden    bool `gorm:"not null;default:false"`
 	CreatedAt time.Time
+	// Flags 是内容过滤标记的待审核记录，随消息在同一事务内写入，不对应数据库列。
+	Flags []MessageFlag `gorm:"-"`
+}
+
+// LinkPreview 保存消息中链接的预览信息，由后台异步抓取后写入。
+type LinkPreview struct {
+	ID          uint   `gorm:"primaryKey"`
+	MessageID   uint   `gorm:"index;not null"`
+	URL         string `gorm:"size:2048;not null"`
+	Title       string `gorm:"size:512"`
+	Description string `gorm:"size:1024"`
+	Image       string `gorm:"size:2048"`
+	SiteName    string `gorm:"size:256"`
+	CreatedAt   time.Time
 }
 
 type RefreshToken struct {
@@ -34,3 +68,96 @@ type RefreshToken struct {
 	RevokedAt *time.Time
 	CreatedAt time.Time
 }
+
+// PresenceInstance 记录一个服务实例的心跳，超时未更新的实例及其在线记录视为失效。
+type PresenceInstance struct {
+	ID          string    `gorm:"primaryKey;size:32"`
+	HeartbeatAt time.Time `gorm:"index;not null"`
+}
+
+// PresenceSession 表示某用户在某实例上至少有一个连接订阅了该房间，同一用户的多个标签页只占一行。
+type PresenceSession struct {
+	InstanceID string `gorm:"primaryKey;size:32"`
+	RoomID     uint   `gorm:"primaryKey;index"`
+	UserID     uint   `gorm:"primaryKey"`
+	// Idle 表示该用户在此实例上的所有连接都已空闲。
+	Idle      bool `gorm:"not null;default:false"`
+	CreatedAt time.Time
+}
+
+// WSTicket 是建立 WebSocket 连接用的一次性票据，只保存票据的 SHA-256 摘要。
+// RoomID 非零时票据只能用于该房间；SessionExpiresAt 是签发票据的访问令牌的过期时间，
+// 通过票据建立的连接到期后同样需要续期。
+type WSTicket struct {
+	ID               string `gorm:"primaryKey;size:64"`
+	UserID           uint   `gorm:"index;not null"`
+	RoomID           uint
+	ExpiresAt        time.Time `gorm:"index;not null"`
+	SessionExpiresAt *time.Time
+	CreatedAt        time.Time
+}
+
+// Sanction 是对用户的封禁（ban）或禁言（mute）记录。RoomID 为零表示全局生效，
+// ExpiresAt 为空表示永久有效，撤销后 RevokedAt 非空。
+type Sanction struct {
+	ID        uint   `gorm:"primaryKey"`
+	Kind      string `gorm:"size:8;not null;index:idx_sanction_lookup,priority:3"`
+	UserID    uint   `gorm:"not null;index:idx_sanction_lookup,priority:1"`
+	RoomID    uint   `gorm:"not null;default:0;index:idx_sanction_lookup,priority:2"`
+	Reason    string `gorm:"size:256"`
+	CreatedBy uint   `gorm:"not null"`
+	ExpiresAt *time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+// RoomFilter 保存房间的消息过滤配置（filter.Config 的 JSON），RoomID 为零是未单独配置的房间使用的默认配置。
+type RoomFilter struct {
+	RoomID    uint   `gorm:"primaryKey;autoIncrement:false"`
+	Config    string `gorm:"type:text;not null"`
+	UpdatedBy uint
+	UpdatedAt time.Time
+}
+
+// MessageFlag 记录被内容过滤标记为待审核的消息及命中的过滤器。
+type MessageFlag struct {
+	ID        uint   `gorm:"primaryKey"`
+	MessageID uint   `gorm:"index;not null"`
+	RoomID    uint   `gorm:"index;not null"`
+	UserID    uint   `gorm:"not null"`
+	Filter    string `gorm:"size:32;not null"`
+	Reason    string `gorm:"size:256"`
+	CreatedAt time.Time
+}
+
+// Report 是用户对消息的举报，同一用户对同一条消息只能举报一次。
+type Report struct {
+	ID         uint   `gorm:"primaryKey"`
+	MessageID  uint   `gorm:"uniqueIndex:idx_report_message_reporter,priority:1;not null"`
+	ReporterID uint   `gorm:"uniqueIndex:idx_report_message_reporter,priority:2;not null"`
+	RoomID     uint   `gorm:"index;not null"`
+	Category   string `gorm:"size:16;not null"`
+	Detail     string `gorm:"size:256"`
+	CreatedAt  time.Time
+}
+
+// ReviewItem 是审核队列中的一条消息，汇总用户举报与内容过滤标记。
+// Status 依次为 open、claimed、resolved；处理时下达的处罚记录在 SanctionID。
+// 已处理的条目保留为历史，同一消息之后再被举报或标记时新建条目；部分唯一索引保证每条消息最多一个未处理条目。
+type ReviewItem struct {
+	ID         uint   `gorm:"primaryKey"`
+	MessageID  uint   `gorm:"index;uniqueIndex:idx_review_items_pending,where:status <> 'resolved';not null"`
+	RoomID     uint   `gorm:"index:idx_review_queue,priority:1;not null"`
+	Status     string `gorm:"size:16;not null;index:idx_review_queue,priority:2"`
+	Reports    int    `gorm:"not null;default:0"`
+	Flags      int    `gorm:"not null;default:0"`
+	ClaimedBy  uint
+	ClaimedAt  *time.Time
+	ResolvedBy uint
+	ResolvedAt *time.Time
+	Resolution string `gorm:"size:16"`
+	Note       string `gorm:"size:256"`
+	SanctionID *uint
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
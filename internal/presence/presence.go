@@ -0,0 +1,440 @@
+// Package presence 在数据库中汇总各实例的在线用户，提供集群范围的在线人数与成员列表。
+package presence
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"chatroom/internal/models"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 对外展示的在线状态。
+const (
+	StatusOnline  = "online"
+	StatusBusy    = "busy"
+	StatusAway    = "away"
+	StatusOffline = "offline"
+)
+
+// 用户可以手动设置的状态。
+const (
+	ManualAvailable = "available"
+	ManualBusy      = "busy"
+	ManualAway      = "away"
+)
+
+// Member 是房间在线成员；Idle 表示该用户的所有连接都已空闲。
+type Member struct {
+	UserID          uint       `json:"user_id"`
+	Username        string     `json:"username"`
+	Status          string     `json:"status"`
+	StatusText      string     `json:"status_text,omitempty"`
+	StatusExpiresAt *time.Time `json:"status_expires_at,omitempty"`
+	Idle            bool       `json:"idle"`
+	LastSeenAt      *time.Time `json:"last_seen_at,omitempty"`
+}
+
+// UserStatus 是用户手动设置的状态。
+type UserStatus struct {
+	Status    string
+	Text      string
+	ExpiresAt *time.Time
+}
+
+// Effective 计算对外展示的状态：手动状态过期后视为 available，available 且所有连接空闲时显示为 away。
+func (s UserStatus) Effective(idle bool, now time.Time) (status, text string, expiresAt *time.Time) {
+	if s.ExpiresAt != nil && !now.Before(*s.ExpiresAt) {
+		s = UserStatus{Status: ManualAvailable}
+	}
+	switch s.Status {
+	case ManualBusy:
+		status = StatusBusy
+	case ManualAway:
+		status = StatusAway
+	default:
+		status = StatusOnline
+		if idle {
+			status = StatusAway
+		}
+	}
+	return status, s.Text, s.ExpiresAt
+}
+
+// Apply 用手动状态与空闲标记填充成员的展示状态。
+func (m *Member) Apply(s UserStatus, now time.Time) {
+	m.Status, m.StatusText, m.StatusExpiresAt = s.Effective(m.Idle, now)
+}
+
+// Options 配置心跳间隔，未设置时使用 10 秒；实例超过 3 个心跳周期未更新即视为下线。
+type Options struct {
+	Heartbeat time.Duration
+}
+
+type key struct {
+	roomID uint
+	userID uint
+}
+
+// Tracker 维护当前实例的在线集合，并周期性同步到 presence_sessions 表。
+// Join / Leave 只修改内存并触发一次后台同步，不会阻塞调用方。
+type Tracker struct {
+	db        *gorm.DB
+	id        string
+	heartbeat time.Duration
+	ttl       time.Duration
+
+	mu         sync.Mutex
+	local      map[key]bool
+	synced     map[key]bool
+	idle       map[uint]bool
+	syncedIdle map[uint]bool
+	// syncMu 保证同一时间只有一次同步，SetIdle 会在调用方 goroutine 中同步执行。
+	syncMu sync.Mutex
+
+	kick     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewTracker 创建 Tracker，需要调用 Start 才会开始同步。
+func NewTracker(db *gorm.DB, opts Options) *Tracker {
+	if opts.Heartbeat <= 0 {
+		opts.Heartbeat = 10 * time.Second
+	}
+	buf := make([]byte, 8)
+	_, _ = rand.Read(buf)
+	return &Tracker{
+		db:         db,
+		id:         hex.EncodeToString(buf),
+		heartbeat:  opts.Heartbeat,
+		ttl:        3 * opts.Heartbeat,
+		local:      make(map[key]bool),
+		synced:     make(map[key]bool),
+		idle:       make(map[uint]bool),
+		syncedIdle: make(map[uint]bool),
+		kick:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// Start 启动后台心跳与同步。
+func (t *Tracker) Start() {
+	t.sync(true)
+	go t.loop()
+}
+
+// Stop 停止心跳并删除当前实例的在线记录，之后本实例的用户立即显示为离线。
+func (t *Tracker) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.stop)
+		<-t.done
+
+		t.mu.Lock()
+		users := usersOf(t.local)
+		t.mu.Unlock()
+		t.touchUsers(users)
+		if err := t.db.Where("instance_id = ?", t.id).Delete(&models.PresenceSession{}).Error; err != nil {
+			log.Warn().Err(err).Msg("presence clear sessions")
+		}
+		if err := t.db.Delete(&models.PresenceInstance{ID: t.id}).Error; err != nil {
+			log.Warn().Err(err).Msg("presence clear instance")
+		}
+	})
+}
+
+// Join 标记用户在房间内在线，nil Tracker 上调用无效果。
+func (t *Tracker) Join(roomID, userID uint) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.local[key{roomID, userID}] = true
+	t.mu.Unlock()
+	t.trigger()
+}
+
+// Leave 标记用户离开房间，nil Tracker 上调用无效果。
+func (t *Tracker) Leave(roomID, userID uint) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	delete(t.local, key{roomID, userID})
+	t.mu.Unlock()
+	t.trigger()
+}
+
+// SetIdle 记录用户在本实例上的所有连接是否空闲，并立即同步到数据库，
+// 以便随后的 AllIdle 能看到最新结果。
+func (t *Tracker) SetIdle(userID uint, idle bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if idle {
+		t.idle[userID] = true
+	} else {
+		delete(t.idle, userID)
+	}
+	t.mu.Unlock()
+	t.sync(false)
+}
+
+func (t *Tracker) trigger() {
+	select {
+	case t.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (t *Tracker) loop() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.sync(true)
+		case <-t.kick:
+			t.sync(false)
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// sync 把内存中的在线集合与数据库对齐；heartbeat 为 true 时顺带刷新心跳并清理失效实例。
+func (t *Tracker) sync(heartbeat bool) {
+	t.syncMu.Lock()
+	defer t.syncMu.Unlock()
+	now := time.Now().UTC()
+	if heartbeat {
+		inst := models.PresenceInstance{ID: t.id, HeartbeatAt: now}
+		err := t.db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"heartbeat_at"}),
+		}).Create(&inst).Error
+		if err != nil {
+			log.Warn().Err(err).Msg("presence heartbeat")
+			return
+		}
+	}
+
+	t.mu.Lock()
+	var added, removed []key
+	for k := range t.local {
+		if !t.synced[k] {
+			added = append(added, k)
+		}
+	}
+	for k := range t.synced {
+		if !t.local[k] {
+			removed = append(removed, k)
+		}
+	}
+	present := usersOf(t.local)
+	idle := make(map[uint]bool, len(t.idle))
+	for id := range t.idle {
+		idle[id] = true
+	}
+	t.mu.Unlock()
+
+	if len(added) > 0 {
+		rows := make([]models.PresenceSession, 0, len(added))
+		for _, k := range added {
+			rows = append(rows, models.PresenceSession{InstanceID: t.id, RoomID: k.roomID, UserID: k.userID, Idle: idle[k.userID]})
+		}
+		if err := t.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error; err != nil {
+			log.Warn().Err(err).Msg("presence add sessions")
+			added = nil
+		}
+	}
+	var gone []uint
+	for _, k := range removed {
+		err := t.db.Where("instance_id = ? AND room_id = ? AND user_id = ?", t.id, k.roomID, k.userID).Delete(&models.PresenceSession{}).Error
+		if err != nil {
+			log.Warn().Err(err).Msg("presence remove session")
+			continue
+		}
+		gone = append(gone, k.userID)
+		t.mu.Lock()
+		delete(t.synced, k)
+		t.mu.Unlock()
+	}
+	t.mu.Lock()
+	for _, k := range added {
+		t.synced[k] = true
+	}
+	t.mu.Unlock()
+
+	// 空闲标记变化的用户整体更新其在本实例上的所有记录。
+	for _, id := range present {
+		if idle[id] == t.syncedIdle[id] {
+			continue
+		}
+		err := t.db.Model(&models.PresenceSession{}).Where("instance_id = ? AND user_id = ?", t.id, id).Update("idle", idle[id]).Error
+		if err != nil {
+			log.Warn().Err(err).Msg("presence update idle")
+			continue
+		}
+		if idle[id] {
+			t.syncedIdle[id] = true
+		} else {
+			delete(t.syncedIdle, id)
+		}
+	}
+	for _, id := range gone {
+		if !t.hasUser(id) {
+			delete(t.syncedIdle, id)
+		}
+	}
+
+	if heartbeat {
+		t.touchUsers(append(present, gone...))
+		t.pruneDead(now)
+	} else {
+		t.touchUsers(gone)
+	}
+}
+
+func (t *Tracker) hasUser(userID uint) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for k := range t.local {
+		if k.userID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// touchUsers 刷新用户的最后在线时间。
+func (t *Tracker) touchUsers(ids []uint) {
+	if len(ids) == 0 {
+		return
+	}
+	if err := t.db.Model(&models.User{}).Where("id IN ?", ids).Update("last_seen_at", time.Now().UTC()).Error; err != nil {
+		log.Warn().Err(err).Msg("presence touch users")
+	}
+}
+
+// pruneDead 删除心跳超时实例的在线记录，任一存活实例都可以执行。
+func (t *Tracker) pruneDead(now time.Time) {
+	var dead []string
+	if err := t.db.Model(&models.PresenceInstance{}).Where("heartbeat_at < ?", now.Add(-t.ttl)).Pluck("id", &dead).Error; err != nil || len(dead) == 0 {
+		return
+	}
+	if err := t.db.Where("instance_id IN ?", dead).Delete(&models.PresenceSession{}).Error; err != nil {
+		log.Warn().Err(err).Msg("presence prune sessions")
+		return
+	}
+	if err := t.db.Where("id IN ?", dead).Delete(&models.PresenceInstance{}).Error; err != nil {
+		log.Warn().Err(err).Msg("presence prune instances")
+		return
+	}
+	log.Info().Strs("instances", dead).Msg("presence pruned dead instances")
+}
+
+// liveSessions 返回只包含存活实例在线记录的查询。
+func (t *Tracker) liveSessions() *gorm.DB {
+	return t.db.Table("presence_sessions AS s").
+		Joins("JOIN presence_instances AS i ON i.id = s.instance_id").
+		Where("i.heartbeat_at >= ?", time.Now().UTC().Add(-t.ttl))
+}
+
+// OnlineCounts 返回各房间在整个集群内的在线用户数（按用户去重）。
+func (t *Tracker) OnlineCounts(roomIDs []uint) (map[uint]int, error) {
+	out := make(map[uint]int, len(roomIDs))
+	if len(roomIDs) == 0 {
+		return out, nil
+	}
+	var rows []struct {
+		RoomID uint
+		N      int
+	}
+	err := t.liveSessions().
+		Select("s.room_id AS room_id, COUNT(DISTINCT s.user_id) AS n").
+		Where("s.room_id IN ?", roomIDs).
+		Group("s.room_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		out[r.RoomID] = r.N
+	}
+	return out, nil
+}
+
+// Members 返回房间在整个集群内的在线成员，按用户名排序；Status 需要调用方结合手动状态填充。
+// 用户在任一实例上仍有活跃连接时不视为空闲。
+func (t *Tracker) Members(roomID uint) ([]Member, error) {
+	var rows []struct {
+		UserID     uint
+		Username   string
+		LastSeenAt *time.Time
+		Active     int
+	}
+	err := t.liveSessions().
+		Select("u.id AS user_id, u.username AS username, u.last_seen_at AS last_seen_at, "+
+			"MAX(CASE WHEN s.idle THEN 0 ELSE 1 END) AS active").
+		Joins("JOIN users AS u ON u.id = s.user_id").
+		Where("s.room_id = ?", roomID).
+		Group("u.id, u.username, u.last_seen_at").
+		Order("u.username").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Member, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, Member{UserID: r.UserID, Username: r.Username, Status: StatusOnline, Idle: r.Active == 0, LastSeenAt: r.LastSeenAt})
+	}
+	return out, nil
+}
+
+// AllIdle 报告用户在所有存活实例上的连接是否都已空闲；用户不在线时返回 false。
+func (t *Tracker) AllIdle(userID uint) (bool, error) {
+	var row struct {
+		Total  int
+		Active int
+	}
+	err := t.liveSessions().
+		Select("COUNT(*) AS total, COALESCE(SUM(CASE WHEN s.idle THEN 0 ELSE 1 END), 0) AS active").
+		Where("s.user_id = ?", userID).
+		Scan(&row).Error
+	if err != nil {
+		return false, err
+	}
+	return row.Total > 0 && row.Active == 0, nil
+}
+
+// RoomsOf 返回用户在整个集群内所在的房间。
+func (t *Tracker) RoomsOf(userID uint) ([]uint, error) {
+	var ids []uint
+	err := t.liveSessions().
+		Distinct("s.room_id").
+		Where("s.user_id = ?", userID).
+		Pluck("s.room_id", &ids).Error
+	return ids, err
+}
+
+func usersOf(set map[key]bool) []uint {
+	seen := make(map[uint]bool, len(set))
+	ids := make([]uint, 0, len(set))
+	for k := range set {
+		if !seen[k.userID] {
+			seen[k.userID] = true
+			ids = append(ids, k.userID)
+		}
+	}
+	return ids
+}
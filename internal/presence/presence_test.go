@@ -0,0 +1,157 @@
+package presence
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"chatroom/internal/db"
+	"chatroom/internal/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	gdb, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		if strings.Contains(err.Error(), "requires cgo") {
+			t.Skipf("skipping presence tests in current environment: %v", err)
+		}
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	if sqlDB, err := gdb.DB(); err == nil {
+		sqlDB.SetMaxOpenConns(1)
+	}
+	if err := db.Migrate(gdb); err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+	for _, name := range []string{"alice", "bob"} {
+		if err := gdb.Create(&models.User{Username: name, PasswordHash: "x"}).Error; err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+	return gdb
+}
+
+func TestTracker_AcrossInstances(t *testing.T) {
+	gdb := setupTestDB(t)
+	a := NewTracker(gdb, Options{Heartbeat: time.Minute})
+	b := NewTracker(gdb, Options{Heartbeat: time.Minute})
+
+	// alice 在两个实例上都有连接，只应计一次。
+	a.Join(1, 1)
+	b.Join(1, 1)
+	b.Join(1, 2)
+	b.Join(2, 2)
+	a.sync(true)
+	b.sync(true)
+
+	counts, err := a.OnlineCounts([]uint{1, 2, 3})
+	if err != nil {
+		t.Fatalf("OnlineCounts() error = %v", err)
+	}
+	if counts[1] != 2 || counts[2] != 1 || counts[3] != 0 {
+		t.Errorf("OnlineCounts() = %v, want 1:2 2:1 3:0", counts)
+	}
+	members, err := a.Members(1)
+	if err != nil {
+		t.Fatalf("Members() error = %v", err)
+	}
+	if len(members) != 2 || members[0].Username != "alice" || members[0].Status != StatusOnline {
+		t.Errorf("Members() = %+v, want alice and bob online", members)
+	}
+
+	// b 停止心跳后，其记录被 a 清理，只剩 a 上的 alice。
+	past := time.Now().UTC().Add(-time.Hour)
+	if err := gdb.Model(&models.PresenceInstance{}).Where("id = ?", b.id).Update("heartbeat_at", past).Error; err != nil {
+		t.Fatalf("expire instance: %v", err)
+	}
+	a.sync(true)
+	var left int64
+	gdb.Model(&models.PresenceSession{}).Where("instance_id = ?", b.id).Count(&left)
+	if left != 0 {
+		t.Errorf("dead instance sessions = %d, want 0", left)
+	}
+	if counts, _ := a.OnlineCounts([]uint{1}); counts[1] != 1 {
+		t.Errorf("OnlineCounts() after prune = %v, want 1:1", counts)
+	}
+}
+
+func TestTracker_LeaveUpdatesLastSeen(t *testing.T) {
+	gdb := setupTestDB(t)
+	tr := NewTracker(gdb, Options{Heartbeat: time.Minute})
+	tr.Join(1, 1)
+	tr.sync(true)
+	tr.Leave(1, 1)
+	tr.sync(false)
+
+	if counts, _ := tr.OnlineCounts([]uint{1}); counts[1] != 0 {
+		t.Errorf("OnlineCounts() after leave = %v, want 0", counts)
+	}
+	var user models.User
+	if err := gdb.First(&user, 1).Error; err != nil {
+		t.Fatalf("load user: %v", err)
+	}
+	if user.LastSeenAt == nil {
+		t.Error("LastSeenAt not set after leave")
+	}
+}
+
+func TestTracker_Idle(t *testing.T) {
+	gdb := setupTestDB(t)
+	a := NewTracker(gdb, Options{Heartbeat: time.Minute})
+	b := NewTracker(gdb, Options{Heartbeat: time.Minute})
+	a.Join(1, 1)
+	b.Join(1, 1)
+	a.sync(true)
+	b.sync(true)
+
+	a.SetIdle(1, true)
+	if idle, err := a.AllIdle(1); err != nil || idle {
+		t.Errorf("AllIdle() = %v, %v, want false while active on another instance", idle, err)
+	}
+	b.SetIdle(1, true)
+	if idle, _ := a.AllIdle(1); !idle {
+		t.Error("AllIdle() = false, want true when idle everywhere")
+	}
+	members, err := a.Members(1)
+	if err != nil || len(members) != 1 || !members[0].Idle {
+		t.Errorf("Members() = %+v, %v, want one idle member", members, err)
+	}
+	rooms, err := a.RoomsOf(1)
+	if err != nil || len(rooms) != 1 || rooms[0] != 1 {
+		t.Errorf("RoomsOf() = %v, %v, want [1]", rooms, err)
+	}
+}
+
+func TestUserStatus_Effective(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Minute)
+	tests := []struct {
+		name string
+		s    UserStatus
+		idle bool
+		want string
+	}{
+		{"available", UserStatus{Status: ManualAvailable}, false, StatusOnline},
+		{"available idle", UserStatus{Status: ManualAvailable}, true, StatusAway},
+		{"busy idle", UserStatus{Status: ManualBusy}, true, StatusBusy},
+		{"away", UserStatus{Status: ManualAway}, false, StatusAway},
+		{"busy expired", UserStatus{Status: ManualBusy, Text: "meeting", ExpiresAt: &past}, false, StatusOnline},
+		{"busy until later", UserStatus{Status: ManualBusy, ExpiresAt: &future}, false, StatusBusy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, text, _ := tt.s.Effective(tt.idle, now)
+			if got != tt.want {
+				t.Errorf("Effective() = %q, want %q", got, tt.want)
+			}
+			if tt.s.ExpiresAt == &past && text != "" {
+				t.Errorf("Effective() text = %q, want cleared after expiry", text)
+			}
+		})
+	}
+}
@@ -5,23 +5,36 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"chatroom/internal/auth"
+	"chatroom/internal/filter"
+	"chatroom/internal/models"
 	"chatroom/internal/service"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
 )
 
+// MessagePublisher 把通过 REST 发送的消息推送给房间内的实时连接，由 ws.Publisher 实现。
+type MessagePublisher interface {
+	PublishMessage(msg *models.Message, username string)
+}
+
 // Handler 聚合所有 HTTP handler，依赖注入 service 层。
 type Handler struct {
-	userSvc *service.UserService
-	roomSvc *service.RoomService
-	msgSvc  *service.MessageService
+	userSvc   *service.UserService
+	roomSvc   *service.RoomService
+	msgSvc    *service.MessageService
+	statusSvc *service.StatusService
+	ticketSvc *service.TicketService
+	modSvc    *service.ModerationService
+	reportSvc *service.ReportService
+	publisher MessagePublisher
 }
 
-func NewHandler(userSvc *service.UserService, roomSvc *service.RoomService, msgSvc *service.MessageService) *Handler {
-	return &Handler{userSvc: userSvc, roomSvc: roomSvc, msgSvc: msgSvc}
+func NewHandler(userSvc *service.UserService, roomSvc *service.RoomService, msgSvc *service.MessageService, statusSvc *service.StatusService, ticketSvc *service.TicketService, modSvc *service.ModerationService, reportSvc *service.ReportService, publisher MessagePublisher) *Handler {
+	return &Handler{userSvc: userSvc, roomSvc: roomSvc, msgSvc: msgSvc, statusSvc: statusSvc, ticketSvc: ticketSvc, modSvc: modSvc, reportSvc: reportSvc, publisher: publisher}
 }
 
 // Register 处理用户注册请求。
@@ -152,6 +165,26 @@ func (h *Handler) ListRooms(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"rooms": rooms})
 }
 
+// RoomPresence 处理获取房间在线成员请求。
+func (h *Handler) RoomPresence(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || roomID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid room id"})
+		return
+	}
+	p, err := h.roomSvc.Presence(uint(roomID))
+	if err != nil {
+		if errors.Is(err, service.ErrRoomNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+			return
+		}
+		log.Error().Err(err).Int("room_id", roomID).Msg("room presence")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load presence"})
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
 // ListMessages 处理获取房间消息列表请求。
 func (h *Handler) ListMessages(c *gin.Context) {
 	roomID, err := strconv.Atoi(c.Param("id"))
@@ -167,13 +200,23 @@ func (h *Handler) ListMessages(c *gin.Context) {
 	if limit <= 0 || limit > 200 {
 		limit = 50
 	}
-	var beforeID uint
+	q := service.ListQuery{Limit: limit}
 	if bid := c.Query("before_id"); bid != "" {
 		if v, err := strconv.Atoi(bid); err == nil && v > 0 {
-			beforeID = uint(v)
+			q.BeforeID = uint(v)
 		}
 	}
-	msgs, err := h.msgSvc.ListByRoom(uint(roomID), limit, beforeID)
+	if bs := c.Query("before_seq"); bs != "" {
+		if v, err := strconv.ParseUint(bs, 10, 64); err == nil {
+			q.BeforeSeq = v
+		}
+	}
+	if as := c.Query("after_seq"); as != "" {
+		if v, err := strconv.ParseUint(as, 10, 64); err == nil {
+			q.AfterSeq = v
+		}
+	}
+	msgs, err := h.msgSvc.ListByRoom(uint(roomID), q)
 	if err != nil {
 		log.Error().Err(err).Int("room_id", roomID).Msg("list messages")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list messages"})
@@ -181,3 +224,494 @@ func (h *Handler) ListMessages(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, gin.H{"messages": msgs})
 }
+
+// PostMessage 通过 REST 发送消息，供无法使用 WebSocket 的客户端配合 SSE 使用。
+// 携带 client_msg_id 的重复请求返回已有消息且不再广播。
+func (h *Handler) PostMessage(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || roomID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid room id"})
+		return
+	}
+	var req struct {
+		Content     string `json:"content"`
+		Format      string `json:"format"`
+		ClientMsgID string `json:"client_msg_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	user, _ := c.MustGet("user").(models.User)
+	if err := h.modSvc.Check(user.ID, uint(roomID), true); err != nil {
+		switch {
+		case errors.Is(err, service.ErrUserBanned):
+			c.JSON(http.StatusForbidden, gin.H{"error": "banned"})
+		case errors.Is(err, service.ErrUserMuted):
+			c.JSON(http.StatusForbidden, gin.H{"error": "muted"})
+		default:
+			log.Error().Err(err).Int("room_id", roomID).Uint("user_id", user.ID).Msg("post message check sanction")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send message"})
+		}
+		return
+	}
+	release, wait, err := h.msgSvc.Policy().Check(user.ID, uint(roomID), h.modSvc.IsModerator(user.ID))
+	if err != nil {
+		// 发言受限时，已写入消息的重发仍然照常返回。
+		if existing, ferr := h.msgSvc.FindByClientMsgID(user.ID, uint(roomID), req.ClientMsgID); ferr == nil && existing != nil {
+			h.respondMessage(c, existing, true)
+			return
+		}
+		switch {
+		case errors.Is(err, service.ErrAnnouncementOnly):
+			c.JSON(http.StatusForbidden, gin.H{"error": "announcement only"})
+		case errors.Is(err, service.ErrSlowMode):
+			secs := int((wait + time.Second - 1) / time.Second)
+			c.Header("Retry-After", strconv.Itoa(secs))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "slow mode", "retry_after": secs})
+		case errors.Is(err, service.ErrRoomNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+		default:
+			log.Error().Err(err).Int("room_id", roomID).Uint("user_id", user.ID).Msg("post message check policy")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send message"})
+		}
+		return
+	}
+	msg, duplicate, err := h.msgSvc.Create(service.CreateMessageInput{
+		RoomID:      uint(roomID),
+		UserID:      user.ID,
+		Content:     req.Content,
+		Format:      req.Format,
+		ClientMsgID: req.ClientMsgID,
+	})
+	if err != nil || duplicate {
+		release()
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrMessageEmpty):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "message empty"})
+		case errors.Is(err, service.ErrMessageTooLong):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "message too long"})
+		case errors.Is(err, service.ErrUnsupportedFormat):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported format"})
+		case errors.Is(err, service.ErrInvalidClientMsgID):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid client_msg_id"})
+		case errors.Is(err, service.ErrClientMsgIDConflict):
+			c.JSON(http.StatusConflict, gin.H{"error": "client_msg_id already used in another room"})
+		case errors.Is(err, service.ErrMessageRejected):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "message rejected"})
+		case errors.Is(err, service.ErrRoomNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+		default:
+			log.Error().Err(err).Int("room_id", roomID).Uint("user_id", user.ID).Msg("post message")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send message"})
+		}
+		return
+	}
+	if !duplicate {
+		h.publisher.PublishMessage(msg, user.Username)
+	}
+	h.respondMessage(c, msg, duplicate)
+}
+
+// respondMessage 返回 PostMessage 写入（或重发命中）的消息。
+func (h *Handler) respondMessage(c *gin.Context, msg *models.Message, duplicate bool) {
+	dto, err := h.msgSvc.ToDTO(msg)
+	if err != nil {
+		log.Error().Err(err).Uint("message_id", msg.ID).Msg("post message dto")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load message"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": dto, "duplicate": duplicate})
+}
+
+// IssueWSTicket 签发建立 WebSocket 连接用的一次性票据，可选 room_id 将票据绑定到单个房间。
+func (h *Handler) IssueWSTicket(c *gin.Context) {
+	var req struct {
+		RoomID uint `json:"room_id"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+			return
+		}
+	}
+	userID := auth.GetUserID(c)
+	dto, err := h.ticketSvc.Issue(userID, req.RoomID, auth.GetTokenExpiry(c))
+	if err != nil {
+		if errors.Is(err, service.ErrRoomNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+			return
+		}
+		if errors.Is(err, service.ErrInvalidTicket) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "token expired"})
+			return
+		}
+		log.Error().Err(err).Uint("user_id", userID).Msg("issue ws ticket")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue ticket"})
+		return
+	}
+	c.JSON(http.StatusOK, dto)
+}
+
+// GetStatus 返回当前用户的手动状态。
+func (h *Handler) GetStatus(c *gin.Context) {
+	st, err := h.statusSvc.Get(auth.GetUserID(c))
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		log.Error().Err(err).Uint("user_id", auth.GetUserID(c)).Msg("get status")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load status"})
+		return
+	}
+	c.JSON(http.StatusOK, st)
+}
+
+// SetStatus 设置当前用户的手动状态，并向其所在房间推送 presence 事件。
+func (h *Handler) SetStatus(c *gin.Context) {
+	var req struct {
+		Status     string `json:"status"`
+		StatusText string `json:"status_text"`
+		ExpiresIn  int    `json:"expires_in"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	st, err := h.statusSvc.Set(auth.GetUserID(c), service.SetStatusInput{
+		Status:    req.Status,
+		Text:      strings.TrimSpace(req.StatusText),
+		ExpiresIn: time.Duration(req.ExpiresIn) * time.Second,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidStatus):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status"})
+		case errors.Is(err, service.ErrStatusTextTooLong):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status text too long"})
+		case errors.Is(err, service.ErrInvalidStatusExpiry):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid expires_in"})
+		case errors.Is(err, service.ErrUserNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		default:
+			log.Error().Err(err).Uint("user_id", auth.GetUserID(c)).Msg("set status")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set status"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, st)
+}
+
+// KickUser 把用户踢出房间，不留下处罚记录。
+func (h *Handler) KickUser(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || roomID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid room id"})
+		return
+	}
+	var req struct {
+		UserID uint   `json:"user_id"`
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	actorID := auth.GetUserID(c)
+	if err := h.modSvc.Kick(actorID, uint(roomID), req.UserID, strings.TrimSpace(req.Reason)); err != nil {
+		moderationError(c, err, "kick user")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"kicked": true})
+}
+
+// CreateSanction 创建封禁或禁言；room_id 为零表示全局，duration 为秒数，为零表示永久。
+func (h *Handler) CreateSanction(c *gin.Context) {
+	var req struct {
+		Kind     string `json:"kind"`
+		UserID   uint   `json:"user_id"`
+		RoomID   uint   `json:"room_id"`
+		Reason   string `json:"reason"`
+		Duration int    `json:"duration"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	dto, err := h.modSvc.Apply(auth.GetUserID(c), service.SanctionInput{
+		Kind:     req.Kind,
+		UserID:   req.UserID,
+		RoomID:   req.RoomID,
+		Reason:   strings.TrimSpace(req.Reason),
+		Duration: time.Duration(req.Duration) * time.Second,
+	})
+	if err != nil {
+		moderationError(c, err, "create sanction")
+		return
+	}
+	c.JSON(http.StatusOK, dto)
+}
+
+// ListSanctions 列出房间（room_id 缺省或为零时为全局）当前生效的处罚。
+func (h *Handler) ListSanctions(c *gin.Context) {
+	var roomID uint64
+	if v := c.Query("room_id"); v != "" {
+		var err error
+		if roomID, err = strconv.ParseUint(v, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid room_id"})
+			return
+		}
+	}
+	list, err := h.modSvc.List(auth.GetUserID(c), uint(roomID))
+	if err != nil {
+		moderationError(c, err, "list sanctions")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sanctions": list})
+}
+
+// RevokeSanction 撤销一条处罚。
+func (h *Handler) RevokeSanction(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid sanction id"})
+		return
+	}
+	if err := h.modSvc.Revoke(auth.GetUserID(c), uint(id)); err != nil {
+		moderationError(c, err, "revoke sanction")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"revoked": true})
+}
+
+// filterRoomID 解析过滤配置接口的房间 ID，零表示默认配置。
+func filterRoomID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid room id"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// GetRoomFilters 返回房间实际生效的消息过滤配置，房主或管理员可见。
+func (h *Handler) GetRoomFilters(c *gin.Context) {
+	roomID, ok := filterRoomID(c)
+	if !ok {
+		return
+	}
+	if err := h.modSvc.CanManage(auth.GetUserID(c), roomID); err != nil {
+		moderationError(c, err, "get filters")
+		return
+	}
+	dto, err := h.msgSvc.Filters().Get(roomID)
+	if err != nil {
+		moderationError(c, err, "get filters")
+		return
+	}
+	c.JSON(http.StatusOK, dto)
+}
+
+// SetRoomFilters 替换房间的消息过滤配置，房间 ID 为零时修改默认配置。
+func (h *Handler) SetRoomFilters(c *gin.Context) {
+	roomID, ok := filterRoomID(c)
+	if !ok {
+		return
+	}
+	var cfg filter.Config
+	if err := c.ShouldBindJSON(&cfg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	actorID := auth.GetUserID(c)
+	if err := h.modSvc.CanManage(actorID, roomID); err != nil {
+		moderationError(c, err, "set filters")
+		return
+	}
+	if err := h.msgSvc.Filters().Set(actorID, roomID, cfg); err != nil {
+		moderationError(c, err, "set filters")
+		return
+	}
+	c.JSON(http.StatusOK, service.FilterConfigDTO{RoomID: roomID, Config: cfg})
+}
+
+// ResetRoomFilters 删除房间的单独配置，之后改用默认配置。
+func (h *Handler) ResetRoomFilters(c *gin.Context) {
+	roomID, ok := filterRoomID(c)
+	if !ok {
+		return
+	}
+	if err := h.modSvc.CanManage(auth.GetUserID(c), roomID); err != nil {
+		moderationError(c, err, "reset filters")
+		return
+	}
+	if err := h.msgSvc.Filters().Reset(roomID); err != nil {
+		moderationError(c, err, "reset filters")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"reset": true})
+}
+
+// UpdateRoomSettings 修改房间的慢速模式与公告模式，房主或管理员可用。
+func (h *Handler) UpdateRoomSettings(c *gin.Context) {
+	roomID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || roomID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid room id"})
+		return
+	}
+	var req service.RoomSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	if err := h.modSvc.CanManage(auth.GetUserID(c), uint(roomID)); err != nil {
+		moderationError(c, err, "update room settings")
+		return
+	}
+	if err := h.msgSvc.Policy().Update(uint(roomID), req); err != nil {
+		moderationError(c, err, "update room settings")
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
+
+// ReportMessage 举报一条消息，同一用户对同一条消息只能举报一次。
+func (h *Handler) ReportMessage(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+		return
+	}
+	var req struct {
+		Category string `json:"category"`
+		Detail   string `json:"detail"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	dto, err := h.reportSvc.Report(auth.GetUserID(c), uint(id), strings.TrimSpace(req.Category), strings.TrimSpace(req.Detail))
+	if err != nil {
+		moderationError(c, err, "report message")
+		return
+	}
+	c.JSON(http.StatusOK, dto)
+}
+
+// ListReviews 列出审核队列；room_id 缺省或为零时列出所有房间，仅全局管理员可用。
+func (h *Handler) ListReviews(c *gin.Context) {
+	var roomID uint64
+	if v := c.Query("room_id"); v != "" {
+		var err error
+		if roomID, err = strconv.ParseUint(v, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid room_id"})
+			return
+		}
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	items, err := h.reportSvc.Queue(auth.GetUserID(c), uint(roomID), c.Query("status"), limit)
+	if err != nil {
+		moderationError(c, err, "list reviews")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// reviewID 解析审核条目 ID。
+func reviewID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid review id"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// ClaimReview 认领审核条目。
+func (h *Handler) ClaimReview(c *gin.Context) {
+	id, ok := reviewID(c)
+	if !ok {
+		return
+	}
+	item, err := h.reportSvc.Claim(auth.GetUserID(c), id)
+	if err != nil {
+		moderationError(c, err, "claim review")
+		return
+	}
+	c.JSON(http.StatusOK, item)
+}
+
+// ResolveReview 处理审核条目，可以同时对消息作者下达处罚或关联已有处罚。
+func (h *Handler) ResolveReview(c *gin.Context) {
+	id, ok := reviewID(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Resolution string `json:"resolution"`
+		Note       string `json:"note"`
+		SanctionID uint   `json:"sanction_id"`
+		Sanction   *struct {
+			Kind     string `json:"kind"`
+			Reason   string `json:"reason"`
+			Duration int    `json:"duration"`
+			Global   bool   `json:"global"`
+		} `json:"sanction"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	in := service.ResolveInput{Resolution: req.Resolution, Note: strings.TrimSpace(req.Note), SanctionID: req.SanctionID}
+	if sa := req.Sanction; sa != nil {
+		in.Action = &service.ReviewAction{Kind: sa.Kind, Reason: strings.TrimSpace(sa.Reason), Duration: time.Duration(sa.Duration) * time.Second, Global: sa.Global}
+	}
+	item, err := h.reportSvc.Resolve(auth.GetUserID(c), id, in)
+	if err != nil {
+		moderationError(c, err, "resolve review")
+		return
+	}
+	c.JSON(http.StatusOK, item)
+}
+
+// moderationError 把管理接口的错误映射为 HTTP 响应。
+func moderationError(c *gin.Context, err error, op string) {
+	switch {
+	case errors.Is(err, service.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	case errors.Is(err, service.ErrInvalidSanction):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid sanction"})
+	case errors.Is(err, service.ErrReasonTooLong):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason too long"})
+	case errors.Is(err, service.ErrInvalidFilter):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid filter config"})
+	case errors.Is(err, service.ErrInvalidRoomSettings):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid room settings"})
+	case errors.Is(err, service.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+	case errors.Is(err, service.ErrRoomNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+	case errors.Is(err, service.ErrSanctionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "sanction not found"})
+	case errors.Is(err, service.ErrInvalidReport):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid report category"})
+	case errors.Is(err, service.ErrInvalidReview):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid review"})
+	case errors.Is(err, service.ErrMessageNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+	case errors.Is(err, service.ErrReviewNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "review item not found"})
+	case errors.Is(err, service.ErrAlreadyReported):
+		c.JSON(http.StatusConflict, gin.H{"error": "already reported"})
+	case errors.Is(err, service.ErrReviewClaimed):
+		c.JSON(http.StatusConflict, gin.H{"error": "claimed by another moderator"})
+	case errors.Is(err, service.ErrReviewResolved):
+		c.JSON(http.StatusConflict, gin.H{"error": "already resolved"})
+	default:
+		log.Error().Err(err).Uint("user_id", auth.GetUserID(c)).Msg(op)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to " + op})
+	}
+}
@@ -34,6 +34,11 @@ func SetupRouter(cfg config.Config, db *gorm.DB, hub *ws.Hub) *gin.Engine {
 	userSvc := service.NewUserService(db, cfg)
 	roomSvc := service.NewRoomService(db, hub)
 	msgSvc := service.NewMessageService(db)
+	statusSvc := service.NewStatusService(db, hub)
+	// REST、WebSocket 与 SSE 共用同一个 ModerationService，处罚变更时缓存在所有入口同时失效。
+	modSvc := service.NewModerationService(db, cfg.ModeratorIDs, hub)
+	// 链接预览抓取器同样共用，预览缓存与外部请求不随入口重复。
+	unf := ws.NewUnfurler(cfg)
 
 	r := gin.New()
 	r.Use(gin.Recovery())
@@ -74,6 +79,16 @@ func SetupRouter(cfg config.Config, db *gorm.DB, hub *ws.Hub) *gin.Engine {
 		}
 		checks["database"] = "healthy"
 
+		// 排空期间报告未就绪，让负载均衡不再把新连接路由到本实例。
+		if hub.Draining() {
+			checks["websocket"] = "draining"
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"status": "not_ready",
+				"checks": checks,
+			})
+			return
+		}
+
 		c.JSON(http.StatusOK, gin.H{
 			"status": "ready",
 			"checks": checks,
@@ -92,7 +107,8 @@ func SetupRouter(cfg config.Config, db *gorm.DB, hub *ws.Hub) *gin.Engine {
 	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
 	api := r.Group("/api/v1")
-	h := NewHandler(userSvc, roomSvc, msgSvc)
+	reportSvc := service.NewReportService(db, msgSvc, modSvc, hub, cfg.ReportHideThreshold)
+	h := NewHandler(userSvc, roomSvc, msgSvc, statusSvc, service.NewTicketService(db), modSvc, reportSvc, ws.NewPublisher(hub, db, unf))
 
 	api.POST("/auth/register", h.Register)
 	api.POST("/auth/login", h.Login)
@@ -105,13 +121,37 @@ func SetupRouter(cfg config.Config, db *gorm.DB, hub *ws.Hub) *gin.Engine {
 	authed.POST("/rooms", h.CreateRoom)
 	authed.GET("/rooms", h.ListRooms)
 	authed.GET("/rooms/:id/messages", h.ListMessages)
-
-	r.GET("/ws", ws.Serve(hub, db, cfg))
-
+	authed.POST("/rooms/:id/messages", h.PostMessage)
+	authed.GET("/rooms/:id/presence", h.RoomPresence)
+	authed.GET("/users/me/status", h.GetStatus)
+	authed.PUT("/users/me/status", h.SetStatus)
+	authed.POST("/ws/ticket", h.IssueWSTicket)
+	authed.POST("/rooms/:id/kick", h.KickUser)
+	authed.PUT("/rooms/:id/settings", h.UpdateRoomSettings)
+	authed.GET("/sanctions", h.ListSanctions)
+	authed.POST("/sanctions", h.CreateSanction)
+	authed.DELETE("/sanctions/:id", h.RevokeSanction)
+	authed.GET("/rooms/:id/filters", h.GetRoomFilters)
+	authed.PUT("/rooms/:id/filters", h.SetRoomFilters)
+	authed.DELETE("/rooms/:id/filters", h.ResetRoomFilters)
+	authed.POST("/messages/:id/report", h.ReportMessage)
+	authed.GET("/reviews", h.ListReviews)
+	authed.POST("/reviews/:id/claim", h.ClaimReview)
+	authed.POST("/reviews/:id/resolve", h.ResolveReview)
+
+	r.GET("/ws", ws.Serve(hub, db, cfg, msgSvc, modSvc, unf))
+	// SSE 与 /ws 一样自行校验凭证，浏览器的 EventSource 无法设置 Authorization 头，应使用 ticket。
+	api.GET("/rooms/:id/events", ws.ServeEvents(hub, db, cfg, msgSvc, modSvc))
+
+	// 静态资源挂在 NoRoute 上，避免通配路由与 /health 等固定路由冲突。
 	distDir := filepath.Join(".", "frontend", "dist")
 	if _, err := os.Stat(filepath.Join(distDir, "index.html")); err == nil {
-		r.GET("/*filepath", func(c *gin.Context) {
-			path := c.Param("filepath")
+		r.NoRoute(func(c *gin.Context) {
+			if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+				c.Status(http.StatusNotFound)
+				return
+			}
+			path := c.Request.URL.Path
 			if path == "" || path == "/" {
 				c.File(filepath.Join(distDir, "index.html"))
 				return
@@ -138,7 +178,14 @@ func SetupRouter(cfg config.Config, db *gorm.DB, hub *ws.Hub) *gin.Engine {
 			c.File(filepath.Join(distDir, "index.html"))
 		})
 	} else {
-		r.Static("/", "./web")
+		fileServer := http.FileServer(http.Dir("./web"))
+		r.NoRoute(func(c *gin.Context) {
+			if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+				c.Status(http.StatusNotFound)
+				return
+			}
+			fileServer.ServeHTTP(c.Writer, c.Request)
+		})
 	}
 	return r
 }
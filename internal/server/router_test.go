@@ -2,6 +2,7 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -9,6 +10,7 @@ import (
 
 	"chatroom/internal/config"
 	"chatroom/internal/models"
+	"chatroom/internal/service"
 	"chatroom/internal/ws"
 
 	"gorm.io/driver/sqlite"
@@ -25,7 +27,7 @@ func setupTestDB(t *testing.T) *gorm.DB {
 		t.Fatalf("failed to connect to test database: %v", err)
 	}
 
-	err = db.AutoMigrate(&models.User{}, &models.Room{}, &models.Message{}, &models.RefreshToken{})
+	err = db.AutoMigrate(&models.User{}, &models.Room{}, &models.Message{}, &models.RefreshToken{}, &models.LinkPreview{}, &models.PresenceInstance{}, &models.PresenceSession{}, &models.WSTicket{}, &models.Sanction{}, &models.RoomFilter{}, &models.MessageFlag{}, &models.Report{}, &models.ReviewItem{})
 	if err != nil {
 		t.Fatalf("failed to migrate test database: %v", err)
 	}
@@ -324,3 +326,325 @@ func TestMetricsEndpoint(t *testing.T) {
 		t.Error("GET /metrics should contain Go runtime metrics")
 	}
 }
+
+// doJSON 向路由发送 JSON 请求，token 非空时携带 Bearer 头。
+func doJSON(handler *http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	(*handler).ServeHTTP(w, req)
+	return w
+}
+
+// registerAndLogin 注册用户并返回其访问令牌。
+func registerAndLogin(t *testing.T, handler *http.Handler, username string) string {
+	t.Helper()
+	creds := fmt.Sprintf(`{"username":%q,"password":"testpass"}`, username)
+	doJSON(handler, http.MethodPost, "/api/v1/auth/register", "", creds)
+	login := doJSON(handler, http.MethodPost, "/api/v1/auth/login", "", creds)
+	var auth struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(login.Body.Bytes(), &auth); err != nil || auth.AccessToken == "" {
+		t.Fatalf("login: %d %s", login.Code, login.Body.String())
+	}
+	return auth.AccessToken
+}
+
+func TestPostMessageEndpoint(t *testing.T) {
+	_, handler := setupTestRouter(t)
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		return doJSON(handler, method, path, token, body)
+	}
+
+	token := registerAndLogin(t, handler, "poster")
+	if w := do(http.MethodPost, "/api/v1/rooms", token, `{"name":"rest"}`); w.Code != http.StatusOK {
+		t.Fatalf("create room: %d %s", w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		wantStatus int
+		wantDup    bool
+	}{
+		{"created", "/api/v1/rooms/1/messages", `{"content":"hello","client_msg_id":"r1"}`, http.StatusOK, false},
+		{"replayed", "/api/v1/rooms/1/messages", `{"content":"hello","client_msg_id":"r1"}`, http.StatusOK, true},
+		{"empty", "/api/v1/rooms/1/messages", `{"content":""}`, http.StatusBadRequest, false},
+		{"unknown room", "/api/v1/rooms/99/messages", `{"content":"hi"}`, http.StatusNotFound, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(http.MethodPost, tt.path, token, tt.body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body = %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if w.Code >= 300 {
+				return
+			}
+			var resp struct {
+				Message   struct{ Seq uint64 } `json:"message"`
+				Duplicate bool                 `json:"duplicate"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if resp.Duplicate != tt.wantDup || resp.Message.Seq != 1 {
+				t.Errorf("response = %+v, want duplicate %v and seq 1", resp, tt.wantDup)
+			}
+		})
+	}
+}
+
+func TestIssueWSTicketEndpoint(t *testing.T) {
+	_, handler := setupTestRouter(t)
+	token := registerAndLogin(t, handler, "ticketer")
+	if w := doJSON(handler, http.MethodPost, "/api/v1/rooms", token, `{"name":"lobby"}`); w.Code != http.StatusOK {
+		t.Fatalf("create room: %d %s", w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		body       string
+		wantStatus int
+		wantRoom   uint
+	}{
+		{"unbound", token, "", http.StatusOK, 0},
+		{"bound to room", token, `{"room_id":1}`, http.StatusOK, 1},
+		{"unknown room", token, `{"room_id":99}`, http.StatusNotFound, 0},
+		{"unauthenticated", "", "", http.StatusUnauthorized, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doJSON(handler, http.MethodPost, "/api/v1/ws/ticket", tt.token, tt.body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body = %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Ticket    string `json:"ticket"`
+				ExpiresIn int    `json:"expires_in"`
+				RoomID    uint   `json:"room_id"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if resp.Ticket == "" || resp.ExpiresIn != 30 || resp.RoomID != tt.wantRoom {
+				t.Errorf("response = %+v, want 30s ticket for room %d", resp, tt.wantRoom)
+			}
+		})
+	}
+}
+
+func TestSanctionEndpoints(t *testing.T) {
+	_, handler := setupTestRouter(t)
+	owner := registerAndLogin(t, handler, "owner")
+	member := registerAndLogin(t, handler, "member")
+	if w := doJSON(handler, http.MethodPost, "/api/v1/rooms", owner, `{"name":"lobby"}`); w.Code != http.StatusOK {
+		t.Fatalf("create room: %d %s", w.Code, w.Body.String())
+	}
+
+	steps := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		body       string
+		wantStatus int
+	}{
+		{"member cannot mute owner", http.MethodPost, "/api/v1/sanctions", member, `{"kind":"mute","user_id":1,"room_id":1}`, http.StatusForbidden},
+		{"owner cannot ban globally", http.MethodPost, "/api/v1/sanctions", owner, `{"kind":"ban","user_id":2}`, http.StatusForbidden},
+		{"invalid kind", http.MethodPost, "/api/v1/sanctions", owner, `{"kind":"shout","user_id":2,"room_id":1}`, http.StatusBadRequest},
+		{"owner mutes member", http.MethodPost, "/api/v1/sanctions", owner, `{"kind":"mute","user_id":2,"room_id":1,"reason":"spam","duration":600}`, http.StatusOK},
+		{"muted member cannot post", http.MethodPost, "/api/v1/rooms/1/messages", member, `{"content":"hi"}`, http.StatusForbidden},
+		{"member cannot list", http.MethodGet, "/api/v1/sanctions?room_id=1", member, "", http.StatusForbidden},
+		{"owner kicks member", http.MethodPost, "/api/v1/rooms/1/kick", owner, `{"user_id":2}`, http.StatusOK},
+		{"unknown sanction", http.MethodDelete, "/api/v1/sanctions/99", owner, "", http.StatusNotFound},
+		{"owner revokes mute", http.MethodDelete, "/api/v1/sanctions/1", owner, "", http.StatusOK},
+		{"member posts again", http.MethodPost, "/api/v1/rooms/1/messages", member, `{"content":"hi"}`, http.StatusOK},
+	}
+	for _, st := range steps {
+		w := doJSON(handler, st.method, st.path, st.token, st.body)
+		if w.Code != st.wantStatus {
+			t.Fatalf("%s: status = %d, want %d, body = %s", st.name, w.Code, st.wantStatus, w.Body.String())
+		}
+	}
+
+	w := doJSON(handler, http.MethodGet, "/api/v1/sanctions?room_id=1", owner, "")
+	var resp struct {
+		Sanctions []service.SanctionDTO `json:"sanctions"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(resp.Sanctions) != 0 {
+		t.Errorf("active sanctions after revoke = %+v, want none", resp.Sanctions)
+	}
+}
+
+func TestRoomFilterEndpoints(t *testing.T) {
+	_, handler := setupTestRouter(t)
+	owner := registerAndLogin(t, handler, "owner")
+	member := registerAndLogin(t, handler, "member")
+	if w := doJSON(handler, http.MethodPost, "/api/v1/rooms", owner, `{"name":"lobby"}`); w.Code != http.StatusOK {
+		t.Fatalf("create room: %d %s", w.Code, w.Body.String())
+	}
+
+	cfg := `{"blocklist":{"words":["spam"],"action":"reject"},"links":{"deny":["evil.com"],"action":"reject"}}`
+	steps := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		body       string
+		wantStatus int
+	}{
+		{"member cannot configure", http.MethodPut, "/api/v1/rooms/1/filters", member, cfg, http.StatusForbidden},
+		{"owner cannot set defaults", http.MethodPut, "/api/v1/rooms/0/filters", owner, cfg, http.StatusForbidden},
+		{"invalid action", http.MethodPut, "/api/v1/rooms/1/filters", owner, `{"repeat":{"max":3,"action":"drop"}}`, http.StatusBadRequest},
+		{"owner configures", http.MethodPut, "/api/v1/rooms/1/filters", owner, cfg, http.StatusOK},
+		{"blocked word rejected", http.MethodPost, "/api/v1/rooms/1/messages", member, `{"content":"S.P.A.M"}`, http.StatusUnprocessableEntity},
+		{"denied link rejected", http.MethodPost, "/api/v1/rooms/1/messages", member, `{"content":"https://evil.com/x"}`, http.StatusUnprocessableEntity},
+		{"clean message accepted", http.MethodPost, "/api/v1/rooms/1/messages", member, `{"content":"hello"}`, http.StatusOK},
+		{"member cannot read", http.MethodGet, "/api/v1/rooms/1/filters", member, "", http.StatusForbidden},
+		{"owner resets", http.MethodDelete, "/api/v1/rooms/1/filters", owner, "", http.StatusOK},
+		{"spam allowed after reset", http.MethodPost, "/api/v1/rooms/1/messages", member, `{"content":"spam"}`, http.StatusOK},
+	}
+	for _, st := range steps {
+		w := doJSON(handler, st.method, st.path, st.token, st.body)
+		if w.Code != st.wantStatus {
+			t.Fatalf("%s: status = %d, want %d, body = %s", st.name, w.Code, st.wantStatus, w.Body.String())
+		}
+	}
+
+	w := doJSON(handler, http.MethodGet, "/api/v1/rooms/1/filters", owner, "")
+	var resp service.FilterConfigDTO
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !resp.Inherited || resp.Config.Blocklist != nil {
+		t.Errorf("filters after reset = %+v, want inherited empty config", resp)
+	}
+}
+
+func TestReportEndpoints(t *testing.T) {
+	_, handler := setupTestRouter(t)
+	owner := registerAndLogin(t, handler, "owner")
+	author := registerAndLogin(t, handler, "author")
+	reporter := registerAndLogin(t, handler, "reporter")
+	if w := doJSON(handler, http.MethodPost, "/api/v1/rooms", owner, `{"name":"lobby"}`); w.Code != http.StatusOK {
+		t.Fatalf("create room: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(handler, http.MethodPost, "/api/v1/rooms/1/messages", author, `{"content":"rude"}`); w.Code != http.StatusOK {
+		t.Fatalf("post message: %d %s", w.Code, w.Body.String())
+	}
+
+	steps := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		body       string
+		wantStatus int
+	}{
+		{"invalid category", http.MethodPost, "/api/v1/messages/1/report", reporter, `{"category":"boring"}`, http.StatusBadRequest},
+		{"unknown message", http.MethodPost, "/api/v1/messages/9/report", reporter, `{"category":"spam"}`, http.StatusNotFound},
+		{"own message", http.MethodPost, "/api/v1/messages/1/report", author, `{"category":"spam"}`, http.StatusForbidden},
+		{"report", http.MethodPost, "/api/v1/messages/1/report", reporter, `{"category":"harassment","detail":"insult"}`, http.StatusOK},
+		{"duplicate report", http.MethodPost, "/api/v1/messages/1/report", reporter, `{"category":"spam"}`, http.StatusConflict},
+		{"member cannot view queue", http.MethodGet, "/api/v1/reviews?room_id=1", reporter, "", http.StatusForbidden},
+		{"owner cannot view all rooms", http.MethodGet, "/api/v1/reviews", owner, "", http.StatusForbidden},
+		{"invalid status", http.MethodGet, "/api/v1/reviews?room_id=1&status=done", owner, "", http.StatusBadRequest},
+		{"member cannot claim", http.MethodPost, "/api/v1/reviews/1/claim", reporter, "", http.StatusForbidden},
+		{"owner claims", http.MethodPost, "/api/v1/reviews/1/claim", owner, "", http.StatusOK},
+		{"invalid resolution", http.MethodPost, "/api/v1/reviews/1/resolve", owner, `{"resolution":"ignore"}`, http.StatusBadRequest},
+		{"owner removes and bans", http.MethodPost, "/api/v1/reviews/1/resolve", owner, `{"resolution":"removed","sanction":{"kind":"ban","reason":"abuse"}}`, http.StatusOK},
+		{"already resolved", http.MethodPost, "/api/v1/reviews/1/resolve", owner, `{"resolution":"dismissed"}`, http.StatusConflict},
+	}
+	for _, st := range steps {
+		w := doJSON(handler, st.method, st.path, st.token, st.body)
+		if w.Code != st.wantStatus {
+			t.Fatalf("%s: status = %d, want %d, body = %s", st.name, w.Code, st.wantStatus, w.Body.String())
+		}
+	}
+
+	w := doJSON(handler, http.MethodGet, "/api/v1/reviews?room_id=1&status=resolved", owner, "")
+	var resp struct {
+		Items []service.ReviewItemDTO `json:"items"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(resp.Items) != 1 || resp.Items[0].Resolution != service.ResolutionRemoved || resp.Items[0].SanctionID == nil {
+		t.Fatalf("resolved items = %+v, want one removal linked to a sanction", resp.Items)
+	}
+
+	w = doJSON(handler, http.MethodGet, "/api/v1/rooms/1/messages", owner, "")
+	var msgs struct {
+		Messages []service.MessageDTO `json:"messages"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &msgs); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(msgs.Messages) != 1 || !msgs.Messages[0].Hidden || msgs.Messages[0].Content != "" {
+		t.Errorf("messages = %+v, want removed message hidden", msgs.Messages)
+	}
+}
+
+func TestRoomSettingsEndpoints(t *testing.T) {
+	_, handler := setupTestRouter(t)
+	owner := registerAndLogin(t, handler, "owner")
+	member := registerAndLogin(t, handler, "member")
+	if w := doJSON(handler, http.MethodPost, "/api/v1/rooms", owner, `{"name":"lobby"}`); w.Code != http.StatusOK {
+		t.Fatalf("create room: %d %s", w.Code, w.Body.String())
+	}
+
+	steps := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		body       string
+		wantStatus int
+	}{
+		{"member cannot change settings", http.MethodPut, "/api/v1/rooms/1/settings", member, `{"slow_mode_seconds":10}`, http.StatusForbidden},
+		{"interval out of range", http.MethodPut, "/api/v1/rooms/1/settings", owner, `{"slow_mode_seconds":999999}`, http.StatusBadRequest},
+		{"owner enables slow mode", http.MethodPut, "/api/v1/rooms/1/settings", owner, `{"slow_mode_seconds":10}`, http.StatusOK},
+		{"rejected post does not use the slot", http.MethodPost, "/api/v1/rooms/1/messages", member, `{"content":""}`, http.StatusBadRequest},
+		{"member posts", http.MethodPost, "/api/v1/rooms/1/messages", member, `{"content":"one","client_msg_id":"m1"}`, http.StatusOK},
+		{"member too fast", http.MethodPost, "/api/v1/rooms/1/messages", member, `{"content":"two"}`, http.StatusTooManyRequests},
+		{"member retries sent message", http.MethodPost, "/api/v1/rooms/1/messages", member, `{"content":"one","client_msg_id":"m1"}`, http.StatusOK},
+		{"owner exempt", http.MethodPost, "/api/v1/rooms/1/messages", owner, `{"content":"one"}`, http.StatusOK},
+		{"owner exempt again", http.MethodPost, "/api/v1/rooms/1/messages", owner, `{"content":"two"}`, http.StatusOK},
+		{"owner enables announcement", http.MethodPut, "/api/v1/rooms/1/settings", owner, `{"announcement":true}`, http.StatusOK},
+		{"member cannot post", http.MethodPost, "/api/v1/rooms/1/messages", member, `{"content":"three"}`, http.StatusForbidden},
+		{"owner posts announcement", http.MethodPost, "/api/v1/rooms/1/messages", owner, `{"content":"news"}`, http.StatusOK},
+	}
+	for _, st := range steps {
+		w := doJSON(handler, st.method, st.path, st.token, st.body)
+		if w.Code != st.wantStatus {
+			t.Fatalf("%s: status = %d, want %d, body = %s", st.name, w.Code, st.wantStatus, w.Body.String())
+		}
+		if st.wantStatus == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "10" {
+			t.Errorf("%s: Retry-After = %q, want 10", st.name, w.Header().Get("Retry-After"))
+		}
+	}
+
+	w := doJSON(handler, http.MethodGet, "/api/v1/rooms", member, "")
+	var resp struct {
+		Rooms []service.RoomDTO `json:"rooms"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(resp.Rooms) != 1 || !resp.Rooms[0].Announcement || resp.Rooms[0].SlowModeSeconds != 0 {
+		t.Errorf("rooms = %+v, want announcement room", resp.Rooms)
+	}
+}
@@ -8,4 +8,38 @@ var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrRoomNotFound       = errors.New("room not found")
 	ErrRoomNameTaken      = errors.New("room name taken")
+
+	ErrMessageEmpty        = errors.New("message empty")
+	ErrMessageTooLong      = errors.New("message too long")
+	ErrUnsupportedFormat   = errors.New("unsupported message format")
+	ErrInvalidClientMsgID  = errors.New("invalid client message id")
+	ErrClientMsgIDConflict = errors.New("client message id used in another room")
+	ErrMessageRejected     = errors.New("message rejected")
+	ErrInvalidFilter       = errors.New("invalid filter config")
+
+	ErrUserNotFound        = errors.New("user not found")
+	ErrInvalidStatus       = errors.New("invalid status")
+	ErrStatusTextTooLong   = errors.New("status text too long")
+	ErrInvalidStatusExpiry = errors.New("invalid status expiry")
+
+	ErrInvalidTicket = errors.New("invalid ticket")
+
+	ErrForbidden        = errors.New("forbidden")
+	ErrInvalidSanction  = errors.New("invalid sanction")
+	ErrReasonTooLong    = errors.New("reason too long")
+	ErrSanctionNotFound = errors.New("sanction not found")
+	ErrUserBanned       = errors.New("user banned")
+	ErrUserMuted        = errors.New("user muted")
+
+	ErrMessageNotFound = errors.New("message not found")
+	ErrInvalidReport   = errors.New("invalid report")
+	ErrAlreadyReported = errors.New("already reported")
+	ErrInvalidReview   = errors.New("invalid review")
+	ErrReviewNotFound  = errors.New("review item not found")
+	ErrReviewClaimed   = errors.New("review item claimed by another moderator")
+	ErrReviewResolved  = errors.New("review item already resolved")
+
+	ErrInvalidRoomSettings = errors.New("invalid room settings")
+	ErrAnnouncementOnly    = errors.New("announcement room")
+	ErrSlowMode            = errors.New("slow mode")
 )
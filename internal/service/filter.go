@@ -0,0 +1,159 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"chatroom/internal/filter"
+	"chatroom/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// filterCacheTTL 是编译后过滤链的缓存时间，其他实例修改配置后最多经过这么久生效。
+const filterCacheTTL = 30 * time.Second
+
+// FilterService 管理房间的消息过滤配置，并缓存编译后的过滤链供发送消息时使用。
+// 房间没有单独配置时使用 RoomID 为零的默认配置，两者都没有时不过滤。
+type FilterService struct {
+	db     *gorm.DB
+	mu     sync.Mutex
+	chains map[uint]cachedChain
+}
+
+type cachedChain struct {
+	chain  *filter.Chain
+	expire time.Time
+}
+
+func NewFilterService(db *gorm.DB) *FilterService {
+	return &FilterService{db: db, chains: make(map[uint]cachedChain)}
+}
+
+// FilterConfigDTO 是对外输出的过滤配置；Inherited 为 true 表示房间没有单独配置，Config 是默认配置。
+type FilterConfigDTO struct {
+	RoomID    uint          `json:"room_id"`
+	Config    filter.Config `json:"config"`
+	Inherited bool          `json:"inherited"`
+}
+
+// Get 返回房间实际生效的过滤配置。
+func (s *FilterService) Get(roomID uint) (*FilterConfigDTO, error) {
+	rec, err := s.load(roomID)
+	if err != nil {
+		return nil, err
+	}
+	dto := &FilterConfigDTO{RoomID: roomID, Inherited: rec == nil || rec.RoomID != roomID}
+	if rec != nil {
+		if err := json.Unmarshal([]byte(rec.Config), &dto.Config); err != nil {
+			return nil, err
+		}
+	}
+	return dto, nil
+}
+
+// Set 校验并保存房间的过滤配置，roomID 为零时修改默认配置。调用方负责鉴权。
+func (s *FilterService) Set(actorID, roomID uint, cfg filter.Config) error {
+	if _, err := filter.Compile(cfg); err != nil {
+		return ErrInvalidFilter
+	}
+	if roomID != 0 {
+		if err := s.db.Select("id").First(&models.Room{}, roomID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrRoomNotFound
+			}
+			return err
+		}
+	}
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	rec := models.RoomFilter{RoomID: roomID, Config: string(b), UpdatedBy: actorID}
+	err = s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "room_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"config", "updated_by", "updated_at"}),
+	}).Create(&rec).Error
+	if err != nil {
+		return err
+	}
+	s.invalidate(roomID)
+	return nil
+}
+
+// Reset 删除房间的单独配置，之后改用默认配置；roomID 为零时删除默认配置。
+func (s *FilterService) Reset(roomID uint) error {
+	if err := s.db.Delete(&models.RoomFilter{}, roomID).Error; err != nil {
+		return err
+	}
+	s.invalidate(roomID)
+	return nil
+}
+
+// Apply 用房间的过滤链检查消息内容。
+func (s *FilterService) Apply(roomID uint, content string) (filter.Verdict, error) {
+	chain, err := s.chain(roomID)
+	if err != nil {
+		return filter.Verdict{}, err
+	}
+	return chain.Run(content), nil
+}
+
+// chain 返回房间的过滤链，缓存过期后重新加载。
+func (s *FilterService) chain(roomID uint) (*filter.Chain, error) {
+	now := time.Now()
+	s.mu.Lock()
+	c, ok := s.chains[roomID]
+	s.mu.Unlock()
+	if ok && now.Before(c.expire) {
+		return c.chain, nil
+	}
+	rec, err := s.load(roomID)
+	if err != nil {
+		return nil, err
+	}
+	var chain *filter.Chain
+	if rec != nil {
+		var cfg filter.Config
+		if err := json.Unmarshal([]byte(rec.Config), &cfg); err != nil {
+			return nil, err
+		}
+		if chain, err = filter.Compile(cfg); err != nil {
+			return nil, err
+		}
+	}
+	s.mu.Lock()
+	s.chains[roomID] = cachedChain{chain: chain, expire: now.Add(filterCacheTTL)}
+	s.mu.Unlock()
+	return chain, nil
+}
+
+// load 读取房间的配置，没有时回退到默认配置，两者都没有时返回 nil。
+func (s *FilterService) load(roomID uint) (*models.RoomFilter, error) {
+	var recs []models.RoomFilter
+	if err := s.db.Where("room_id IN ?", []uint{roomID, 0}).Find(&recs).Error; err != nil {
+		return nil, err
+	}
+	var fallback *models.RoomFilter
+	for i := range recs {
+		if recs[i].RoomID == roomID {
+			return &recs[i], nil
+		}
+		fallback = &recs[i]
+	}
+	return fallback, nil
+}
+
+// invalidate 丢弃缓存的过滤链；默认配置变化会影响所有房间。
+func (s *FilterService) invalidate(roomID uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if roomID == 0 {
+		s.chains = make(map[uint]cachedChain)
+		return
+	}
+	delete(s.chains, roomID)
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"chatroom/internal/filter"
+	"chatroom/internal/models"
+)
+
+func TestMessageService_Filters(t *testing.T) {
+	gdb := setupTestDB(t)
+	svc := NewMessageService(gdb)
+	lobby := createTestRoom(t, gdb, "lobby")
+	quiet := createTestRoom(t, gdb, "quiet")
+	fs := svc.Filters()
+
+	// 默认配置作用于所有未单独配置的房间。
+	if err := fs.Set(1, 0, filter.Config{Blocklist: &filter.BlocklistConfig{Words: []string{"spam"}, Action: filter.Reject}}); err != nil {
+		t.Fatalf("Set(default) error = %v", err)
+	}
+	if err := fs.Set(1, quiet, filter.Config{
+		Blocklist:   &filter.BlocklistConfig{Words: []string{"darn"}, Action: filter.Rewrite},
+		MaxMentions: &filter.MentionsConfig{Max: 1, Action: filter.Flag},
+	}); err != nil {
+		t.Fatalf("Set(room) error = %v", err)
+	}
+	if err := fs.Set(1, quiet, filter.Config{Rules: []filter.RuleConfig{{Pattern: "(", Action: filter.Reject}}}); !errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("Set(invalid) error = %v, want ErrInvalidFilter", err)
+	}
+
+	if _, _, err := svc.Create(CreateMessageInput{RoomID: lobby, UserID: 1, Content: "buy SPAM"}); !errors.Is(err, ErrMessageRejected) {
+		t.Errorf("Create(lobby) error = %v, want ErrMessageRejected", err)
+	}
+	msg, _, err := svc.Create(CreateMessageInput{RoomID: quiet, UserID: 1, Content: "spam darn @a @b"})
+	if err != nil {
+		t.Fatalf("Create(quiet) error = %v", err)
+	}
+	if msg.Content != "spam **** @a @b" || msg.Seq != 1 {
+		t.Errorf("message = %q seq %d, want rewritten with seq 1", msg.Content, msg.Seq)
+	}
+	var flags []models.MessageFlag
+	gdb.Find(&flags)
+	if len(flags) != 1 || flags[0].MessageID != msg.ID || flags[0].Filter != "max_mentions" {
+		t.Errorf("flags = %+v, want one max_mentions flag", flags)
+	}
+
+	res := svc.CreateBatch(quiet, []CreateMessageInput{
+		{UserID: 1, Content: "ok"},
+		{UserID: 1, Content: "@a @b @c"},
+	})
+	if res[0].Err != nil || res[1].Err != nil || res[1].Message.Seq != 3 {
+		t.Fatalf("CreateBatch() = %+v", res)
+	}
+	gdb.Find(&flags)
+	if len(flags) != 2 || flags[1].MessageID != res[1].Message.ID {
+		t.Errorf("flags after batch = %+v", flags)
+	}
+
+	// 重置后房间改用默认配置。
+	if err := fs.Reset(quiet); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+	dto, err := fs.Get(quiet)
+	if err != nil || !dto.Inherited || dto.Config.Blocklist == nil || dto.Config.Blocklist.Action != filter.Reject {
+		t.Errorf("Get() = %+v, %v, want inherited default", dto, err)
+	}
+	if _, _, err := svc.Create(CreateMessageInput{RoomID: quiet, UserID: 1, Content: "spam"}); !errors.Is(err, ErrMessageRejected) {
+		t.Errorf("Create() after reset error = %v, want ErrMessageRejected", err)
+	}
+}
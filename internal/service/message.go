@@ -1,46 +1,379 @@
 package service
 
 import (
+	"errors"
+	"strings"
 	"time"
+	"unicode/utf8"
 
+	"chatroom/internal/filter"
+	"chatroom/internal/markdown"
 	"chatroom/internal/models"
+	"chatroom/internal/unfurl"
 
 	"gorm.io/gorm"
 )
 
 // MessageService 封装消息相关的业务逻辑。
 type MessageService struct {
-	db *gorm.DB
+	db      *gorm.DB
+	filters *FilterService
+	policy  *PostPolicy
 }
 
 func NewMessageService(db *gorm.DB) *MessageService {
-	return &MessageService{db: db}
+	return &MessageService{db: db, filters: NewFilterService(db), policy: NewPostPolicy(db)}
+}
+
+// Policy 返回房间的发言限制（公告模式与慢速模式），WebSocket 与 REST 共用同一份发言记录。
+func (s *MessageService) Policy() *PostPolicy {
+	return s.policy
+}
+
+// Filters 返回发送消息时使用的过滤配置服务，修改配置后本实例的缓存立即失效。
+func (s *MessageService) Filters() *FilterService {
+	return s.filters
 }
 
 // MessageDTO 是对外输出的消息数据。
 type MessageDTO struct {
-	Type      string    `json:"type"`
-	ID        uint      `json:"id"`
-	RoomID    uint      `json:"room_id"`
-	UserID    uint      `json:"user_id"`
-	Username  string    `json:"username"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
+	Type        string           `json:"type"`
+	ID          uint             `json:"id"`
+	RoomID      uint             `json:"room_id"`
+	Seq         uint64           `json:"seq"`
+	UserID      uint             `json:"user_id"`
+	Username    string           `json:"username"`
+	Content     string           `json:"content"`
+	Format      string           `json:"format"`
+	ContentHTML string           `json:"content_html"`
+	ClientMsgID string           `json:"client_msg_id,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+	Previews    []unfurl.Preview `json:"previews,omitempty"`
+	// Hidden 为 true 时消息已被隐藏，内容与预览均为空，只保留 seq 占位。
+	Hidden bool `json:"hidden,omitempty"`
+}
+
+// 消息校验相关的上限。
+const (
+	MaxContentLength     = 2000
+	MaxClientMsgIDLength = 64
+)
+
+// CreateMessageInput 描述一次发送消息请求。
+type CreateMessageInput struct {
+	RoomID      uint
+	UserID      uint
+	Content     string
+	Format      string
+	ClientMsgID string
+}
+
+// Create 校验、过滤、渲染并持久化消息。
+// 携带 ClientMsgID 的重复请求不会重复写入，而是返回已有消息且 duplicate 为 true。
+func (s *MessageService) Create(in CreateMessageInput) (msg *models.Message, duplicate bool, err error) {
+	in.ClientMsgID = strings.TrimSpace(in.ClientMsgID)
+	if err := validateMessage(in); err != nil {
+		return nil, false, err
+	}
+	if in.ClientMsgID != "" {
+		existing, err := s.findByClientMsgID(in.UserID, in.RoomID, in.ClientMsgID)
+		if err != nil {
+			return nil, false, err
+		}
+		if existing != nil {
+			return existing, true, nil
+		}
+	}
+
+	m, err := s.prepare(in)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := s.insertWithSeq(m); err != nil {
+		if errors.Is(err, ErrRoomNotFound) {
+			return nil, false, err
+		}
+		// 并发重放时唯一索引冲突，此时另一请求已写入成功，直接返回那条消息。
+		if in.ClientMsgID != "" {
+			if existing, ferr := s.findByClientMsgID(in.UserID, in.RoomID, in.ClientMsgID); ferr == nil && existing != nil {
+				return existing, true, nil
+			}
+		}
+		return nil, false, err
+	}
+	return m, false, nil
+}
+
+// validateMessage 校验消息内容、格式与 ClientMsgID，调用方需先去除 ClientMsgID 首尾空白。
+func validateMessage(in CreateMessageInput) error {
+	if in.Content == "" {
+		return ErrMessageEmpty
+	}
+	if len(in.Content) > MaxContentLength {
+		return ErrMessageTooLong
+	}
+	if len(in.ClientMsgID) > MaxClientMsgIDLength {
+		return ErrInvalidClientMsgID
+	}
+	if _, err := markdown.NormalizeFormat(in.Format); err != nil {
+		return ErrUnsupportedFormat
+	}
+	return nil
+}
+
+// prepare 对已校验的输入执行房间的内容过滤，被拒绝时返回 ErrMessageRejected；
+// 改写后的内容替换原文，标记待审核的命中记录在 Message.Flags 中随消息一起写入。
+func (s *MessageService) prepare(in CreateMessageInput) (*models.Message, error) {
+	v, err := s.filters.Apply(in.RoomID, in.Content)
+	if err != nil {
+		return nil, err
+	}
+	if v.Action == filter.Reject {
+		return nil, ErrMessageRejected
+	}
+	if in.Content = v.Content; strings.TrimSpace(in.Content) == "" {
+		return nil, ErrMessageEmpty
+	}
+	if len(in.Content) > MaxContentLength {
+		return nil, ErrMessageTooLong
+	}
+	m, err := newMessage(in)
+	if err != nil {
+		return nil, err
+	}
+	for _, hit := range v.Flags {
+		m.Flags = append(m.Flags, models.MessageFlag{RoomID: in.RoomID, UserID: in.UserID, Filter: hit.Filter, Reason: truncateRunes(hit.Reason, MaxSanctionReasonLength)})
+	}
+	return m, nil
+}
+
+// truncateRunes 把字符串截断到最多 n 个字符。
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// createFlags 写入消息附带的待审核记录并放入审核队列，需在消息写入后于同一事务内调用。
+func createFlags(tx *gorm.DB, msgs ...*models.Message) error {
+	var flags []models.MessageFlag
+	for _, m := range msgs {
+		if len(m.Flags) == 0 {
+			continue
+		}
+		for _, f := range m.Flags {
+			f.MessageID = m.ID
+			flags = append(flags, f)
+		}
+		if _, err := openReview(tx, m.RoomID, m.ID, 0, len(m.Flags)); err != nil {
+			return err
+		}
+	}
+	if len(flags) == 0 {
+		return nil
+	}
+	return tx.Create(&flags).Error
+}
+
+// newMessage 渲染已校验的输入，返回尚未分配 seq 的消息。
+func newMessage(in CreateMessageInput) (*models.Message, error) {
+	format, _ := markdown.NormalizeFormat(in.Format)
+	rendered, err := markdown.Render(format, in.Content)
+	if err != nil {
+		return nil, err
+	}
+	m := &models.Message{RoomID: in.RoomID, UserID: in.UserID, Content: in.Content, Format: format, ContentHTML: rendered}
+	if in.ClientMsgID != "" {
+		m.ClientMsgID = &in.ClientMsgID
+	}
+	return m, nil
+}
+
+// CreateResult 是批量写入中单条消息的结果，字段含义与 Create 的返回值一致。
+type CreateResult struct {
+	Message   *models.Message
+	Duplicate bool
+	Err       error
+}
+
+// CreateBatch 按顺序写入同一房间的多条消息，整批在一个事务内分配连续的 seq。
+// 单条消息的校验错误只影响它自己；事务失败时逐条回退到 Create，重放冲突等问题因此只影响相关消息。
+func (s *MessageService) CreateBatch(roomID uint, ins []CreateMessageInput) []CreateResult {
+	results := make([]CreateResult, len(ins))
+	var pending []*models.Message
+	var owners []int
+	// first 记录批内每个 ClientMsgID 第一次出现的位置，之后的重复项复用它的结果。
+	first := make(map[clientKey]int)
+	dupOf := make(map[int]int)
+	for i := range ins {
+		ins[i].RoomID = roomID
+		ins[i].ClientMsgID = strings.TrimSpace(ins[i].ClientMsgID)
+		in := ins[i]
+		if err := validateMessage(in); err != nil {
+			results[i].Err = err
+			continue
+		}
+		if in.ClientMsgID != "" {
+			key := clientKey{in.UserID, in.ClientMsgID}
+			if j, ok := first[key]; ok {
+				dupOf[i] = j
+				continue
+			}
+			first[key] = i
+			existing, err := s.findByClientMsgID(in.UserID, in.RoomID, in.ClientMsgID)
+			if err != nil {
+				results[i].Err = err
+				continue
+			}
+			if existing != nil {
+				results[i] = CreateResult{Message: existing, Duplicate: true}
+				continue
+			}
+		}
+		m, err := s.prepare(in)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		pending = append(pending, m)
+		owners = append(owners, i)
+	}
+
+	if len(pending) > 0 {
+		if err := s.insertBatchWithSeq(roomID, pending); err == nil {
+			for k, m := range pending {
+				results[owners[k]].Message = m
+			}
+		} else {
+			for _, i := range owners {
+				if errors.Is(err, ErrRoomNotFound) {
+					results[i].Err = err
+					continue
+				}
+				msg, dup, err := s.Create(ins[i])
+				results[i] = CreateResult{Message: msg, Duplicate: dup, Err: err}
+			}
+		}
+	}
+	for i, j := range dupOf {
+		results[i] = CreateResult{Message: results[j].Message, Duplicate: results[j].Err == nil, Err: results[j].Err}
+	}
+	return results
 }
 
-// ListByRoom 分页查询指定房间的消息，按 id 升序返回。
-func (s *MessageService) ListByRoom(roomID uint, limit int, beforeID uint) ([]MessageDTO, error) {
+type clientKey struct {
+	userID uint
+	id     string
+}
+
+// insertBatchWithSeq 与 insertWithSeq 相同，但一次为整批消息分配连续的序号。
+func (s *MessageService) insertBatchWithSeq(roomID uint, msgs []*models.Message) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.Room{}).Where("id = ?", roomID).UpdateColumn("last_seq", gorm.Expr("last_seq + ?", len(msgs)))
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrRoomNotFound
+		}
+		var room models.Room
+		if err := tx.Select("last_seq").First(&room, roomID).Error; err != nil {
+			return err
+		}
+		base := room.LastSeq - uint64(len(msgs))
+		for i, m := range msgs {
+			m.Seq = base + uint64(i) + 1
+		}
+		if err := tx.Create(msgs).Error; err != nil {
+			return err
+		}
+		return createFlags(tx, msgs...)
+	})
+}
+
+// insertWithSeq 在同一事务内递增房间的 last_seq 并写入消息。
+// UPDATE 会持有房间行锁直到提交，多实例并发写入时序号依然唯一；事务回滚时序号一并回滚，保证不出现空洞。
+func (s *MessageService) insertWithSeq(m *models.Message) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.Room{}).Where("id = ?", m.RoomID).UpdateColumn("last_seq", gorm.Expr("last_seq + 1"))
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrRoomNotFound
+		}
+		var room models.Room
+		if err := tx.Select("last_seq").First(&room, m.RoomID).Error; err != nil {
+			return err
+		}
+		m.Seq = room.LastSeq
+		if err := tx.Create(m).Error; err != nil {
+			return err
+		}
+		return createFlags(tx, m)
+	})
+}
+
+// findByClientMsgID 查找用户以 clientMsgID 发送过的消息。client_msg_id 在同一用户内唯一，
+// 已用于其他房间时返回 ErrClientMsgIDConflict，不能把那条消息当作本房间的重发。
+func (s *MessageService) findByClientMsgID(userID, roomID uint, clientMsgID string) (*models.Message, error) {
+	var m models.Message
+	err := s.db.Where("user_id = ? AND client_msg_id = ?", userID, clientMsgID).First(&m).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.RoomID != roomID {
+		return nil, ErrClientMsgIDConflict
+	}
+	return &m, nil
+}
+
+// FindByClientMsgID 返回用户在房间内以 clientMsgID 发送过的消息，没有时返回 nil，
+// 该 ID 已用于其他房间时返回 ErrClientMsgIDConflict。发言受限时调用方据此识别重发，已写入的消息仍然照常确认。
+func (s *MessageService) FindByClientMsgID(userID, roomID uint, clientMsgID string) (*models.Message, error) {
+	clientMsgID = strings.TrimSpace(clientMsgID)
+	if clientMsgID == "" {
+		return nil, nil
+	}
+	return s.findByClientMsgID(userID, roomID, clientMsgID)
+}
+
+// ListQuery 描述历史消息的分页条件。
+// AfterSeq 用于断线后补齐缺口，按 seq 升序向后翻页；否则按 BeforeSeq / BeforeID 向前翻页。
+type ListQuery struct {
+	Limit     int
+	BeforeID  uint
+	BeforeSeq uint64
+	AfterSeq  uint64
+}
+
+// ListByRoom 分页查询指定房间的消息，按 seq 升序返回。
+func (s *MessageService) ListByRoom(roomID uint, lq ListQuery) ([]MessageDTO, error) {
+	limit := lq.Limit
 	if limit <= 0 || limit > 200 {
 		limit = 50
 	}
 
 	q := s.db.Where("room_id = ?", roomID)
-	if beforeID > 0 {
-		q = q.Where("id < ?", beforeID)
+	var msgs []models.Message
+	if lq.AfterSeq > 0 {
+		if err := q.Where("seq > ?", lq.AfterSeq).Order("seq asc").Limit(limit).Find(&msgs).Error; err != nil {
+			return nil, err
+		}
+		return s.toDTOs(msgs)
 	}
 
-	var msgs []models.Message
-	if err := q.Order("id desc").Limit(limit).Find(&msgs).Error; err != nil {
+	if lq.BeforeSeq > 0 {
+		q = q.Where("seq < ?", lq.BeforeSeq)
+	}
+	if lq.BeforeID > 0 {
+		q = q.Where("id < ?", lq.BeforeID)
+	}
+	if err := q.Order("seq desc").Limit(limit).Find(&msgs).Error; err != nil {
 		return nil, err
 	}
 
@@ -48,28 +381,88 @@ func (s *MessageService) ListByRoom(roomID uint, limit int, beforeID uint) ([]Me
 	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
 		msgs[i], msgs[j] = msgs[j], msgs[i]
 	}
+	return s.toDTOs(msgs)
+}
 
+// ListAfterID 按 seq 升序返回房间内 id 大于 afterID 的消息，供 WebSocket 断线续传使用。
+func (s *MessageService) ListAfterID(roomID, afterID uint, limit int) ([]MessageDTO, error) {
+	var msgs []models.Message
+	if err := s.db.Where("room_id = ? AND id > ?", roomID, afterID).Order("seq asc").Limit(limit).Find(&msgs).Error; err != nil {
+		return nil, err
+	}
+	return s.toDTOs(msgs)
+}
+
+// ToDTO 把单条消息转换为对外输出的格式。
+func (s *MessageService) ToDTO(m *models.Message) (*MessageDTO, error) {
+	out, err := s.toDTOs([]models.Message{*m})
+	if err != nil {
+		return nil, err
+	}
+	return &out[0], nil
+}
+
+// toDTOs 批量补齐用户名与链接预览，转换为对外输出的消息。
+func (s *MessageService) toDTOs(msgs []models.Message) ([]MessageDTO, error) {
 	// 批量获取用户名
 	usernames, err := s.resolveUsernames(msgs)
 	if err != nil {
 		return nil, err
 	}
 
+	previews, err := s.resolvePreviews(msgs)
+	if err != nil {
+		return nil, err
+	}
+
 	out := make([]MessageDTO, 0, len(msgs))
 	for _, m := range msgs {
+		if m.Hidden {
+			out = append(out, MessageDTO{Type: "message", ID: m.ID, RoomID: m.RoomID, Seq: m.Seq, UserID: m.UserID, Username: usernames[m.UserID], Format: m.Format, ClientMsgID: derefString(m.ClientMsgID), CreatedAt: m.CreatedAt, Hidden: true})
+			continue
+		}
+		format, contentHTML := renderedContent(m)
 		out = append(out, MessageDTO{
-			Type:      "message",
-			ID:        m.ID,
-			RoomID:    m.RoomID,
-			UserID:    m.UserID,
-			Username:  usernames[m.UserID],
-			Content:   m.Content,
-			CreatedAt: m.CreatedAt,
+			Type:        "message",
+			ID:          m.ID,
+			RoomID:      m.RoomID,
+			Seq:         m.Seq,
+			UserID:      m.UserID,
+			Username:    usernames[m.UserID],
+			Content:     m.Content,
+			Format:      format,
+			ContentHTML: contentHTML,
+			ClientMsgID: derefString(m.ClientMsgID),
+			CreatedAt:   m.CreatedAt,
+			Previews:    previews[m.ID],
 		})
 	}
 	return out, nil
 }
 
+// renderedContent 返回消息的格式与 HTML，兼容引入渲染之前写入的旧消息。
+func renderedContent(m models.Message) (string, string) {
+	format := m.Format
+	if format == "" {
+		format = markdown.FormatPlain
+	}
+	if m.ContentHTML != "" {
+		return format, m.ContentHTML
+	}
+	html, err := markdown.Render(format, m.Content)
+	if err != nil {
+		return markdown.FormatPlain, ""
+	}
+	return format, html
+}
+
+func derefString(p *string) string {
+	if p == nil {
+		return ""
+	}
+	return *p
+}
+
 // resolveUsernames 批量获取消息涉及的用户名。
 func (s *MessageService) resolveUsernames(msgs []models.Message) (map[uint]string, error) {
 	seen := make(map[uint]struct{}, len(msgs))
@@ -94,3 +487,29 @@ func (s *MessageService) resolveUsernames(msgs []models.Message) (map[uint]strin
 	}
 	return usernames, nil
 }
+
+// resolvePreviews 批量获取消息已抓取到的链接预览。
+func (s *MessageService) resolvePreviews(msgs []models.Message) (map[uint][]unfurl.Preview, error) {
+	out := make(map[uint][]unfurl.Preview)
+	if len(msgs) == 0 {
+		return out, nil
+	}
+	ids := make([]uint, 0, len(msgs))
+	for _, m := range msgs {
+		ids = append(ids, m.ID)
+	}
+	var rows []models.LinkPreview
+	if err := s.db.Where("message_id IN ?", ids).Order("id asc").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		out[r.MessageID] = append(out[r.MessageID], unfurl.Preview{
+			URL:         r.URL,
+			Title:       r.Title,
+			Description: r.Description,
+			Image:       r.Image,
+			SiteName:    r.SiteName,
+		})
+	}
+	return out, nil
+}
@@ -0,0 +1,260 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"chatroom/internal/db"
+	"chatroom/internal/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	gdb, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		if strings.Contains(err.Error(), "requires cgo") {
+			t.Skipf("skipping service tests in current environment: %v", err)
+		}
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	if err := db.Migrate(gdb); err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+	return gdb
+}
+
+func createTestRoom(t *testing.T, gdb *gorm.DB, name string) uint {
+	t.Helper()
+	room := models.Room{Name: name, OwnerID: 1}
+	if err := gdb.Create(&room).Error; err != nil {
+		t.Fatalf("failed to create room: %v", err)
+	}
+	return room.ID
+}
+
+func TestMessageService_Create(t *testing.T) {
+	gdb := setupTestDB(t)
+	svc := NewMessageService(gdb)
+	roomID := createTestRoom(t, gdb, "general")
+
+	msg, dup, err := svc.Create(CreateMessageInput{RoomID: roomID, UserID: 1, Content: "**hi**", Format: "markdown"})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if dup {
+		t.Error("Create() duplicate = true for first send")
+	}
+	if msg.ID == 0 {
+		t.Error("Create() returned message without id")
+	}
+	if !strings.Contains(msg.ContentHTML, "<strong>hi</strong>") {
+		t.Errorf("Create() ContentHTML = %q, want rendered markdown", msg.ContentHTML)
+	}
+	if msg.Seq != 1 {
+		t.Errorf("Create() Seq = %d, want 1", msg.Seq)
+	}
+}
+
+func TestMessageService_Create_Validation(t *testing.T) {
+	svc := NewMessageService(setupTestDB(t))
+
+	tests := []struct {
+		name string
+		in   CreateMessageInput
+		want error
+	}{
+		{"empty", CreateMessageInput{RoomID: 1, UserID: 1}, ErrMessageEmpty},
+		{"too long", CreateMessageInput{RoomID: 1, UserID: 1, Content: strings.Repeat("a", MaxContentLength+1)}, ErrMessageTooLong},
+		{"bad format", CreateMessageInput{RoomID: 1, UserID: 1, Content: "x", Format: "html"}, ErrUnsupportedFormat},
+		{"long client id", CreateMessageInput{RoomID: 1, UserID: 1, Content: "x", ClientMsgID: strings.Repeat("c", MaxClientMsgIDLength+1)}, ErrInvalidClientMsgID},
+		{"unknown room", CreateMessageInput{RoomID: 404, UserID: 1, Content: "x"}, ErrRoomNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := svc.Create(tt.in); !errors.Is(err, tt.want) {
+				t.Errorf("Create() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageService_Create_Idempotent(t *testing.T) {
+	gdb := setupTestDB(t)
+	svc := NewMessageService(gdb)
+	createTestRoom(t, gdb, "general")
+
+	in := CreateMessageInput{RoomID: 1, UserID: 7, Content: "hello", ClientMsgID: "c-1"}
+	first, dup, err := svc.Create(in)
+	if err != nil || dup {
+		t.Fatalf("first Create() = dup %v, err %v", dup, err)
+	}
+	second, dup, err := svc.Create(in)
+	if err != nil {
+		t.Fatalf("replay Create() error = %v", err)
+	}
+	if !dup {
+		t.Error("replay Create() duplicate = false, want true")
+	}
+	if second.ID != first.ID {
+		t.Errorf("replay Create() id = %d, want %d", second.ID, first.ID)
+	}
+
+	// 同一个 client_msg_id 在不同用户之间互不影响。
+	other, dup, err := svc.Create(CreateMessageInput{RoomID: 1, UserID: 8, Content: "hello", ClientMsgID: "c-1"})
+	if err != nil || dup {
+		t.Fatalf("other user Create() = dup %v, err %v", dup, err)
+	}
+	if other.ID == first.ID {
+		t.Error("other user Create() reused message id")
+	}
+
+	// 在另一个房间重用 client_msg_id 不能被当作重发，也不会写入。
+	otherRoom := createTestRoom(t, gdb, "random")
+	if msg, dup, err := svc.Create(CreateMessageInput{RoomID: otherRoom, UserID: 7, Content: "elsewhere", ClientMsgID: "c-1"}); !errors.Is(err, ErrClientMsgIDConflict) {
+		t.Errorf("Create() in another room = %+v, dup %v, err %v, want ErrClientMsgIDConflict", msg, dup, err)
+	}
+	if res := svc.CreateBatch(otherRoom, []CreateMessageInput{{UserID: 7, Content: "elsewhere", ClientMsgID: "c-1"}}); !errors.Is(res[0].Err, ErrClientMsgIDConflict) {
+		t.Errorf("CreateBatch() in another room = %+v, want ErrClientMsgIDConflict", res[0])
+	}
+	if msgs, _ := svc.ListByRoom(otherRoom, ListQuery{}); len(msgs) != 0 {
+		t.Errorf("ListByRoom(other) = %+v, want empty", msgs)
+	}
+
+	// 不带 client_msg_id 的消息不受唯一约束限制。
+	for i := 0; i < 2; i++ {
+		if _, _, err := svc.Create(CreateMessageInput{RoomID: 1, UserID: 7, Content: "plain"}); err != nil {
+			t.Fatalf("Create() without client id error = %v", err)
+		}
+	}
+
+	msgs, err := svc.ListByRoom(1, ListQuery{Limit: 50})
+	if err != nil {
+		t.Fatalf("ListByRoom() error = %v", err)
+	}
+	if len(msgs) != 4 {
+		t.Fatalf("ListByRoom() len = %d, want 4", len(msgs))
+	}
+	if msgs[0].ClientMsgID != "c-1" {
+		t.Errorf("ListByRoom()[0].ClientMsgID = %q, want c-1", msgs[0].ClientMsgID)
+	}
+}
+
+func TestMessageService_SeqPerRoom(t *testing.T) {
+	gdb := setupTestDB(t)
+	svc := NewMessageService(gdb)
+	roomA := createTestRoom(t, gdb, "a")
+	roomB := createTestRoom(t, gdb, "b")
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := svc.Create(CreateMessageInput{RoomID: roomA, UserID: 1, Content: "a"}); err != nil {
+			t.Fatalf("Create(a) error = %v", err)
+		}
+	}
+	// 重放不消耗序号。
+	if _, _, err := svc.Create(CreateMessageInput{RoomID: roomB, UserID: 1, Content: "b", ClientMsgID: "x"}); err != nil {
+		t.Fatalf("Create(b) error = %v", err)
+	}
+	if _, _, err := svc.Create(CreateMessageInput{RoomID: roomB, UserID: 1, Content: "b", ClientMsgID: "x"}); err != nil {
+		t.Fatalf("Create(b replay) error = %v", err)
+	}
+	last, _, err := svc.Create(CreateMessageInput{RoomID: roomB, UserID: 1, Content: "b"})
+	if err != nil {
+		t.Fatalf("Create(b) error = %v", err)
+	}
+	if last.Seq != 2 {
+		t.Errorf("room b seq = %d, want 2", last.Seq)
+	}
+
+	msgs, err := svc.ListByRoom(roomA, ListQuery{})
+	if err != nil {
+		t.Fatalf("ListByRoom() error = %v", err)
+	}
+	for i, m := range msgs {
+		if m.Seq != uint64(i+1) {
+			t.Errorf("room a msgs[%d].Seq = %d, want %d", i, m.Seq, i+1)
+		}
+	}
+}
+
+func TestMessageService_ListByRoom_Seq(t *testing.T) {
+	gdb := setupTestDB(t)
+	svc := NewMessageService(gdb)
+	roomID := createTestRoom(t, gdb, "general")
+	for i := 0; i < 10; i++ {
+		if _, _, err := svc.Create(CreateMessageInput{RoomID: roomID, UserID: 1, Content: "m"}); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		q         ListQuery
+		wantFirst uint64
+		wantLast  uint64
+		wantLen   int
+	}{
+		{"latest", ListQuery{Limit: 3}, 8, 10, 3},
+		{"after seq", ListQuery{Limit: 3, AfterSeq: 4}, 5, 7, 3},
+		{"after seq tail", ListQuery{AfterSeq: 9}, 10, 10, 1},
+		{"before seq", ListQuery{Limit: 3, BeforeSeq: 5}, 2, 4, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs, err := svc.ListByRoom(roomID, tt.q)
+			if err != nil {
+				t.Fatalf("ListByRoom() error = %v", err)
+			}
+			if len(msgs) != tt.wantLen {
+				t.Fatalf("ListByRoom() len = %d, want %d", len(msgs), tt.wantLen)
+			}
+			if msgs[0].Seq != tt.wantFirst || msgs[len(msgs)-1].Seq != tt.wantLast {
+				t.Errorf("ListByRoom() seq range = %d..%d, want %d..%d", msgs[0].Seq, msgs[len(msgs)-1].Seq, tt.wantFirst, tt.wantLast)
+			}
+		})
+	}
+}
+
+func TestMessageService_CreateBatch(t *testing.T) {
+	gdb := setupTestDB(t)
+	svc := NewMessageService(gdb)
+	roomID := createTestRoom(t, gdb, "general")
+	if _, _, err := svc.Create(CreateMessageInput{RoomID: roomID, UserID: 7, Content: "earlier", ClientMsgID: "c-0"}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	results := svc.CreateBatch(roomID, []CreateMessageInput{
+		{UserID: 7, Content: "one", ClientMsgID: "c-1"},
+		{UserID: 7, Content: ""},
+		{UserID: 8, Content: "two"},
+		{UserID: 7, Content: "one again", ClientMsgID: "c-1"},
+		{UserID: 7, Content: "replayed", ClientMsgID: "c-0"},
+		{UserID: 8, Content: "three"},
+	})
+	if len(results) != 6 {
+		t.Fatalf("CreateBatch() len = %d, want 6", len(results))
+	}
+	// 写入的消息按输入顺序获得连续的 seq，校验失败的消息不占用序号。
+	for i, want := range map[int]uint64{0: 2, 2: 3, 5: 4} {
+		r := results[i]
+		if r.Err != nil || r.Duplicate || r.Message.Seq != want {
+			t.Errorf("results[%d] = %+v, want seq %d", i, r, want)
+		}
+	}
+	if !errors.Is(results[1].Err, ErrMessageEmpty) {
+		t.Errorf("results[1].Err = %v, want ErrMessageEmpty", results[1].Err)
+	}
+	if r := results[3]; !r.Duplicate || r.Message.ID != results[0].Message.ID {
+		t.Errorf("in-batch replay = %+v, want duplicate of results[0]", r)
+	}
+	if r := results[4]; !r.Duplicate || r.Message.Seq != 1 {
+		t.Errorf("earlier replay = %+v, want duplicate of seq 1", r)
+	}
+
+	if r := svc.CreateBatch(999, []CreateMessageInput{{UserID: 7, Content: "x"}}); !errors.Is(r[0].Err, ErrRoomNotFound) {
+		t.Errorf("CreateBatch(missing room) err = %v, want ErrRoomNotFound", r[0].Err)
+	}
+}
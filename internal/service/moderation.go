@@ -0,0 +1,313 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"chatroom/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// 处罚类型。
+const (
+	SanctionBan  = "ban"
+	SanctionMute = "mute"
+)
+
+// MaxSanctionReasonLength 是处罚原因的最大字符数。
+const MaxSanctionReasonLength = 256
+
+// sanctionCacheTTL 是生效处罚的缓存时间。经由同一个 ModerationService 下达或撤销的处罚立即生效，
+// 因此每个进程应只创建一个实例并在 REST 与实时连接之间共用；其他实例的修改最多经过这么久生效，
+// 封禁同时经由 Enforcer 踢出，不受缓存影响。
+const sanctionCacheTTL = 10 * time.Second
+
+// Enforcer 把用户从房间或整个实例集群中踢出，由 ws.Hub 实现；roomID 为零表示断开该用户的所有连接。
+type Enforcer interface {
+	Kick(roomID, userID uint, reason string)
+}
+
+// ModerationService 管理封禁、禁言与踢人。全局管理员可以处理任何房间以及全局处罚，
+// 房主只能处理自己的房间。
+//
+// Check 在每条消息发送前调用，生效的处罚按用户与房间缓存，避免每条消息都查询数据库。
+type ModerationService struct {
+	db         *gorm.DB
+	moderators map[uint]bool
+	enforcer   Enforcer
+
+	mu    sync.Mutex
+	cache map[uint]map[uint]cachedSanctions
+	swept time.Time
+}
+
+// cachedSanctions 是用户在某个房间内生效的处罚（含全局处罚），最早到期的处罚过期时缓存随之失效。
+type cachedSanctions struct {
+	recs   []models.Sanction
+	expire time.Time
+}
+
+func NewModerationService(db *gorm.DB, moderatorIDs []uint, enforcer Enforcer) *ModerationService {
+	mods := make(map[uint]bool, len(moderatorIDs))
+	for _, id := range moderatorIDs {
+		mods[id] = true
+	}
+	return &ModerationService{db: db, moderators: mods, enforcer: enforcer, cache: make(map[uint]map[uint]cachedSanctions), swept: time.Now()}
+}
+
+// SanctionDTO 是对外输出的处罚记录。
+type SanctionDTO struct {
+	ID        uint       `json:"id"`
+	Kind      string     `json:"kind"`
+	UserID    uint       `json:"user_id"`
+	RoomID    uint       `json:"room_id"`
+	Reason    string     `json:"reason"`
+	CreatedBy uint       `json:"created_by"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// SanctionInput 描述一次处罚；RoomID 为零表示全局，Duration 为零表示永久。
+type SanctionInput struct {
+	Kind     string
+	UserID   uint
+	RoomID   uint
+	Reason   string
+	Duration time.Duration
+}
+
+// IsModerator 表示用户是否为全局管理员。
+func (s *ModerationService) IsModerator(userID uint) bool { return s.moderators[userID] }
+
+// CanManage 确认 actor 可以管理房间的设置，roomID 为零时要求全局管理员。
+func (s *ModerationService) CanManage(actorID, roomID uint) error {
+	return s.authorize(actorID, roomID, 0)
+}
+
+// authorize 确认 actor 可以管理 roomID（为零时表示全局）；目标是管理员或房主时同样拒绝。
+func (s *ModerationService) authorize(actorID, roomID, targetID uint) error {
+	if targetID != 0 && (targetID == actorID || s.moderators[targetID]) {
+		return ErrForbidden
+	}
+	if roomID == 0 {
+		if !s.moderators[actorID] {
+			return ErrForbidden
+		}
+		return nil
+	}
+	var room models.Room
+	if err := s.db.Select("id", "owner_id").First(&room, roomID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrRoomNotFound
+		}
+		return err
+	}
+	if targetID != 0 && targetID == room.OwnerID {
+		return ErrForbidden
+	}
+	if !s.moderators[actorID] && room.OwnerID != actorID {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// Apply 创建处罚记录；封禁会立即把用户踢出对应范围。
+func (s *ModerationService) Apply(actorID uint, in SanctionInput) (*SanctionDTO, error) {
+	rec, err := s.prepare(actorID, in)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.Create(rec).Error; err != nil {
+		return nil, err
+	}
+	s.enforce(rec)
+	return sanctionDTO(*rec), nil
+}
+
+// prepare 校验处罚并构造尚未写入的记录。需要在自己的事务中写入处罚的调用方
+// 在事务提交后必须调用 enforce。
+func (s *ModerationService) prepare(actorID uint, in SanctionInput) (*models.Sanction, error) {
+	if (in.Kind != SanctionBan && in.Kind != SanctionMute) || in.UserID == 0 || in.Duration < 0 {
+		return nil, ErrInvalidSanction
+	}
+	if utf8.RuneCountInString(in.Reason) > MaxSanctionReasonLength {
+		return nil, ErrReasonTooLong
+	}
+	if err := s.userExists(in.UserID); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(actorID, in.RoomID, in.UserID); err != nil {
+		return nil, err
+	}
+	rec := models.Sanction{Kind: in.Kind, UserID: in.UserID, RoomID: in.RoomID, Reason: in.Reason, CreatedBy: actorID}
+	if in.Duration > 0 {
+		t := time.Now().Add(in.Duration)
+		rec.ExpiresAt = &t
+	}
+	return &rec, nil
+}
+
+// enforce 使已写入的处罚立即生效：丢弃缓存，封禁时把用户踢出对应范围。
+func (s *ModerationService) enforce(rec *models.Sanction) {
+	s.invalidate(rec.UserID)
+	if rec.Kind == SanctionBan && s.enforcer != nil {
+		s.enforcer.Kick(rec.RoomID, rec.UserID, rec.Reason)
+	}
+}
+
+// Kick 把用户踢出房间（roomID 为零时断开其所有连接），不留下处罚记录，用户可以立即重新加入。
+func (s *ModerationService) Kick(actorID, roomID, userID uint, reason string) error {
+	if userID == 0 {
+		return ErrInvalidSanction
+	}
+	if utf8.RuneCountInString(reason) > MaxSanctionReasonLength {
+		return ErrReasonTooLong
+	}
+	if err := s.userExists(userID); err != nil {
+		return err
+	}
+	if err := s.authorize(actorID, roomID, userID); err != nil {
+		return err
+	}
+	if s.enforcer != nil {
+		s.enforcer.Kick(roomID, userID, reason)
+	}
+	return nil
+}
+
+// Revoke 撤销一条处罚记录。
+func (s *ModerationService) Revoke(actorID, id uint) error {
+	var rec models.Sanction
+	if err := s.db.Where("revoked_at IS NULL").First(&rec, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrSanctionNotFound
+		}
+		return err
+	}
+	if err := s.authorize(actorID, rec.RoomID, 0); err != nil {
+		return err
+	}
+	if err := s.db.Model(&rec).Update("revoked_at", time.Now()).Error; err != nil {
+		return err
+	}
+	s.invalidate(rec.UserID)
+	return nil
+}
+
+// List 返回房间（roomID 为零时为全局）当前生效的处罚，按创建时间倒序。
+func (s *ModerationService) List(actorID, roomID uint) ([]SanctionDTO, error) {
+	if err := s.authorize(actorID, roomID, 0); err != nil {
+		return nil, err
+	}
+	var recs []models.Sanction
+	if err := s.active(s.db.Where("room_id = ?", roomID)).Order("id desc").Find(&recs).Error; err != nil {
+		return nil, err
+	}
+	out := make([]SanctionDTO, 0, len(recs))
+	for _, r := range recs {
+		out = append(out, *sanctionDTO(r))
+	}
+	return out, nil
+}
+
+// Check 返回用户在房间内生效的处罚：被封禁时返回 ErrUserBanned，只检查封禁时 mute 为 false。
+// 房间处罚与全局处罚都会生效，roomID 为零时只检查全局处罚。
+func (s *ModerationService) Check(userID, roomID uint, mute bool) error {
+	recs, err := s.sanctions(userID, roomID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	muted := false
+	for _, r := range recs {
+		if r.ExpiresAt != nil && !r.ExpiresAt.After(now) {
+			continue
+		}
+		if r.Kind == SanctionBan {
+			return ErrUserBanned
+		}
+		muted = muted || r.Kind == SanctionMute
+	}
+	if mute && muted {
+		return ErrUserMuted
+	}
+	return nil
+}
+
+// sanctions 返回用户在房间内生效的处罚，优先使用缓存。
+func (s *ModerationService) sanctions(userID, roomID uint) ([]models.Sanction, error) {
+	now := time.Now()
+	s.mu.Lock()
+	c, ok := s.cache[userID][roomID]
+	s.mu.Unlock()
+	if ok && now.Before(c.expire) {
+		return c.recs, nil
+	}
+	var recs []models.Sanction
+	err := s.active(s.db.Select("kind", "expires_at").Where("user_id = ? AND room_id IN ?", userID, []uint{0, roomID})).Find(&recs).Error
+	if err != nil {
+		return nil, err
+	}
+	expire := now.Add(sanctionCacheTTL)
+	for _, r := range recs {
+		if r.ExpiresAt != nil && r.ExpiresAt.Before(expire) {
+			expire = *r.ExpiresAt
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+	if s.cache[userID] == nil {
+		s.cache[userID] = make(map[uint]cachedSanctions)
+	}
+	s.cache[userID][roomID] = cachedSanctions{recs: recs, expire: expire}
+	return recs, nil
+}
+
+// invalidate 丢弃用户所有房间的缓存；全局处罚会影响该用户的每个房间。
+func (s *ModerationService) invalidate(userID uint) {
+	s.mu.Lock()
+	delete(s.cache, userID)
+	s.mu.Unlock()
+}
+
+// sweep 每分钟清理一次已过期的缓存，调用方需持有 s.mu。
+func (s *ModerationService) sweep(now time.Time) {
+	if now.Sub(s.swept) < time.Minute {
+		return
+	}
+	s.swept = now
+	for userID, rooms := range s.cache {
+		for roomID, c := range rooms {
+			if !now.Before(c.expire) {
+				delete(rooms, roomID)
+			}
+		}
+		if len(rooms) == 0 {
+			delete(s.cache, userID)
+		}
+	}
+}
+
+// active 限定为未撤销且未过期的处罚。
+func (s *ModerationService) active(q *gorm.DB) *gorm.DB {
+	return q.Where("revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", time.Now())
+}
+
+func (s *ModerationService) userExists(userID uint) error {
+	var count int64
+	if err := s.db.Model(&models.User{}).Where("id = ?", userID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func sanctionDTO(r models.Sanction) *SanctionDTO {
+	return &SanctionDTO{ID: r.ID, Kind: r.Kind, UserID: r.UserID, RoomID: r.RoomID, Reason: r.Reason, CreatedBy: r.CreatedBy, ExpiresAt: r.ExpiresAt, CreatedAt: r.CreatedAt}
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"chatroom/internal/models"
+)
+
+type kickRecorder struct{ kicks []string }
+
+func (k *kickRecorder) Kick(roomID, userID uint, reason string) {
+	k.kicks = append(k.kicks, reason)
+}
+
+func TestModerationService_Permissions(t *testing.T) {
+	gdb := setupTestDB(t)
+	for _, name := range []string{"owner", "member", "mod"} {
+		if err := gdb.Create(&models.User{Username: name, PasswordHash: "x"}).Error; err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+	}
+	roomID := createTestRoom(t, gdb, "general")
+	rec := &kickRecorder{}
+	svc := NewModerationService(gdb, []uint{3}, rec)
+
+	tests := []struct {
+		name  string
+		actor uint
+		in    SanctionInput
+		want  error
+	}{
+		{"member cannot moderate", 2, SanctionInput{Kind: SanctionMute, UserID: 1, RoomID: roomID}, ErrForbidden},
+		{"owner cannot act globally", 1, SanctionInput{Kind: SanctionBan, UserID: 2}, ErrForbidden},
+		{"nobody sanctions a moderator", 1, SanctionInput{Kind: SanctionBan, UserID: 3, RoomID: roomID}, ErrForbidden},
+		{"moderator cannot sanction owner in own room", 3, SanctionInput{Kind: SanctionBan, UserID: 1, RoomID: roomID}, ErrForbidden},
+		{"unknown user", 1, SanctionInput{Kind: SanctionBan, UserID: 99, RoomID: roomID}, ErrUserNotFound},
+		{"unknown room", 3, SanctionInput{Kind: SanctionBan, UserID: 2, RoomID: 99}, ErrRoomNotFound},
+		{"invalid kind", 1, SanctionInput{Kind: "kick", UserID: 2, RoomID: roomID}, ErrInvalidSanction},
+		{"owner bans in room", 1, SanctionInput{Kind: SanctionBan, UserID: 2, RoomID: roomID, Reason: "spam"}, nil},
+		{"moderator mutes globally", 3, SanctionInput{Kind: SanctionMute, UserID: 2, Duration: time.Hour}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := svc.Apply(tt.actor, tt.in); !errors.Is(err, tt.want) {
+				t.Errorf("Apply() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+	// 只有封禁会立即踢人。
+	if len(rec.kicks) != 1 || rec.kicks[0] != "spam" {
+		t.Errorf("kicks = %v, want one kick for the ban", rec.kicks)
+	}
+}
+
+func TestModerationService_Check(t *testing.T) {
+	gdb := setupTestDB(t)
+	roomID := createTestRoom(t, gdb, "general")
+	otherRoom := createTestRoom(t, gdb, "random")
+	svc := NewModerationService(gdb, nil, nil)
+	past := time.Now().Add(-time.Minute)
+	for _, s := range []models.Sanction{
+		{Kind: SanctionBan, UserID: 5, RoomID: roomID, CreatedBy: 1},
+		{Kind: SanctionMute, UserID: 6, CreatedBy: 1},
+		{Kind: SanctionBan, UserID: 7, RoomID: roomID, CreatedBy: 1, ExpiresAt: &past},
+		{Kind: SanctionBan, UserID: 8, RoomID: roomID, CreatedBy: 1, RevokedAt: &past},
+	} {
+		if err := gdb.Create(&s).Error; err != nil {
+			t.Fatalf("create sanction: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		userID uint
+		roomID uint
+		mute   bool
+		want   error
+	}{
+		{"banned in room", 5, roomID, false, ErrUserBanned},
+		{"room ban does not leak", 5, otherRoom, true, nil},
+		{"global mute applies everywhere", 6, otherRoom, true, ErrUserMuted},
+		{"mute ignored when only checking bans", 6, otherRoom, false, nil},
+		{"expired ban", 7, roomID, true, nil},
+		{"revoked ban", 8, roomID, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := svc.Check(tt.userID, tt.roomID, tt.mute); !errors.Is(err, tt.want) {
+				t.Errorf("Check() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestModerationService_CheckCache(t *testing.T) {
+	gdb := setupTestDB(t)
+	for _, name := range []string{"owner", "member"} {
+		if err := gdb.Create(&models.User{Username: name, PasswordHash: "x"}).Error; err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+	}
+	roomID := createTestRoom(t, gdb, "general")
+	svc := NewModerationService(gdb, nil, nil)
+
+	if err := svc.Check(2, roomID, true); err != nil {
+		t.Fatalf("Check() = %v, want nil", err)
+	}
+	// 绕过服务直接写入的处罚在缓存过期前不会生效，说明结果来自缓存。
+	if err := gdb.Create(&models.Sanction{Kind: SanctionBan, UserID: 2, RoomID: roomID, CreatedBy: 1}).Error; err != nil {
+		t.Fatalf("create sanction: %v", err)
+	}
+	if err := svc.Check(2, roomID, true); err != nil {
+		t.Fatalf("cached Check() = %v, want nil", err)
+	}
+	gdb.Where("user_id = ?", 2).Delete(&models.Sanction{})
+
+	// 下达与撤销处罚立即生效，限时处罚到期后缓存随之失效。
+	dto, err := svc.Apply(1, SanctionInput{Kind: SanctionMute, UserID: 2, RoomID: roomID})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if err := svc.Check(2, roomID, true); !errors.Is(err, ErrUserMuted) {
+		t.Errorf("Check() after Apply() = %v, want ErrUserMuted", err)
+	}
+	if err := svc.Revoke(1, dto.ID); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if err := svc.Check(2, roomID, true); err != nil {
+		t.Errorf("Check() after Revoke() = %v, want nil", err)
+	}
+	if _, err := svc.Apply(1, SanctionInput{Kind: SanctionMute, UserID: 2, RoomID: roomID, Duration: 50 * time.Millisecond}); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if err := svc.Check(2, roomID, true); !errors.Is(err, ErrUserMuted) {
+		t.Errorf("Check() during timed mute = %v, want ErrUserMuted", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if err := svc.Check(2, roomID, true); err != nil {
+		t.Errorf("Check() after mute expired = %v, want nil", err)
+	}
+}
@@ -0,0 +1,201 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"chatroom/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// MaxSlowModeSeconds 是慢速模式间隔的上限。
+const MaxSlowModeSeconds = 6 * 60 * 60
+
+// roomPolicyTTL 是房间发言设置的缓存时间，其他实例修改设置后最多经过这么久生效。
+const roomPolicyTTL = 30 * time.Second
+
+// RoomSettings 是房间的发言限制。
+type RoomSettings struct {
+	SlowModeSeconds int  `json:"slow_mode_seconds"`
+	Announcement    bool `json:"announcement"`
+}
+
+// PostPolicy 执行房间的发言限制：公告模式下只有房主与管理员可以发言，慢速模式限制普通成员的发言间隔。
+// 上次发言时间以本实例的记录为准，异步落库尚未写入的消息也能被计入；本实例没有记录时才读取数据库中最后一条消息的时间。
+type PostPolicy struct {
+	db    *gorm.DB
+	mu    sync.Mutex
+	rooms map[uint]cachedRoom
+	last  map[postKey]postRecord
+	swept time.Time
+}
+
+// postRecord 是用户在房间内最近一次发言（或尚未写入的预留）的时间，prev 是预留前的时间，归还预留时恢复。
+type postRecord struct {
+	at   time.Time
+	prev time.Time
+}
+
+type cachedRoom struct {
+	room   models.Room
+	expire time.Time
+}
+
+type postKey struct {
+	userID uint
+	roomID uint
+}
+
+func NewPostPolicy(db *gorm.DB) *PostPolicy {
+	return &PostPolicy{db: db, rooms: make(map[uint]cachedRoom), last: make(map[postKey]postRecord), swept: time.Now()}
+}
+
+// Settings 返回房间当前的发言限制。
+func (p *PostPolicy) Settings(roomID uint) (*RoomSettings, error) {
+	room, err := p.load(roomID)
+	if err != nil {
+		return nil, err
+	}
+	return &RoomSettings{SlowModeSeconds: room.SlowModeSeconds, Announcement: room.Announcement}, nil
+}
+
+// Update 修改房间的发言限制，调用方负责鉴权。
+func (p *PostPolicy) Update(roomID uint, in RoomSettings) error {
+	if in.SlowModeSeconds < 0 || in.SlowModeSeconds > MaxSlowModeSeconds {
+		return ErrInvalidRoomSettings
+	}
+	res := p.db.Model(&models.Room{}).Where("id = ?", roomID).
+		Updates(map[string]interface{}{"slow_mode_seconds": in.SlowModeSeconds, "announcement": in.Announcement})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrRoomNotFound
+	}
+	p.mu.Lock()
+	delete(p.rooms, roomID)
+	p.mu.Unlock()
+	return nil
+}
+
+// Check 判断用户现在能否在房间发言；房主与全局管理员（moderator）不受限制。
+// 公告房间返回 ErrAnnouncementOnly；慢速模式未到间隔时返回 ErrSlowMode 与还需等待的时长。
+// 放行时为本次发言预留名额，同一用户并发发送的消息不能借此绕过间隔；消息最终没有写入时
+// （校验失败、被过滤拒绝、重发去重或落库失败）调用方必须调用 release 归还名额，写入成功时不必调用。
+func (p *PostPolicy) Check(userID, roomID uint, moderator bool) (release func(), wait time.Duration, err error) {
+	room, err := p.room(roomID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if moderator || room.OwnerID == userID {
+		return noRelease, 0, nil
+	}
+	if room.Announcement {
+		return nil, 0, ErrAnnouncementOnly
+	}
+	if room.SlowModeSeconds <= 0 {
+		return noRelease, 0, nil
+	}
+	interval := time.Duration(room.SlowModeSeconds) * time.Second
+	key := postKey{userID, roomID}
+	if err := p.loadLast(key); err != nil {
+		return nil, 0, err
+	}
+
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rec := p.last[key]
+	if wait := rec.at.Add(interval).Sub(now); wait > 0 {
+		return nil, wait, ErrSlowMode
+	}
+	p.last[key] = postRecord{at: now, prev: rec.at}
+	p.sweep(now)
+	return func() { p.release(key, now) }, 0, nil
+}
+
+func noRelease() {}
+
+// release 归还 Check 在 at 时刻的预留，恢复之前的发言时间；预留已被更新的记录替换时不做任何事。
+func (p *PostPolicy) release(key postKey, at time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rec, ok := p.last[key]
+	if !ok || !rec.at.Equal(at) {
+		return
+	}
+	if rec.prev.IsZero() {
+		delete(p.last, key)
+		return
+	}
+	p.last[key] = postRecord{at: rec.prev}
+}
+
+// room 返回缓存的房间设置，过期后重新加载。
+func (p *PostPolicy) room(roomID uint) (models.Room, error) {
+	now := time.Now()
+	p.mu.Lock()
+	c, ok := p.rooms[roomID]
+	p.mu.Unlock()
+	if ok && now.Before(c.expire) {
+		return c.room, nil
+	}
+	room, err := p.load(roomID)
+	if err != nil {
+		return models.Room{}, err
+	}
+	p.mu.Lock()
+	p.rooms[roomID] = cachedRoom{room: *room, expire: now.Add(roomPolicyTTL)}
+	p.mu.Unlock()
+	return *room, nil
+}
+
+func (p *PostPolicy) load(roomID uint) (*models.Room, error) {
+	var room models.Room
+	err := p.db.Select("id", "owner_id", "slow_mode_seconds", "announcement").First(&room, roomID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRoomNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+// loadLast 在本实例没有该用户的发言记录时，用数据库中最后一条已落库消息的时间补上，
+// 其他实例或 REST 发送的消息由此计入；已有记录时不再查询数据库。
+func (p *PostPolicy) loadLast(key postKey) error {
+	p.mu.Lock()
+	_, ok := p.last[key]
+	p.mu.Unlock()
+	if ok {
+		return nil
+	}
+	var msgs []models.Message
+	err := p.db.Select("created_at").Where("room_id = ? AND user_id = ?", key.roomID, key.userID).
+		Order("id desc").Limit(1).Find(&msgs).Error
+	if err != nil || len(msgs) == 0 {
+		return err
+	}
+	p.mu.Lock()
+	if _, ok := p.last[key]; !ok {
+		p.last[key] = postRecord{at: msgs[0].CreatedAt}
+	}
+	p.mu.Unlock()
+	return nil
+}
+
+// sweep 每分钟清理一次早已超过最大间隔的记录，调用方需持有 p.mu。
+func (p *PostPolicy) sweep(now time.Time) {
+	if now.Sub(p.swept) < time.Minute {
+		return
+	}
+	p.swept = now
+	for k, rec := range p.last {
+		if now.Sub(rec.at) > MaxSlowModeSeconds*time.Second {
+			delete(p.last, k)
+		}
+	}
+}
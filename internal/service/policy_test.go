@@ -0,0 +1,77 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"chatroom/internal/models"
+)
+
+func TestPostPolicy(t *testing.T) {
+	gdb := setupTestDB(t)
+	roomID := createTestRoom(t, gdb, "general")
+	p := NewPostPolicy(gdb)
+
+	if err := p.Update(roomID, RoomSettings{SlowModeSeconds: -1}); !errors.Is(err, ErrInvalidRoomSettings) {
+		t.Errorf("Update(negative) error = %v, want ErrInvalidRoomSettings", err)
+	}
+	if err := p.Update(99, RoomSettings{}); !errors.Is(err, ErrRoomNotFound) {
+		t.Errorf("Update(unknown room) error = %v, want ErrRoomNotFound", err)
+	}
+	if err := p.Update(roomID, RoomSettings{SlowModeSeconds: 60}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	release, _, err := p.Check(2, roomID, false)
+	if err != nil {
+		t.Fatalf("first Check() error = %v", err)
+	}
+	// 消息没有写入时归还名额，用户可以立即重发。
+	release()
+	if _, _, err := p.Check(2, roomID, false); err != nil {
+		t.Fatalf("Check() after release error = %v", err)
+	}
+	// 已归还的预留再次归还不影响新的预留。
+	release()
+	_, wait, err := p.Check(2, roomID, false)
+	if !errors.Is(err, ErrSlowMode) || wait <= 59*time.Second || wait > 60*time.Second {
+		t.Errorf("second Check() = %v, %v, want ErrSlowMode with ~60s wait", wait, err)
+	}
+	for _, exempt := range []struct {
+		user      uint
+		moderator bool
+	}{{1, false}, {1, false}, {5, true}, {5, true}} {
+		if _, _, err := p.Check(exempt.user, roomID, exempt.moderator); err != nil {
+			t.Errorf("Check(%d) error = %v, want exempt", exempt.user, err)
+		}
+	}
+
+	// 其他实例或 REST 写入的消息同样计入间隔。
+	if err := gdb.Create(&models.Message{RoomID: roomID, UserID: 3, Seq: 1, Content: "hi"}).Error; err != nil {
+		t.Fatalf("create message: %v", err)
+	}
+	if _, _, err := p.Check(3, roomID, false); !errors.Is(err, ErrSlowMode) {
+		t.Errorf("Check() after stored message error = %v, want ErrSlowMode", err)
+	}
+	// 已有本地记录时不再查询数据库。
+	if err := gdb.Migrator().DropTable(&models.Message{}); err != nil {
+		t.Fatalf("drop messages: %v", err)
+	}
+	if _, _, err := p.Check(3, roomID, false); !errors.Is(err, ErrSlowMode) {
+		t.Errorf("Check() with cached record error = %v, want ErrSlowMode", err)
+	}
+
+	if err := p.Update(roomID, RoomSettings{Announcement: true}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if _, _, err := p.Check(4, roomID, false); !errors.Is(err, ErrAnnouncementOnly) {
+		t.Errorf("Check() in announcement room error = %v, want ErrAnnouncementOnly", err)
+	}
+	if _, _, err := p.Check(1, roomID, false); err != nil {
+		t.Errorf("owner Check() in announcement room error = %v", err)
+	}
+	if s, err := p.Settings(roomID); err != nil || !s.Announcement || s.SlowModeSeconds != 0 {
+		t.Errorf("Settings() = %+v, %v", s, err)
+	}
+}
@@ -0,0 +1,460 @@
+package service
+
+import (
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"chatroom/internal/models"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 举报分类。
+const (
+	ReportSpam       = "spam"
+	ReportHarassment = "harassment"
+	ReportHate       = "hate"
+	ReportSexual     = "sexual"
+	ReportViolence   = "violence"
+	ReportOther      = "other"
+)
+
+var reportCategories = map[string]bool{
+	ReportSpam: true, ReportHarassment: true, ReportHate: true,
+	ReportSexual: true, ReportViolence: true, ReportOther: true,
+}
+
+// 审核队列条目的状态。
+const (
+	ReviewOpen     = "open"
+	ReviewClaimed  = "claimed"
+	ReviewResolved = "resolved"
+)
+
+// 审核结论：dismissed 表示举报不成立，恢复被自动隐藏的消息；removed 表示隐藏消息。
+const (
+	ResolutionDismissed = "dismissed"
+	ResolutionRemoved   = "removed"
+)
+
+// MaxReportDetailLength 是举报说明与审核备注的最大字符数。
+const MaxReportDetailLength = 256
+
+// MessageNotifier 把消息的变化（隐藏与恢复）推送给房间内的实时连接，由 ws.Hub 实现。
+type MessageNotifier interface {
+	MessageUpdated(dto MessageDTO)
+}
+
+// ReportService 处理用户举报与审核队列。队列中的每一条对应一条消息，汇总用户举报与内容过滤标记；
+// 房主处理自己房间的条目，全局管理员处理所有条目。
+type ReportService struct {
+	db        *gorm.DB
+	msgs      *MessageService
+	mod       *ModerationService
+	notifier  MessageNotifier
+	threshold int
+}
+
+// NewReportService 创建 ReportService；hideThreshold 是自动隐藏消息所需的举报人数，0 表示不自动隐藏。
+func NewReportService(db *gorm.DB, msgs *MessageService, mod *ModerationService, notifier MessageNotifier, hideThreshold int) *ReportService {
+	return &ReportService{db: db, msgs: msgs, mod: mod, notifier: notifier, threshold: hideThreshold}
+}
+
+// ReportDTO 是对外输出的举报记录。
+type ReportDTO struct {
+	ID        uint      `json:"id"`
+	MessageID uint      `json:"message_id"`
+	RoomID    uint      `json:"room_id"`
+	Category  string    `json:"category"`
+	Detail    string    `json:"detail"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ReviewItemDTO 是对外输出的审核队列条目，Message 总是包含原文，即使消息已被隐藏。
+type ReviewItemDTO struct {
+	ID         uint           `json:"id"`
+	MessageID  uint           `json:"message_id"`
+	RoomID     uint           `json:"room_id"`
+	Status     string         `json:"status"`
+	Reports    int            `json:"reports"`
+	Flags      int            `json:"flags"`
+	Categories map[string]int `json:"categories"`
+	Filters    []string       `json:"filters"`
+	ClaimedBy  uint           `json:"claimed_by,omitempty"`
+	ClaimedAt  *time.Time     `json:"claimed_at,omitempty"`
+	ResolvedBy uint           `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time     `json:"resolved_at,omitempty"`
+	Resolution string         `json:"resolution,omitempty"`
+	Note       string         `json:"note,omitempty"`
+	SanctionID *uint          `json:"sanction_id,omitempty"`
+	Message    *MessageDTO    `json:"message,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+// Report 记录一次举报并把消息放入审核队列；未处理的举报人数达到阈值时自动隐藏消息。
+func (s *ReportService) Report(reporterID, messageID uint, category, detail string) (*ReportDTO, error) {
+	if !reportCategories[category] {
+		return nil, ErrInvalidReport
+	}
+	if utf8.RuneCountInString(detail) > MaxReportDetailLength {
+		return nil, ErrReasonTooLong
+	}
+	msg, err := s.message(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if msg.UserID == reporterID {
+		return nil, ErrForbidden
+	}
+	rep := models.Report{MessageID: msg.ID, ReporterID: reporterID, RoomID: msg.RoomID, Category: category, Detail: detail}
+	var hidden bool
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&rep)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrAlreadyReported
+		}
+		item, err := openReview(tx, msg.RoomID, msg.ID, 1, 0)
+		if err != nil {
+			return err
+		}
+		if s.threshold <= 0 || item.Reports < s.threshold || msg.Hidden {
+			return nil
+		}
+		hidden, err = setHidden(tx, msg.ID, true)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if hidden {
+		msg.Hidden = true
+		s.notify(msg)
+	}
+	return &ReportDTO{ID: rep.ID, MessageID: rep.MessageID, RoomID: rep.RoomID, Category: rep.Category, Detail: rep.Detail, CreatedAt: rep.CreatedAt}, nil
+}
+
+// Queue 列出审核队列，按进入队列的时间先后排序。roomID 为零时列出所有房间，仅全局管理员可用；
+// status 为空时列出未处理（open 与 claimed）的条目。
+func (s *ReportService) Queue(actorID, roomID uint, status string, limit int) ([]ReviewItemDTO, error) {
+	if err := s.mod.CanManage(actorID, roomID); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+	q := s.db.Order("id asc").Limit(limit)
+	if roomID != 0 {
+		q = q.Where("room_id = ?", roomID)
+	}
+	switch status {
+	case "":
+		q = q.Where("status <> ?", ReviewResolved)
+	case ReviewOpen, ReviewClaimed, ReviewResolved:
+		q = q.Where("status = ?", status)
+	default:
+		return nil, ErrInvalidReview
+	}
+	var items []models.ReviewItem
+	if err := q.Find(&items).Error; err != nil {
+		return nil, err
+	}
+	return s.toDTOs(items)
+}
+
+// Claim 认领一个条目，避免多位管理员重复处理；已被他人认领时返回 ErrReviewClaimed。
+func (s *ReportService) Claim(actorID, itemID uint) (*ReviewItemDTO, error) {
+	item, err := s.item(actorID, itemID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	res := s.db.Model(&models.ReviewItem{}).
+		Where("id = ? AND (status = ? OR (status = ? AND claimed_by = ?))", item.ID, ReviewOpen, ReviewClaimed, actorID).
+		Updates(map[string]interface{}{"status": ReviewClaimed, "claimed_by": actorID, "claimed_at": now})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, s.stateError(item.ID)
+	}
+	return s.reload(item.ID)
+}
+
+// ReviewAction 是处理条目时对消息作者下达的处罚，Global 为 true 时全局生效，否则限于消息所在房间。
+type ReviewAction struct {
+	Kind     string
+	Reason   string
+	Duration time.Duration
+	Global   bool
+}
+
+// ResolveInput 描述如何处理一个条目；Action 会新建处罚，SanctionID 关联已有处罚，两者都会记录在条目上。
+type ResolveInput struct {
+	Resolution string
+	Note       string
+	Action     *ReviewAction
+	SanctionID uint
+}
+
+// Resolve 处理一个条目：移除时隐藏消息，驳回时恢复被隐藏的消息。被他人认领的条目不能处理。
+// 新建的处罚与条目状态在同一个事务中写入，条目已被他人处理或认领时处罚不会生效。
+func (s *ReportService) Resolve(actorID, itemID uint, in ResolveInput) (*ReviewItemDTO, error) {
+	if in.Resolution != ResolutionDismissed && in.Resolution != ResolutionRemoved {
+		return nil, ErrInvalidReview
+	}
+	if utf8.RuneCountInString(in.Note) > MaxReportDetailLength {
+		return nil, ErrReasonTooLong
+	}
+	item, err := s.item(actorID, itemID)
+	if err != nil {
+		return nil, err
+	}
+	if item.Status == ReviewResolved || (item.Status == ReviewClaimed && item.ClaimedBy != actorID) {
+		return nil, s.stateError(item.ID)
+	}
+	msg, err := s.message(item.MessageID)
+	if err != nil {
+		return nil, err
+	}
+
+	var sanctionID *uint
+	var sanction *models.Sanction
+	switch {
+	case in.Action != nil:
+		roomID := item.RoomID
+		if in.Action.Global {
+			roomID = 0
+		}
+		sanction, err = s.mod.prepare(actorID, SanctionInput{Kind: in.Action.Kind, UserID: msg.UserID, RoomID: roomID, Reason: in.Action.Reason, Duration: in.Action.Duration})
+		if err != nil {
+			return nil, err
+		}
+	case in.SanctionID != 0:
+		var count int64
+		if err := s.db.Model(&models.Sanction{}).Where("id = ? AND user_id = ?", in.SanctionID, msg.UserID).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, ErrSanctionNotFound
+		}
+		sanctionID = &in.SanctionID
+	}
+
+	var changed bool
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// 先以条件更新占住条目（PostgreSQL 上同时锁住该行），并发的处理在这里失败，随后才写入处罚。
+		res := tx.Model(&models.ReviewItem{}).
+			Where("id = ? AND status <> ? AND (status = ? OR claimed_by = ?)", item.ID, ReviewResolved, ReviewOpen, actorID).
+			Updates(map[string]interface{}{
+				"status": ReviewResolved, "resolved_by": actorID, "resolved_at": time.Now(),
+				"resolution": in.Resolution, "note": in.Note, "sanction_id": sanctionID,
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errReviewConflict
+		}
+		if sanction != nil {
+			if err := tx.Create(sanction).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&models.ReviewItem{}).Where("id = ?", item.ID).Update("sanction_id", sanction.ID).Error; err != nil {
+				return err
+			}
+		}
+		var err error
+		changed, err = setHidden(tx, msg.ID, in.Resolution == ResolutionRemoved)
+		return err
+	})
+	if errors.Is(err, errReviewConflict) {
+		return nil, s.stateError(item.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if sanction != nil {
+		s.mod.enforce(sanction)
+	}
+	if changed {
+		msg.Hidden = in.Resolution == ResolutionRemoved
+		s.notify(msg)
+	}
+	return s.reload(item.ID)
+}
+
+// openReview 把消息放入审核队列：已有未处理的条目时累加计数，否则新建一条；已处理的条目保留为历史记录。
+// 以 upsert 写入，并发的首次举报由未处理条目的部分唯一索引合并到同一条目。需在事务内调用。
+func openReview(tx *gorm.DB, roomID, messageID uint, reports, flags int) (*models.ReviewItem, error) {
+	item := models.ReviewItem{MessageID: messageID, RoomID: roomID, Status: ReviewOpen, Reports: reports, Flags: flags}
+	err := tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "message_id"}},
+		// 冲突目标需与部分索引的条件一致，这里只能写字面量，参数化的条件无法匹配索引。
+		TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "status <> '" + ReviewResolved + "'"}}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"reports":    gorm.Expr("review_items.reports + ?", reports),
+			"flags":      gorm.Expr("review_items.flags + ?", flags),
+			"updated_at": time.Now(),
+		}),
+	}).Create(&item).Error
+	if err != nil {
+		return nil, err
+	}
+	// 冲突时 item 只保存了本次的计数，重新读取累加后的条目。
+	item = models.ReviewItem{}
+	if err := tx.Where("message_id = ? AND status <> ?", messageID, ReviewResolved).First(&item).Error; err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// setHidden 修改消息的隐藏状态，返回状态是否真的发生了变化。
+func setHidden(tx *gorm.DB, messageID uint, hidden bool) (bool, error) {
+	res := tx.Model(&models.Message{}).Where("id = ? AND hidden = ?", messageID, !hidden).Update("hidden", hidden)
+	return res.RowsAffected > 0, res.Error
+}
+
+// notify 推送消息隐藏或恢复后的内容，失败只记录日志。
+func (s *ReportService) notify(msg *models.Message) {
+	if s.notifier == nil {
+		return
+	}
+	dto, err := s.msgs.ToDTO(msg)
+	if err != nil {
+		log.Error().Err(err).Uint("message_id", msg.ID).Msg("report notify message update")
+		return
+	}
+	s.notifier.MessageUpdated(*dto)
+}
+
+func (s *ReportService) message(id uint) (*models.Message, error) {
+	var msg models.Message
+	if err := s.db.First(&msg, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// item 加载条目并确认 actor 可以处理它所在的房间。
+func (s *ReportService) item(actorID, itemID uint) (*models.ReviewItem, error) {
+	var item models.ReviewItem
+	if err := s.db.First(&item, itemID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrReviewNotFound
+		}
+		return nil, err
+	}
+	if err := s.mod.CanManage(actorID, item.RoomID); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// errReviewConflict 表示条件更新没有命中条目，事务回滚后由 stateError 说明原因。
+var errReviewConflict = errors.New("review item state changed")
+
+// stateError 在条件更新失败后说明原因：条目已处理或已被他人认领。
+func (s *ReportService) stateError(itemID uint) error {
+	var item models.ReviewItem
+	if err := s.db.Select("status").First(&item, itemID).Error; err != nil {
+		return err
+	}
+	if item.Status == ReviewResolved {
+		return ErrReviewResolved
+	}
+	return ErrReviewClaimed
+}
+
+func (s *ReportService) reload(itemID uint) (*ReviewItemDTO, error) {
+	var item models.ReviewItem
+	if err := s.db.First(&item, itemID).Error; err != nil {
+		return nil, err
+	}
+	out, err := s.toDTOs([]models.ReviewItem{item})
+	if err != nil {
+		return nil, err
+	}
+	return &out[0], nil
+}
+
+// toDTOs 批量补齐消息原文、举报分类统计与命中的过滤器。
+func (s *ReportService) toDTOs(items []models.ReviewItem) ([]ReviewItemDTO, error) {
+	out := make([]ReviewItemDTO, 0, len(items))
+	if len(items) == 0 {
+		return out, nil
+	}
+	ids := make([]uint, 0, len(items))
+	for _, it := range items {
+		ids = append(ids, it.MessageID)
+	}
+
+	var msgs []models.Message
+	if err := s.db.Where("id IN ?", ids).Find(&msgs).Error; err != nil {
+		return nil, err
+	}
+	dtos, err := s.msgs.toDTOs(msgs)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]*MessageDTO, len(dtos))
+	for i := range dtos {
+		// 审核需要看到原文，被隐藏的消息也还原内容。
+		_, dtos[i].ContentHTML = renderedContent(msgs[i])
+		dtos[i].Content = msgs[i].Content
+		byID[dtos[i].ID] = &dtos[i]
+	}
+
+	var cats []struct {
+		MessageID uint
+		Category  string
+		N         int
+	}
+	if err := s.db.Model(&models.Report{}).Select("message_id, category, count(*) as n").
+		Where("message_id IN ?", ids).Group("message_id, category").Scan(&cats).Error; err != nil {
+		return nil, err
+	}
+	categories := make(map[uint]map[string]int)
+	for _, c := range cats {
+		if categories[c.MessageID] == nil {
+			categories[c.MessageID] = make(map[string]int)
+		}
+		categories[c.MessageID][c.Category] = c.N
+	}
+
+	var flags []models.MessageFlag
+	if err := s.db.Select("message_id", "filter").Where("message_id IN ?", ids).Find(&flags).Error; err != nil {
+		return nil, err
+	}
+	filters := make(map[uint][]string)
+	for _, f := range flags {
+		filters[f.MessageID] = append(filters[f.MessageID], f.Filter)
+	}
+
+	for _, it := range items {
+		cat := categories[it.MessageID]
+		if cat == nil {
+			cat = map[string]int{}
+		}
+		fs := filters[it.MessageID]
+		if fs == nil {
+			fs = []string{}
+		}
+		out = append(out, ReviewItemDTO{
+			ID: it.ID, MessageID: it.MessageID, RoomID: it.RoomID, Status: it.Status,
+			Reports: it.Reports, Flags: it.Flags, Categories: cat, Filters: fs,
+			ClaimedBy: it.ClaimedBy, ClaimedAt: it.ClaimedAt, ResolvedBy: it.ResolvedBy, ResolvedAt: it.ResolvedAt,
+			Resolution: it.Resolution, Note: it.Note, SanctionID: it.SanctionID,
+			Message: byID[it.MessageID], CreatedAt: it.CreatedAt,
+		})
+	}
+	return out, nil
+}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"chatroom/internal/filter"
+	"chatroom/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type updateRecorder struct{ updates []MessageDTO }
+
+func (u *updateRecorder) MessageUpdated(dto MessageDTO) { u.updates = append(u.updates, dto) }
+
+func TestReportService_Flow(t *testing.T) {
+	gdb := setupTestDB(t)
+	for _, name := range []string{"owner", "author", "r1", "r2", "mod"} {
+		if err := gdb.Create(&models.User{Username: name, PasswordHash: "x"}).Error; err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+	}
+	roomID := createTestRoom(t, gdb, "general")
+	msgSvc := NewMessageService(gdb)
+	modSvc := NewModerationService(gdb, []uint{5}, nil)
+	rec := &updateRecorder{}
+	svc := NewReportService(gdb, msgSvc, modSvc, rec, 2)
+
+	msg, _, err := msgSvc.Create(CreateMessageInput{RoomID: roomID, UserID: 2, Content: "rude"})
+	if err != nil {
+		t.Fatalf("create message: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		reporter uint
+		msgID    uint
+		category string
+		want     error
+	}{
+		{"first report", 3, msg.ID, ReportHarassment, nil},
+		{"duplicate report", 3, msg.ID, ReportSpam, ErrAlreadyReported},
+		{"own message", 2, msg.ID, ReportSpam, ErrForbidden},
+		{"unknown category", 4, msg.ID, "boring", ErrInvalidReport},
+		{"unknown message", 4, 99, ReportSpam, ErrMessageNotFound},
+	}
+	for _, tt := range tests {
+		if _, err := svc.Report(tt.reporter, tt.msgID, tt.category, ""); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Report() error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	if len(rec.updates) != 0 {
+		t.Fatalf("message hidden below threshold: %+v", rec.updates)
+	}
+
+	// 达到阈值后自动隐藏，列表中只保留占位。
+	if _, err := svc.Report(4, msg.ID, ReportSpam, "again"); err != nil {
+		t.Fatalf("second report: %v", err)
+	}
+	if len(rec.updates) != 1 || !rec.updates[0].Hidden || rec.updates[0].Content != "" {
+		t.Fatalf("updates = %+v, want one hidden update", rec.updates)
+	}
+	list, _ := msgSvc.ListByRoom(roomID, ListQuery{})
+	if len(list) != 1 || !list[0].Hidden || list[0].Content != "" || list[0].Seq != 1 {
+		t.Errorf("ListByRoom() = %+v, want hidden placeholder", list)
+	}
+
+	if _, err := svc.Queue(3, roomID, "", 0); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Queue() by member error = %v, want ErrForbidden", err)
+	}
+	if _, err := svc.Queue(1, 0, "", 0); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Queue() all rooms by owner error = %v, want ErrForbidden", err)
+	}
+	items, err := svc.Queue(5, 0, "", 0)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("Queue() = %+v, %v", items, err)
+	}
+	item := items[0]
+	if item.Reports != 2 || item.Categories[ReportSpam] != 1 || item.Categories[ReportHarassment] != 1 || item.Message.Content != "rude" {
+		t.Errorf("queue item = %+v, want two reports with original content", item)
+	}
+
+	if _, err := svc.Claim(5, item.ID); err != nil {
+		t.Fatalf("Claim() error = %v", err)
+	}
+	if _, err := svc.Claim(1, item.ID); !errors.Is(err, ErrReviewClaimed) {
+		t.Errorf("Claim() by owner error = %v, want ErrReviewClaimed", err)
+	}
+	if _, err := svc.Resolve(1, item.ID, ResolveInput{Resolution: ResolutionRemoved}); !errors.Is(err, ErrReviewClaimed) {
+		t.Errorf("Resolve() by owner error = %v, want ErrReviewClaimed", err)
+	}
+
+	// 驳回举报并禁言作者：消息恢复显示，处罚关联到条目上。
+	got, err := svc.Resolve(5, item.ID, ResolveInput{Resolution: ResolutionDismissed, Note: "borderline", Action: &ReviewAction{Kind: SanctionMute, Reason: "tone"}})
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if got.Status != ReviewResolved || got.SanctionID == nil || got.ResolvedBy != 5 {
+		t.Errorf("resolved item = %+v", got)
+	}
+	if err := modSvc.Check(2, roomID, true); !errors.Is(err, ErrUserMuted) {
+		t.Errorf("Check() after resolve = %v, want ErrUserMuted", err)
+	}
+	if len(rec.updates) != 2 || rec.updates[1].Hidden || rec.updates[1].Content != "rude" {
+		t.Errorf("updates = %+v, want restored message", rec.updates)
+	}
+	if _, err := svc.Resolve(5, item.ID, ResolveInput{Resolution: ResolutionRemoved}); !errors.Is(err, ErrReviewResolved) {
+		t.Errorf("Resolve() twice error = %v, want ErrReviewResolved", err)
+	}
+	if items, _ := svc.Queue(5, roomID, "", 0); len(items) != 0 {
+		t.Errorf("pending queue = %+v, want empty", items)
+	}
+}
+
+func TestReportService_FilterFlagsQueued(t *testing.T) {
+	gdb := setupTestDB(t)
+	roomID := createTestRoom(t, gdb, "general")
+	msgSvc := NewMessageService(gdb)
+	svc := NewReportService(gdb, msgSvc, NewModerationService(gdb, nil, nil), nil, 0)
+	if err := msgSvc.Filters().Set(1, roomID, filter.Config{Links: &filter.LinksConfig{Deny: []string{"evil.com"}, Action: filter.Flag}}); err != nil {
+		t.Fatalf("set filters: %v", err)
+	}
+	msg, _, err := msgSvc.Create(CreateMessageInput{RoomID: roomID, UserID: 2, Content: "https://evil.com"})
+	if err != nil {
+		t.Fatalf("create message: %v", err)
+	}
+	items, err := svc.Queue(1, roomID, ReviewOpen, 0)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("Queue() = %+v, %v", items, err)
+	}
+	if it := items[0]; it.MessageID != msg.ID || it.Flags != 1 || it.Reports != 0 || len(it.Filters) != 1 || it.Filters[0] != "links" {
+		t.Errorf("queue item = %+v, want one links flag", it)
+	}
+}
+
+func TestReportService_ReportAfterResolve(t *testing.T) {
+	gdb := setupTestDB(t)
+	for _, name := range []string{"owner", "author", "r1", "r2"} {
+		if err := gdb.Create(&models.User{Username: name, PasswordHash: "x"}).Error; err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+	}
+	roomID := createTestRoom(t, gdb, "general")
+	msgSvc := NewMessageService(gdb)
+	svc := NewReportService(gdb, msgSvc, NewModerationService(gdb, nil, nil), nil, 0)
+	msg, _, err := msgSvc.Create(CreateMessageInput{RoomID: roomID, UserID: 2, Content: "rude"})
+	if err != nil {
+		t.Fatalf("create message: %v", err)
+	}
+	if _, err := svc.Report(3, msg.ID, ReportSpam, ""); err != nil {
+		t.Fatalf("first report: %v", err)
+	}
+	items, _ := svc.Queue(1, roomID, "", 0)
+	if len(items) != 1 {
+		t.Fatalf("Queue() = %+v, want one item", items)
+	}
+	if _, err := svc.Resolve(1, items[0].ID, ResolveInput{Resolution: ResolutionDismissed}); err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+
+	// 已处理的条目保留为历史，新的举报进入一个新条目。
+	if _, err := svc.Report(4, msg.ID, ReportHarassment, ""); err != nil {
+		t.Fatalf("report after resolve: %v", err)
+	}
+	items, err = svc.Queue(1, roomID, "", 0)
+	if err != nil || len(items) != 1 || items[0].ID == 0 || items[0].Reports != 1 || items[0].Status != ReviewOpen {
+		t.Fatalf("Queue() = %+v, %v, want one new open item", items, err)
+	}
+	if resolved, _ := svc.Queue(1, roomID, ReviewResolved, 0); len(resolved) != 1 || resolved[0].ID == items[0].ID {
+		t.Errorf("resolved history = %+v", resolved)
+	}
+	// 每条消息最多一个未处理条目，并发的首次举报只能合并到同一条目。
+	dup := models.ReviewItem{MessageID: msg.ID, RoomID: roomID, Status: ReviewOpen, Reports: 1}
+	if err := gdb.Create(&dup).Error; err == nil {
+		t.Errorf("second pending item for message created: %+v", dup)
+	}
+}
+
+func TestReportService_ResolveConflictAppliesNoSanction(t *testing.T) {
+	gdb := setupTestDB(t)
+	for _, name := range []string{"owner", "author", "r1", "mod"} {
+		if err := gdb.Create(&models.User{Username: name, PasswordHash: "x"}).Error; err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+	}
+	roomID := createTestRoom(t, gdb, "general")
+	msgSvc := NewMessageService(gdb)
+	svc := NewReportService(gdb, msgSvc, NewModerationService(gdb, []uint{4}, nil), nil, 0)
+	msg, _, err := msgSvc.Create(CreateMessageInput{RoomID: roomID, UserID: 2, Content: "rude"})
+	if err != nil {
+		t.Fatalf("create message: %v", err)
+	}
+	if _, err := svc.Report(3, msg.ID, ReportSpam, ""); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	items, _ := svc.Queue(1, roomID, "", 0)
+	if len(items) != 1 {
+		t.Fatalf("Queue() = %+v, want one item", items)
+	}
+
+	// 另一位管理员恰好在 Resolve 读取条目之后认领了它。
+	claimed := false
+	if err := gdb.Callback().Update().Before("gorm:update").Register("test:claim", func(d *gorm.DB) {
+		if claimed || d.Statement.Table != "review_items" {
+			return
+		}
+		claimed = true
+		d.Session(&gorm.Session{NewDB: true}).Exec("UPDATE review_items SET status = ?, claimed_by = ? WHERE id = ?", ReviewClaimed, 4, items[0].ID)
+	}); err != nil {
+		t.Fatalf("register callback: %v", err)
+	}
+	_, err = svc.Resolve(1, items[0].ID, ResolveInput{Resolution: ResolutionRemoved, Action: &ReviewAction{Kind: SanctionBan}})
+	if !errors.Is(err, ErrReviewClaimed) {
+		t.Fatalf("Resolve() error = %v, want ErrReviewClaimed", err)
+	}
+	var count int64
+	if err := gdb.Model(&models.Sanction{}).Count(&count).Error; err != nil || count != 0 {
+		t.Errorf("sanctions = %d, %v, want none after conflict", count, err)
+	}
+}
@@ -2,26 +2,42 @@ package service
 
 import (
 	"chatroom/internal/models"
-	"chatroom/internal/ws"
+	"chatroom/internal/presence"
 
 	"gorm.io/gorm"
 )
 
+// Presence 提供房间在线人数与在线成员，由 ws.Hub 实现；以接口依赖避免 service 与 ws 互相引用。
+type Presence interface {
+	OnlineCounts(roomIDs []uint) (map[uint]int, error)
+	Members(roomID uint) ([]presence.Member, error)
+}
+
 // RoomService 封装房间相关的业务逻辑。
 type RoomService struct {
-	db  *gorm.DB
-	hub *ws.Hub
+	db       *gorm.DB
+	presence Presence
+	statuses *StatusService
+}
+
+func NewRoomService(db *gorm.DB, p Presence) *RoomService {
+	return &RoomService{db: db, presence: p, statuses: NewStatusService(db, nil)}
 }
 
-func NewRoomService(db *gorm.DB, hub *ws.Hub) *RoomService {
-	return &RoomService{db: db, hub: hub}
+// RoomPresenceDTO 是房间在线成员列表。
+type RoomPresenceDTO struct {
+	RoomID  uint              `json:"room_id"`
+	Online  int               `json:"online"`
+	Members []presence.Member `json:"members"`
 }
 
 // RoomDTO 是对外输出的房间数据。
 type RoomDTO struct {
-	ID     uint   `json:"id"`
-	Name   string `json:"name"`
-	Online int    `json:"online"`
+	ID              uint   `json:"id"`
+	Name            string `json:"name"`
+	Online          int    `json:"online"`
+	SlowModeSeconds int    `json:"slow_mode_seconds"`
+	Announcement    bool   `json:"announcement"`
 }
 
 // Create 创建新房间，房间名不可重复。
@@ -49,13 +65,36 @@ func (s *RoomService) List(limit int) ([]RoomDTO, error) {
 	if err := s.db.Order("id desc").Limit(limit).Find(&rooms).Error; err != nil {
 		return nil, err
 	}
+	ids := make([]uint, 0, len(rooms))
+	for _, r := range rooms {
+		ids = append(ids, r.ID)
+	}
+	counts, err := s.presence.OnlineCounts(ids)
+	if err != nil {
+		return nil, err
+	}
 	out := make([]RoomDTO, 0, len(rooms))
 	for _, r := range rooms {
-		out = append(out, RoomDTO{ID: r.ID, Name: r.Name, Online: s.hub.Online(r.ID)})
+		out = append(out, RoomDTO{ID: r.ID, Name: r.Name, Online: counts[r.ID], SlowModeSeconds: r.SlowModeSeconds, Announcement: r.Announcement})
 	}
 	return out, nil
 }
 
+// Presence 返回房间的在线成员，房间不存在时返回 ErrRoomNotFound。
+func (s *RoomService) Presence(roomID uint) (*RoomPresenceDTO, error) {
+	if _, err := s.Exists(roomID); err != nil {
+		return nil, err
+	}
+	members, err := s.presence.Members(roomID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.statuses.ApplyStatuses(members); err != nil {
+		return nil, err
+	}
+	return &RoomPresenceDTO{RoomID: roomID, Online: len(members), Members: members}, nil
+}
+
 // Exists 检查房间是否存在。
 func (s *RoomService) Exists(roomID uint) (*models.Room, error) {
 	var room models.Room
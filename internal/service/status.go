@@ -0,0 +1,147 @@
+package service
+
+import (
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"chatroom/internal/models"
+	"chatroom/internal/presence"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxStatusTextLength 是自定义状态文字的最大字符数。
+	MaxStatusTextLength = 128
+	// MaxStatusExpiry 是手动状态最长的有效期。
+	MaxStatusExpiry = 7 * 24 * time.Hour
+)
+
+// StatusNotifier 在用户手动状态变化后向其所在房间推送 presence 事件，由 ws.Hub 实现。
+type StatusNotifier interface {
+	StatusChanged(userID uint, username string, st presence.UserStatus)
+}
+
+// StatusService 管理用户手动设置的状态。
+type StatusService struct {
+	db       *gorm.DB
+	notifier StatusNotifier
+}
+
+func NewStatusService(db *gorm.DB, notifier StatusNotifier) *StatusService {
+	return &StatusService{db: db, notifier: notifier}
+}
+
+// StatusDTO 是用户当前生效的手动状态。
+type StatusDTO struct {
+	Status     string     `json:"status"`
+	StatusText string     `json:"status_text"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+// SetStatusInput 是设置状态的参数；ExpiresIn 为 0 表示一直有效。
+type SetStatusInput struct {
+	Status    string
+	Text      string
+	ExpiresIn time.Duration
+}
+
+// Load 读取用户的手动状态与用户名。
+func (s *StatusService) Load(userID uint) (string, presence.UserStatus, error) {
+	var user models.User
+	if err := s.db.Select("id", "username", "status", "status_text", "status_expires_at").First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", presence.UserStatus{}, ErrUserNotFound
+		}
+		return "", presence.UserStatus{}, err
+	}
+	return user.Username, userStatusOf(user), nil
+}
+
+// Get 返回用户当前生效的手动状态，已过期的状态视为 available。
+func (s *StatusService) Get(userID uint) (*StatusDTO, error) {
+	_, st, err := s.Load(userID)
+	if err != nil {
+		return nil, err
+	}
+	return statusDTO(st, time.Now()), nil
+}
+
+// Set 校验并保存用户的手动状态，随后通知其所在的房间。
+func (s *StatusService) Set(userID uint, in SetStatusInput) (*StatusDTO, error) {
+	switch in.Status {
+	case presence.ManualAvailable, presence.ManualBusy, presence.ManualAway:
+	default:
+		return nil, ErrInvalidStatus
+	}
+	if utf8.RuneCountInString(in.Text) > MaxStatusTextLength {
+		return nil, ErrStatusTextTooLong
+	}
+	if in.ExpiresIn < 0 || in.ExpiresIn > MaxStatusExpiry {
+		return nil, ErrInvalidStatusExpiry
+	}
+
+	st := presence.UserStatus{Status: in.Status, Text: in.Text}
+	if in.ExpiresIn > 0 {
+		exp := time.Now().UTC().Add(in.ExpiresIn)
+		st.ExpiresAt = &exp
+	}
+	res := s.db.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"status":            st.Status,
+		"status_text":       st.Text,
+		"status_expires_at": st.ExpiresAt,
+	})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrUserNotFound
+	}
+
+	if s.notifier != nil {
+		var user models.User
+		if err := s.db.Select("id", "username").First(&user, userID).Error; err == nil {
+			s.notifier.StatusChanged(userID, user.Username, st)
+		}
+	}
+	return statusDTO(st, time.Now()), nil
+}
+
+// ApplyStatuses 根据成员的手动状态与空闲标记填充展示状态。
+func (s *StatusService) ApplyStatuses(members []presence.Member) error {
+	if len(members) == 0 {
+		return nil
+	}
+	ids := make([]uint, 0, len(members))
+	for _, m := range members {
+		ids = append(ids, m.UserID)
+	}
+	var users []models.User
+	if err := s.db.Select("id", "status", "status_text", "status_expires_at").Where("id IN ?", ids).Find(&users).Error; err != nil {
+		return err
+	}
+	byID := make(map[uint]presence.UserStatus, len(users))
+	for _, u := range users {
+		byID[u.ID] = userStatusOf(u)
+	}
+	now := time.Now()
+	for i := range members {
+		members[i].Apply(byID[members[i].UserID], now)
+	}
+	return nil
+}
+
+func userStatusOf(u models.User) presence.UserStatus {
+	return presence.UserStatus{Status: u.Status, Text: u.StatusText, ExpiresAt: u.StatusExpiresAt}
+}
+
+func statusDTO(st presence.UserStatus, now time.Time) *StatusDTO {
+	if st.ExpiresAt != nil && !now.Before(*st.ExpiresAt) {
+		st = presence.UserStatus{Status: presence.ManualAvailable}
+	}
+	if st.Status == "" {
+		st.Status = presence.ManualAvailable
+	}
+	return &StatusDTO{Status: st.Status, StatusText: st.Text, ExpiresAt: st.ExpiresAt}
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"chatroom/internal/models"
+	"chatroom/internal/presence"
+)
+
+type recordingNotifier struct {
+	calls []presence.UserStatus
+}
+
+func (n *recordingNotifier) StatusChanged(_ uint, _ string, st presence.UserStatus) {
+	n.calls = append(n.calls, st)
+}
+
+func TestStatusService_Set(t *testing.T) {
+	gdb := setupTestDB(t)
+	user := models.User{Username: "alice", PasswordHash: "x"}
+	if err := gdb.Create(&user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	n := &recordingNotifier{}
+	svc := NewStatusService(gdb, n)
+
+	if st, err := svc.Get(user.ID); err != nil || st.Status != presence.ManualAvailable {
+		t.Fatalf("Get() = %+v, %v, want available by default", st, err)
+	}
+
+	st, err := svc.Set(user.ID, SetStatusInput{Status: "busy", Text: "in a meeting", ExpiresIn: time.Hour})
+	if err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if st.Status != "busy" || st.StatusText != "in a meeting" || st.ExpiresAt == nil {
+		t.Errorf("Set() = %+v, want busy with text and expiry", st)
+	}
+	if len(n.calls) != 1 || n.calls[0].Status != "busy" {
+		t.Errorf("notifier calls = %+v, want one busy", n.calls)
+	}
+
+	// 过期后视为 available。
+	past := time.Now().UTC().Add(-time.Minute)
+	if err := gdb.Model(&models.User{}).Where("id = ?", user.ID).Update("status_expires_at", past).Error; err != nil {
+		t.Fatalf("expire status: %v", err)
+	}
+	if st, _ := svc.Get(user.ID); st.Status != presence.ManualAvailable || st.StatusText != "" {
+		t.Errorf("Get() after expiry = %+v, want available without text", st)
+	}
+}
+
+func TestStatusService_Set_Validation(t *testing.T) {
+	svc := NewStatusService(setupTestDB(t), nil)
+	tests := []struct {
+		name string
+		in   SetStatusInput
+		want error
+	}{
+		{"unknown status", SetStatusInput{Status: "invisible"}, ErrInvalidStatus},
+		{"text too long", SetStatusInput{Status: "away", Text: strings.Repeat("字", MaxStatusTextLength+1)}, ErrStatusTextTooLong},
+		{"expiry too long", SetStatusInput{Status: "away", ExpiresIn: MaxStatusExpiry + time.Second}, ErrInvalidStatusExpiry},
+		{"unknown user", SetStatusInput{Status: "away"}, ErrUserNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := svc.Set(404, tt.in); !errors.Is(err, tt.want) {
+				t.Errorf("Set() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"chatroom/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// TicketTTL 是 WebSocket 票据的有效期。
+const TicketTTL = 30 * time.Second
+
+// TicketService 签发并兑换建立 WebSocket 连接用的一次性票据，避免把访问令牌放进 URL。
+type TicketService struct {
+	db *gorm.DB
+}
+
+func NewTicketService(db *gorm.DB) *TicketService {
+	return &TicketService{db: db}
+}
+
+// TicketDTO 是签发票据接口返回的数据。
+type TicketDTO struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expires_at"`
+	ExpiresIn int       `json:"expires_in"`
+	RoomID    uint      `json:"room_id,omitempty"`
+}
+
+// Issue 为用户签发票据；roomID 非零时票据只能用于连接该房间。
+// sessionExpiresAt 是签发票据所用访问令牌的过期时间，零值表示不限制；票据本身也不会晚于它过期。
+func (s *TicketService) Issue(userID, roomID uint, sessionExpiresAt time.Time) (*TicketDTO, error) {
+	if roomID != 0 {
+		var room models.Room
+		if err := s.db.Select("id").First(&room, roomID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrRoomNotFound
+			}
+			return nil, err
+		}
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	ticket := hex.EncodeToString(b)
+	now := time.Now()
+	// 顺带清理已过期的票据，表中只保留最近 30 秒内签发的记录。
+	if err := s.db.Where("expires_at <= ?", now).Delete(&models.WSTicket{}).Error; err != nil {
+		return nil, err
+	}
+	rec := models.WSTicket{ID: hashTicket(ticket), UserID: userID, RoomID: roomID, ExpiresAt: now.Add(TicketTTL)}
+	if !sessionExpiresAt.IsZero() {
+		if !sessionExpiresAt.After(now) {
+			return nil, ErrInvalidTicket
+		}
+		if sessionExpiresAt.Before(rec.ExpiresAt) {
+			rec.ExpiresAt = sessionExpiresAt
+		}
+		rec.SessionExpiresAt = &sessionExpiresAt
+	}
+	if err := s.db.Create(&rec).Error; err != nil {
+		return nil, err
+	}
+	return &TicketDTO{Ticket: ticket, ExpiresAt: rec.ExpiresAt, ExpiresIn: int(rec.ExpiresAt.Sub(now) / time.Second), RoomID: roomID}, nil
+}
+
+// Redeem 兑换票据并立即作废，返回票据记录（绑定的用户、房间与会话过期时间）；
+// 票据不存在、已过期或已被使用时返回 ErrInvalidTicket。
+func (s *TicketService) Redeem(ticket string) (*models.WSTicket, error) {
+	id := hashTicket(ticket)
+	var rec models.WSTicket
+	if err := s.db.Where("id = ? AND expires_at > ?", id, time.Now()).First(&rec).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidTicket
+		}
+		return nil, err
+	}
+	res := s.db.Where("id = ?", id).Delete(&models.WSTicket{})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	// 并发兑换同一票据时只有删除成功的一方有效。
+	if res.RowsAffected != 1 {
+		return nil, ErrInvalidTicket
+	}
+	return &rec, nil
+}
+
+func hashTicket(ticket string) string {
+	sum := sha256.Sum256([]byte(ticket))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"chatroom/internal/models"
+)
+
+func TestTicketService_RedeemOnce(t *testing.T) {
+	gdb := setupTestDB(t)
+	svc := NewTicketService(gdb)
+	roomID := createTestRoom(t, gdb, "general")
+
+	session := time.Now().Add(time.Hour)
+	dto, err := svc.Issue(7, roomID, session)
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	if dto.Ticket == "" || dto.RoomID != roomID || dto.ExpiresIn != 30 {
+		t.Fatalf("Issue() = %+v, want ticket bound to room %d", dto, roomID)
+	}
+	var stored models.WSTicket
+	if err := gdb.First(&stored).Error; err != nil || stored.ID == dto.Ticket {
+		t.Fatalf("stored ticket = %+v, %v, want hashed id", stored, err)
+	}
+
+	rec, err := svc.Redeem(dto.Ticket)
+	if err != nil || rec.UserID != 7 || rec.RoomID != roomID {
+		t.Fatalf("Redeem() = %+v, %v, want user 7 in room %d", rec, err, roomID)
+	}
+	if rec.SessionExpiresAt == nil || !rec.SessionExpiresAt.Equal(session) {
+		t.Errorf("SessionExpiresAt = %v, want %v", rec.SessionExpiresAt, session)
+	}
+	if _, err := svc.Redeem(dto.Ticket); !errors.Is(err, ErrInvalidTicket) {
+		t.Errorf("second Redeem() error = %v, want ErrInvalidTicket", err)
+	}
+	if _, err := svc.Redeem("bogus"); !errors.Is(err, ErrInvalidTicket) {
+		t.Errorf("Redeem(bogus) error = %v, want ErrInvalidTicket", err)
+	}
+}
+
+func TestTicketService_Expired(t *testing.T) {
+	gdb := setupTestDB(t)
+	svc := NewTicketService(gdb)
+
+	dto, err := svc.Issue(1, 0, time.Time{})
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	gdb.Model(&models.WSTicket{}).Where("user_id = ?", 1).Update("expires_at", time.Now().Add(-time.Second))
+	if _, err := svc.Redeem(dto.Ticket); !errors.Is(err, ErrInvalidTicket) {
+		t.Errorf("Redeem(expired) error = %v, want ErrInvalidTicket", err)
+	}
+
+	// 票据不会晚于签发它的访问令牌过期。
+	session := time.Now().Add(10 * time.Second)
+	if dto, err := svc.Issue(1, 0, session); err != nil || !dto.ExpiresAt.Equal(session) || dto.ExpiresIn > 10 {
+		t.Errorf("Issue(near expiry) = %+v, %v, want ticket expiring with the session", dto, err)
+	}
+	if _, err := svc.Issue(1, 0, time.Now().Add(-time.Second)); !errors.Is(err, ErrInvalidTicket) {
+		t.Errorf("Issue(expired session) error = %v, want ErrInvalidTicket", err)
+	}
+	if _, err := svc.Issue(1, 999, time.Time{}); !errors.Is(err, ErrRoomNotFound) {
+		t.Errorf("Issue(missing room) error = %v, want ErrRoomNotFound", err)
+	}
+}
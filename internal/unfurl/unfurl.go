@@ -0,0 +1,414 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// 抓取过程中可能返回的错误，调用方通常只需记录日志即可。
+var (
+	ErrBlockedAddress     = errors.New("unfurl: destination address is not allowed")
+	ErrUnsupportedScheme  = errors.New("unfurl: unsupported url scheme")
+	ErrUnsupportedContent = errors.New("unfurl: unsupported content type")
+	ErrNoMetadata         = errors.New("unfurl: no metadata found")
+)
+
+// Preview 描述从网页中提取到的链接预览信息。
+type Preview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+}
+
+// Options 控制抓取的超时、大小上限与缓存策略。
+type Options struct {
+	Timeout      time.Duration
+	MaxBytes     int64
+	MaxRedirects int
+	CacheTTL     time.Duration
+	CacheSize    int
+	UserAgent    string
+	// AllowPrivate 允许访问内网与回环地址，仅用于测试。
+	AllowPrivate bool
+}
+
+func (o Options) withDefaults() Options {
+	if o.Timeout <= 0 {
+		o.Timeout = 5 * time.Second
+	}
+	if o.MaxBytes <= 0 {
+		o.MaxBytes = 512 << 10
+	}
+	if o.MaxRedirects <= 0 {
+		o.MaxRedirects = 3
+	}
+	if o.CacheTTL <= 0 {
+		o.CacheTTL = time.Hour
+	}
+	if o.CacheSize <= 0 {
+		o.CacheSize = 1024
+	}
+	if o.UserAgent == "" {
+		o.UserAgent = "ChatRoomBot/1.0 (+link preview)"
+	}
+	return o
+}
+
+type cacheEntry struct {
+	preview *Preview
+	err     error
+	expires time.Time
+}
+
+// Unfurler 负责抓取网页元数据，内置 SSRF 防护与结果缓存，可被多个 goroutine 并发使用。
+type Unfurler struct {
+	opts   Options
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+// New 创建 Unfurler，未设置的选项使用保守的默认值。
+func New(opts Options) *Unfurler {
+	opts = opts.withDefaults()
+	u := &Unfurler{opts: opts, cache: make(map[string]cacheEntry)}
+
+	dialer := &net.Dialer{Timeout: opts.Timeout}
+	if !opts.AllowPrivate {
+		// 在建立连接前校验解析后的真实 IP，可同时防御重定向与 DNS rebinding。
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isBlockedIP(ip) {
+				return ErrBlockedAddress
+			}
+			return nil
+		}
+	}
+	transport := &http.Transport{
+		// 不走代理，否则连接目标变成代理地址，IP 校验会失效。
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   opts.Timeout,
+		ResponseHeaderTimeout: opts.Timeout,
+		MaxIdleConns:          16,
+		IdleConnTimeout:       30 * time.Second,
+	}
+	u.client = &http.Client{
+		Transport: transport,
+		Timeout:   opts.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= opts.MaxRedirects {
+				return fmt.Errorf("unfurl: stopped after %d redirects", opts.MaxRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return ErrUnsupportedScheme
+			}
+			return nil
+		},
+	}
+	return u
+}
+
+// Fetch 抓取指定 URL 的预览信息，成功与失败的结果都会按 TTL 缓存。
+func (u *Unfurler) Fetch(ctx context.Context, rawURL string) (*Preview, error) {
+	if p, err, ok := u.cached(rawURL); ok {
+		return p, err
+	}
+	p, err := u.fetch(ctx, rawURL)
+	// 调用方取消的请求不缓存，避免把偶发超时固化下来。
+	if ctx.Err() == nil {
+		u.store(rawURL, p, err)
+	}
+	return p, err
+}
+
+func (u *Unfurler) cached(key string) (*Preview, error, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	e, ok := u.cache[key]
+	if !ok {
+		return nil, nil, false
+	}
+	if time.Now().After(e.expires) {
+		delete(u.cache, key)
+		return nil, nil, false
+	}
+	return e.preview, e.err, true
+}
+
+func (u *Unfurler) store(key string, p *Preview, err error) {
+	ttl := u.opts.CacheTTL
+	if err != nil {
+		// 失败结果只短暂缓存，防止反复请求同一个坏链接。
+		ttl = ttl / 10
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if len(u.cache) >= u.opts.CacheSize {
+		now := time.Now()
+		for k, e := range u.cache {
+			if now.After(e.expires) {
+				delete(u.cache, k)
+			}
+		}
+		// 仍然满了就随机淘汰一项，保证内存有上界。
+		for k := range u.cache {
+			if len(u.cache) < u.opts.CacheSize {
+				break
+			}
+			delete(u.cache, k)
+		}
+	}
+	u.cache[key] = cacheEntry{preview: p, err: err, expires: time.Now().Add(ttl)}
+}
+
+func (u *Unfurler) fetch(ctx context.Context, rawURL string) (*Preview, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return nil, ErrUnsupportedScheme
+	}
+	if !u.opts.AllowPrivate {
+		if ip := net.ParseIP(target.Hostname()); ip != nil && isBlockedIP(ip) {
+			return nil, ErrBlockedAddress
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", u.opts.UserAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrBlockedAddress) {
+			return nil, ErrBlockedAddress
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("unfurl: unexpected status %d", resp.StatusCode)
+	}
+	mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mt != "text/html" && mt != "application/xhtml+xml" {
+		return nil, ErrUnsupportedContent
+	}
+
+	p := parseHTML(io.LimitReader(resp.Body, u.opts.MaxBytes), resp.Request.URL)
+	if p.Title == "" && p.Description == "" {
+		return nil, ErrNoMetadata
+	}
+	p.URL = rawURL
+	return p, nil
+}
+
+// parseHTML 只扫描 <head>，提取 OpenGraph / Twitter Card 与 <title>。
+func parseHTML(r io.Reader, base *url.URL) *Preview {
+	z := html.NewTokenizer(r)
+	meta := make(map[string]string)
+	var title string
+	inTitle := false
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return buildPreview(meta, title, base)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "body":
+				return buildPreview(meta, title, base)
+			case "title":
+				inTitle = tt == html.StartTagToken
+			case "meta":
+				var key, content string
+				for hasAttr {
+					var k, v []byte
+					k, v, hasAttr = z.TagAttr()
+					switch string(k) {
+					case "property", "name":
+						key = strings.ToLower(string(v))
+					case "content":
+						content = string(v)
+					}
+				}
+				if key != "" && content != "" {
+					if _, ok := meta[key]; !ok {
+						meta[key] = content
+					}
+				}
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "head":
+				return buildPreview(meta, title, base)
+			case "title":
+				inTitle = false
+			}
+		case html.TextToken:
+			if inTitle && title == "" {
+				title = string(z.Text())
+			}
+		}
+	}
+}
+
+func buildPreview(meta map[string]string, title string, base *url.URL) *Preview {
+	pick := func(keys ...string) string {
+		for _, k := range keys {
+			if v := strings.TrimSpace(meta[k]); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	p := &Preview{
+		Title:       pick("og:title", "twitter:title"),
+		Description: pick("og:description", "twitter:description", "description"),
+		Image:       pick("og:image", "og:image:url", "twitter:image"),
+		SiteName:    pick("og:site_name"),
+	}
+	if p.Title == "" {
+		p.Title = strings.TrimSpace(title)
+	}
+	if p.Image != "" && base != nil {
+		if ref, err := url.Parse(p.Image); err == nil {
+			abs := base.ResolveReference(ref)
+			if abs.Scheme == "http" || abs.Scheme == "https" {
+				p.Image = abs.String()
+			} else {
+				p.Image = ""
+			}
+		} else {
+			p.Image = ""
+		}
+	}
+	p.Title = truncate(p.Title, 300)
+	p.Description = truncate(p.Description, 1000)
+	p.SiteName = truncate(p.SiteName, 200)
+	if len(p.Image) > 2048 {
+		p.Image = ""
+	}
+	return p
+}
+
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max])
+}
+
+// blockedNets 是不允许抓取的网段，按 IANA IPv4/IPv6 Special-Purpose Address Registry
+// 中不可全局路由的条目整理，另加组播以及内嵌 IPv4 地址、可能绕到内网的 NAT64 与 6to4 前缀。
+// IPv4 映射地址（::ffff:0:0/96）先转换成 IPv4 再比对。
+var blockedNets = mustParseCIDRs(
+	// IPv4
+	"0.0.0.0/8",          // 本网络，Linux 上会连到本机
+	"10.0.0.0/8",         // 私有地址
+	"100.64.0.0/10",      // 运营商级 NAT
+	"127.0.0.0/8",        // 回环
+	"169.254.0.0/16",     // 链路本地，含云厂商元数据地址
+	"172.16.0.0/12",      // 私有地址
+	"192.0.0.0/24",       // IETF 协议分配
+	"192.0.2.0/24",       // 文档 TEST-NET-1
+	"192.88.99.0/24",     // 6to4 中继任播（已废弃）
+	"192.168.0.0/16",     // 私有地址
+	"198.18.0.0/15",      // 基准测试
+	"198.51.100.0/24",    // 文档 TEST-NET-2
+	"203.0.113.0/24",     // 文档 TEST-NET-3
+	"224.0.0.0/4",        // 组播
+	"240.0.0.0/4",        // 保留
+	"255.255.255.255/32", // 受限广播
+	// IPv6
+	"::/128",         // 未指定地址
+	"::1/128",        // 回环
+	"64:ff9b::/96",   // NAT64 知名前缀
+	"64:ff9b:1::/48", // 本地 NAT64
+	"100::/64",       // 仅丢弃
+	"2001::/23",      // IETF 协议分配，含 Teredo
+	"2001:db8::/32",  // 文档
+	"2002::/16",      // 6to4
+	"3fff::/20",      // 文档
+	"5f00::/16",      // SRv6 SID
+	"fc00::/7",       // 唯一本地地址
+	"fe80::/10",      // 链路本地
+	"fec0::/10",      // 站点本地（已废弃）
+	"ff00::/8",       // 组播
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// isBlockedIP 判断地址是否属于内网、回环、链路本地等不允许访问的范围。
+func isBlockedIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, n := range blockedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"'` + "`" + `]+`)
+
+// ExtractURLs 从消息文本中按出现顺序提取去重后的 http(s) 链接，最多 max 个。
+func ExtractURLs(text string, max int) []string {
+	matches := urlPattern.FindAllString(text, -1)
+	seen := make(map[string]struct{}, len(matches))
+	out := make([]string, 0, len(matches))
+	for _, m := range matches {
+		m = strings.TrimRight(m, ".,;:!?)]}")
+		if len(m) > 2048 {
+			continue
+		}
+		if _, ok := seen[m]; ok {
+			continue
+		}
+		seen[m] = struct{}{}
+		out = append(out, m)
+		if max > 0 && len(out) >= max {
+			break
+		}
+	}
+	return out
+}
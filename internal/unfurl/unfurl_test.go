@@ -0,0 +1,249 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testPage = `<!doctype html>
+<html><head>
+<title>Fallback Title</title>
+<meta property="og:title" content="Hello OG">
+<meta property="og:description" content="A description">
+<meta property="og:image" content="/img/cover.png">
+<meta property="og:site_name" content="Example">
+</head><body><p>ignored</p></body></html>`
+
+func TestFetch_OpenGraph(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, testPage)
+	}))
+	defer srv.Close()
+
+	u := New(Options{AllowPrivate: true})
+	p, err := u.Fetch(context.Background(), srv.URL+"/post")
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if p.Title != "Hello OG" {
+		t.Errorf("Title = %q, want %q", p.Title, "Hello OG")
+	}
+	if p.Description != "A description" {
+		t.Errorf("Description = %q, want %q", p.Description, "A description")
+	}
+	if p.Image != srv.URL+"/img/cover.png" {
+		t.Errorf("Image = %q, want absolute url", p.Image)
+	}
+	if p.SiteName != "Example" {
+		t.Errorf("SiteName = %q, want Example", p.SiteName)
+	}
+	if p.URL != srv.URL+"/post" {
+		t.Errorf("URL = %q, want %q", p.URL, srv.URL+"/post")
+	}
+}
+
+func TestFetch_TitleFallback(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><head><title> Plain page </title></head><body></body></html>`)
+	}))
+	defer srv.Close()
+
+	p, err := New(Options{AllowPrivate: true}).Fetch(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if p.Title != "Plain page" {
+		t.Errorf("Title = %q, want %q", p.Title, "Plain page")
+	}
+}
+
+func TestFetch_BlocksPrivateAddresses(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, testPage)
+	}))
+	defer srv.Close()
+
+	u := New(Options{})
+	_, err := u.Fetch(context.Background(), srv.URL)
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("Fetch() error = %v, want ErrBlockedAddress", err)
+	}
+	// 通过域名解析到回环地址同样需要被拦截。
+	port := srv.Listener.Addr().(*net.TCPAddr).Port
+	_, err = u.Fetch(context.Background(), fmt.Sprintf("http://localhost:%d/", port))
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("Fetch(localhost) error = %v, want ErrBlockedAddress", err)
+	}
+	if atomic.LoadInt32(&hits) != 0 {
+		t.Errorf("server received %d requests, want 0", hits)
+	}
+}
+
+func TestFetch_RejectsNonHTML(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"title":"nope"}`)
+	}))
+	defer srv.Close()
+
+	_, err := New(Options{AllowPrivate: true}).Fetch(context.Background(), srv.URL)
+	if !errors.Is(err, ErrUnsupportedContent) {
+		t.Errorf("Fetch() error = %v, want ErrUnsupportedContent", err)
+	}
+}
+
+func TestFetch_SizeCap(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><head>")
+		fmt.Fprint(w, strings.Repeat("<!-- padding -->", 1024))
+		fmt.Fprint(w, `<meta property="og:title" content="Too late"></head></html>`)
+	}))
+	defer srv.Close()
+
+	_, err := New(Options{AllowPrivate: true, MaxBytes: 1024}).Fetch(context.Background(), srv.URL)
+	if !errors.Is(err, ErrNoMetadata) {
+		t.Errorf("Fetch() error = %v, want ErrNoMetadata", err)
+	}
+}
+
+func TestFetch_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	start := time.Now()
+	_, err := New(Options{AllowPrivate: true, Timeout: 100 * time.Millisecond}).Fetch(context.Background(), srv.URL)
+	if err == nil {
+		t.Fatal("Fetch() should time out")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Fetch() took %v, timeout not enforced", elapsed)
+	}
+}
+
+func TestFetch_Cache(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, testPage)
+	}))
+	defer srv.Close()
+
+	u := New(Options{AllowPrivate: true})
+	for i := 0; i < 3; i++ {
+		if _, err := u.Fetch(context.Background(), srv.URL); err != nil {
+			t.Fatalf("Fetch() error = %v", err)
+		}
+	}
+	if got := atomic.LoadInt32(&hits); got != 1 {
+		t.Errorf("server hits = %d, want 1", got)
+	}
+}
+
+func TestFetch_UnsupportedScheme(t *testing.T) {
+	_, err := New(Options{}).Fetch(context.Background(), "file:///etc/passwd")
+	if !errors.Is(err, ErrUnsupportedScheme) {
+		t.Errorf("Fetch() error = %v, want ErrUnsupportedScheme", err)
+	}
+}
+
+func TestExtractURLs(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		max  int
+		want []string
+	}{
+		{"none", "hello world", 3, nil},
+		{"single", "see https://example.com/a.", 3, []string{"https://example.com/a"}},
+		{"dedupe", "http://a.io http://a.io http://b.io", 3, []string{"http://a.io", "http://b.io"}},
+		{"max", "http://a.io http://b.io http://c.io", 2, []string{"http://a.io", "http://b.io"}},
+		{"parens", "(https://example.com/x)", 3, []string{"https://example.com/x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ExtractURLs(tt.text, tt.max)
+			if len(got) != len(tt.want) {
+				t.Fatalf("ExtractURLs() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("ExtractURLs()[%d] = %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestIsBlockedIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"100.64.0.1", true},
+		{"0.0.0.0", true},
+		{"0.1.2.3", true},
+		{"64:ff9b::7f00:1", true},
+		{"64:ff9b::808:808", true},
+		{"::1", true},
+		{"fe80::1", true},
+		{"fd00::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::", true},
+		{"192.0.0.8", true},
+		{"192.0.2.1", true},
+		{"192.88.99.1", true},
+		{"198.18.0.1", true},
+		{"198.19.255.254", true},
+		{"198.51.100.7", true},
+		{"203.0.113.9", true},
+		{"224.0.0.1", true},
+		{"240.0.0.1", true},
+		{"255.255.255.255", true},
+		{"64:ff9b:1::a00:1", true},
+		{"100::1", true},
+		{"2001::1", true},
+		{"2001:db8::1", true},
+		{"2002:7f00:1::", true},
+		{"3fff::1", true},
+		{"5f00::1", true},
+		{"fec0::1", true},
+		{"ff02::1", true},
+		{"8.8.8.8", false},
+		{"198.20.0.1", false},
+		{"192.0.1.1", false},
+		{"2606:4700:4700::1111", false},
+		{"2001:4860:4860::8888", false},
+	}
+	for _, tt := range tests {
+		if got := isBlockedIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("isBlockedIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
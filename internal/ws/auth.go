@@ -0,0 +1,166 @@
+package ws
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"chatroom/internal/auth"
+	"chatroom/internal/config"
+	"chatroom/internal/models"
+	"chatroom/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// authTimeout 是未在握手中携带凭证时，等待首帧 auth 的最长时间。
+const authTimeout = 5 * time.Second
+
+// 握手鉴权失败的原因，直接作为 401 响应或关闭帧的描述。
+var (
+	errMissingCredentials = errors.New("missing token")
+	errInvalidToken       = errors.New("invalid token")
+	errUserNotFound       = errors.New("user not found")
+	errInvalidTicket      = errors.New("invalid ticket")
+	errTicketRoom         = errors.New("ticket not valid for this room")
+	errQueryTokenDisabled = errors.New("token query parameter is disabled, use a ticket")
+)
+
+// authenticator 校验实时连接的凭证：一次性 ticket、Authorization 头，或在配置允许时使用 token 查询参数。
+type authenticator struct {
+	db         *gorm.DB
+	secret     string
+	queryToken bool
+	tickets    *service.TicketService
+}
+
+func newAuthenticator(db *gorm.DB, cfg config.Config) *authenticator {
+	return &authenticator{db: db, secret: cfg.JWTSecret, queryToken: cfg.WSQueryTokenEnabled, tickets: service.NewTicketService(db)}
+}
+
+// identity 是鉴权结果；room 非零表示凭证绑定了房间，连接只能订阅该房间。
+// expiresAt 是凭证的过期时间，零值表示不限制。
+type identity struct {
+	user      models.User
+	room      uint
+	expiresAt time.Time
+}
+
+// fromRequest 从握手请求中读取凭证，请求未携带任何凭证时返回 errMissingCredentials。
+func (a *authenticator) fromRequest(c *gin.Context) (identity, error) {
+	if ticket := c.Query("ticket"); ticket != "" {
+		return a.fromTicket(ticket)
+	}
+	if token := c.Query("token"); token != "" {
+		if !a.queryToken {
+			return identity{}, errQueryTokenDisabled
+		}
+		return a.fromToken(token)
+	}
+	authz := c.GetHeader("Authorization")
+	if len(authz) > 7 && (authz[:7] == "Bearer " || authz[:7] == "bearer ") {
+		return a.fromToken(authz[7:])
+	}
+	return identity{}, errMissingCredentials
+}
+
+// fromFirstFrame 等待连接上的第一帧 auth 并校验其中的 token 或 ticket。
+func (a *authenticator) fromFirstFrame(conn *websocket.Conn, codec Codec) (identity, error) {
+	conn.SetReadLimit(4096)
+	_ = conn.SetReadDeadline(time.Now().Add(authTimeout))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return identity{}, errMissingCredentials
+	}
+	var in InboundMessage
+	if err := codec.Unmarshal(data, &in); err != nil || in.Type != TypeAuth {
+		return identity{}, errMissingCredentials
+	}
+	switch {
+	case in.Ticket != "":
+		return a.fromTicket(in.Ticket)
+	case in.Token != "":
+		return a.fromToken(in.Token)
+	}
+	return identity{}, errMissingCredentials
+}
+
+func (a *authenticator) fromToken(token string) (identity, error) {
+	claims, err := auth.ParseAccessToken(token, a.secret)
+	if err != nil {
+		return identity{}, errInvalidToken
+	}
+	id, err := a.load(claims.UserID, 0)
+	if err == nil && claims.ExpiresAt != nil {
+		id.expiresAt = claims.ExpiresAt.Time
+	}
+	return id, err
+}
+
+func (a *authenticator) fromTicket(ticket string) (identity, error) {
+	rec, err := a.tickets.Redeem(ticket)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidTicket) {
+			return identity{}, errInvalidTicket
+		}
+		return identity{}, err
+	}
+	// 连接沿用签发票据的访问令牌的过期时间，票据不能延长会话。
+	id, err := a.load(rec.UserID, rec.RoomID)
+	if err == nil && rec.SessionExpiresAt != nil {
+		id.expiresAt = *rec.SessionExpiresAt
+	}
+	return id, err
+}
+
+func (a *authenticator) load(userID, roomID uint) (identity, error) {
+	var user models.User
+	if err := a.db.First(&user, userID).Error; err != nil {
+		return identity{}, errUserNotFound
+	}
+	return identity{user: user, room: roomID}, nil
+}
+
+// bindRoom 校验凭证绑定的房间与请求的房间一致；未请求房间时自动订阅绑定的房间。
+func (id identity) bindRoom(roomID uint) (uint, error) {
+	if id.room == 0 {
+		return roomID, nil
+	}
+	if roomID != 0 && roomID != id.room {
+		return 0, errTicketRoom
+	}
+	return id.room, nil
+}
+
+// rejectUpgraded 以 CloseUnauthorized 关闭已升级但鉴权失败的连接。
+func rejectUpgraded(conn *websocket.Conn, err error) {
+	_, reason := authFailure(err)
+	closeUpgraded(conn, CloseUnauthorized, reason)
+}
+
+// closeUpgraded 在 writePump 启动前发送关闭帧并断开连接。
+func closeUpgraded(conn *websocket.Conn, code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(10*time.Second))
+	_ = conn.Close()
+}
+
+// rejectRequest 为握手阶段的鉴权失败写出 HTTP 响应。
+func rejectRequest(c *gin.Context, err error) {
+	status, reason := authFailure(err)
+	c.JSON(status, gin.H{"error": reason})
+}
+
+// authFailure 把鉴权错误映射为状态码与描述，数据库等内部错误不向客户端暴露细节。
+func authFailure(err error) (int, string) {
+	switch {
+	case errors.Is(err, errMissingCredentials), errors.Is(err, errInvalidToken), errors.Is(err, errUserNotFound),
+		errors.Is(err, errInvalidTicket), errors.Is(err, errTicketRoom), errors.Is(err, errQueryTokenDisabled):
+		return http.StatusUnauthorized, err.Error()
+	}
+	log.Error().Err(err).Msg("ws authenticate")
+	return http.StatusInternalServerError, "failed to authenticate"
+}
@@ -0,0 +1,129 @@
+package ws
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/ugorji/go/codec"
+)
+
+// 客户端通过 Sec-WebSocket-Protocol 协商线协议编码，未协商时使用 JSON。
+const (
+	SubprotocolJSON    = "chatroom.v1.json"
+	SubprotocolMsgpack = "chatroom.v1.msgpack"
+)
+
+// Codec 是 WebSocket 帧的编码方式。
+// 服务端内部统一以 JSON 构造事件（broker 转发与续传也使用 JSON），发送前再转换为连接协商的编码。
+type Codec interface {
+	// Name 返回对应的子协议名。
+	Name() string
+	// MessageType 返回写出帧时使用的 WebSocket 消息类型。
+	MessageType() int
+	// Unmarshal 解码客户端发来的帧。
+	Unmarshal(data []byte, v interface{}) error
+	// Transcode 把 JSON 编码的事件转换为本编码。
+	Transcode(data []byte) ([]byte, error)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                               { return SubprotocolJSON }
+func (jsonCodec) MessageType() int                           { return websocket.TextMessage }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Transcode(data []byte) ([]byte, error)      { return data, nil }
+
+var (
+	jsonHandle    = &codec.JsonHandle{}
+	msgpackHandle = newMsgpackHandle()
+)
+
+func newMsgpackHandle() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{WriteExt: true}
+	h.RawToString = true
+	return h
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string     { return SubprotocolMsgpack }
+func (msgpackCodec) MessageType() int { return websocket.BinaryMessage }
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return codec.NewDecoderBytes(data, msgpackHandle).Decode(v)
+}
+
+func (msgpackCodec) Transcode(data []byte) ([]byte, error) {
+	var v interface{}
+	if err := codec.NewDecoderBytes(data, jsonHandle).Decode(&v); err != nil {
+		return nil, err
+	}
+	var out []byte
+	if err := codec.NewEncoderBytes(&out, msgpackHandle).Encode(v); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// JSONCodec 与 MsgpackCodec 是内置的两种编码。
+var (
+	JSONCodec    Codec = jsonCodec{}
+	MsgpackCodec Codec = msgpackCodec{}
+)
+
+// codecs 按服务端偏好排列，客户端同时支持多种编码时优先选择靠前的。
+var codecs = []Codec{MsgpackCodec, JSONCodec}
+
+// subprotocols 返回升级时可协商的子协议列表。
+func subprotocols() []string {
+	out := make([]string, 0, len(codecs))
+	for _, cd := range codecs {
+		out = append(out, cd.Name())
+	}
+	return out
+}
+
+// codecFor 根据协商出的子协议选择编码，未协商时回退到 JSON。
+func codecFor(subprotocol string) Codec {
+	for _, cd := range codecs {
+		if cd.Name() == subprotocol {
+			return cd
+		}
+	}
+	return JSONCodec
+}
+
+// encoded 缓存一次广播在各编码下的结果，所有接收方共享，每种编码只转换一次。
+type encoded struct {
+	mu sync.Mutex
+	by map[string][]byte
+}
+
+func (e *encoded) get(cd Codec, data []byte) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if b, ok := e.by[cd.Name()]; ok {
+		return b, nil
+	}
+	b, err := cd.Transcode(data)
+	if err != nil {
+		return nil, err
+	}
+	if e.by == nil {
+		e.by = make(map[string][]byte, len(codecs))
+	}
+	e.by[cd.Name()] = b
+	return b, nil
+}
+
+// encode 返回帧在指定编码下的字节；广播帧复用共享缓存，其余帧就地转换。
+func (f frame) encode(cd Codec) ([]byte, error) {
+	if cd == JSONCodec {
+		return f.data, nil
+	}
+	if f.enc == nil {
+		return cd.Transcode(f.data)
+	}
+	return f.enc.get(cd, f.data)
+}
@@ -0,0 +1,67 @@
+package ws
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ugorji/go/codec"
+)
+
+func TestMsgpackCodec_Transcode(t *testing.T) {
+	b, err := MsgpackCodec.Transcode([]byte(`{"type":"message","id":12,"room_id":3,"content":"hi","previews":[{"url":"https://a"}],"client_msg_id":null}`))
+	if err != nil {
+		t.Fatalf("Transcode() error = %v", err)
+	}
+	var got map[string]interface{}
+	if err := codec.NewDecoderBytes(b, msgpackHandle).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got["type"] != "message" || got["content"] != "hi" || fmt.Sprint(got["id"]) != "12" || got["client_msg_id"] != nil {
+		t.Errorf("decoded = %v", got)
+	}
+	previews, ok := got["previews"].([]interface{})
+	if !ok || len(previews) != 1 {
+		t.Errorf("previews = %v, want one entry", got["previews"])
+	}
+}
+
+func TestMsgpackCodec_Unmarshal(t *testing.T) {
+	var b []byte
+	if err := codec.NewEncoderBytes(&b, msgpackHandle).Encode(map[string]interface{}{"type": "typing", "room_id": 2, "is_typing": true}); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	var in InboundMessage
+	if err := MsgpackCodec.Unmarshal(b, &in); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if in.Type != "typing" || in.RoomID != 2 || !in.IsTyping {
+		t.Errorf("decoded = %+v", in)
+	}
+}
+
+// countingCodec 统计转换次数，用于验证广播只编码一次。
+type countingCodec struct {
+	msgpackCodec
+	calls int
+}
+
+func (c *countingCodec) Transcode(data []byte) ([]byte, error) {
+	c.calls++
+	return c.msgpackCodec.Transcode(data)
+}
+
+func TestFrame_EncodeSharedAcrossRecipients(t *testing.T) {
+	cd := &countingCodec{}
+	f := frame{data: []byte(`{"type":"message"}`), enc: &encoded{}}
+	for i := 0; i < 5; i++ {
+		if _, err := f.encode(cd); err != nil {
+			t.Fatalf("encode() error = %v", err)
+		}
+	}
+	if cd.calls != 1 {
+		t.Errorf("Transcode called %d times, want 1", cd.calls)
+	}
+	if b, _ := f.encode(JSONCodec); string(b) != `{"type":"message"}` {
+		t.Errorf("JSON encode = %s, want original bytes", b)
+	}
+}
@@ -0,0 +1,111 @@
+package ws
+
+import (
+	"bufio"
+	"compress/flate"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"chatroom/internal/config"
+	"chatroom/internal/metrics"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultCompressionMinBytes 是未配置时触发压缩的最小帧长度，更短的帧压缩收益抵不过 CPU 开销。
+const defaultCompressionMinBytes = 512
+
+// compression 是 permessage-deflate 的配置。
+type compression struct {
+	enabled  bool
+	level    int
+	minBytes int
+}
+
+func newCompression(cfg config.Config) compression {
+	level := cfg.WSCompressionLevel
+	if level < flate.BestSpeed || level > flate.BestCompression {
+		level = flate.BestSpeed
+	}
+	minBytes := cfg.WSCompressionMinBytes
+	if minBytes <= 0 {
+		minBytes = defaultCompressionMinBytes
+	}
+	return compression{enabled: cfg.WSCompressionEnabled, level: level, minBytes: minBytes}
+}
+
+// negotiate 判断本次握手是否会启用压缩。
+// 客户端可以用 compress=false 单独关闭压缩，此时从请求中移除扩展声明，Upgrader 就不会协商该扩展。
+func (cp compression) negotiate(r *http.Request) bool {
+	offered := strings.Contains(strings.ToLower(r.Header.Get("Sec-WebSocket-Extensions")), "permessage-deflate")
+	if !cp.enabled || !offered {
+		return false
+	}
+	if v := r.URL.Query().Get("compress"); v == "false" || v == "0" {
+		r.Header.Del("Sec-WebSocket-Extensions")
+		return false
+	}
+	return true
+}
+
+// setCompression 为协商了压缩的连接设置压缩级别与阈值。
+func (c *Client) setCompression(cp compression, negotiated bool) {
+	c.compress = negotiated
+	if !negotiated {
+		return
+	}
+	c.compressMin = cp.minBytes
+	if err := c.conn.SetCompressionLevel(cp.level); err != nil {
+		log.Warn().Err(err).Int("level", cp.level).Msg("ws set compression level")
+	}
+}
+
+// writeData 写出一帧数据，只压缩超过阈值的帧，并统计压缩前的负载字节数。
+func (c *Client) writeData(b []byte) bool {
+	if c.compress {
+		c.conn.EnableWriteCompression(len(b) >= c.compressMin)
+	}
+	metrics.WsPayloadBytes.WithLabelValues(compressLabel(c.compress)).Add(float64(len(b)))
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return c.conn.WriteMessage(c.codec.MessageType(), b) == nil
+}
+
+func compressLabel(compress bool) string {
+	if compress {
+		return "true"
+	}
+	return "false"
+}
+
+// countWireBytes 包装 ResponseWriter，使升级后的底层连接统计实际写出的字节数（包含帧头与握手）。
+func countWireBytes(w gin.ResponseWriter, compress bool) http.ResponseWriter {
+	return &hijackCounter{ResponseWriter: w, counter: metrics.WsWireBytes.WithLabelValues(compressLabel(compress))}
+}
+
+type hijackCounter struct {
+	gin.ResponseWriter
+	counter prometheus.Counter
+}
+
+func (w *hijackCounter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := w.ResponseWriter.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &countingConn{Conn: conn, counter: w.counter}, brw, nil
+}
+
+type countingConn struct {
+	net.Conn
+	counter prometheus.Counter
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.counter.Add(float64(n))
+	return n, err
+}
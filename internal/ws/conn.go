@@ -2,36 +2,170 @@ package ws
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"chatroom/internal/auth"
 	"chatroom/internal/config"
 	"chatroom/internal/metrics"
 	"chatroom/internal/models"
+	"chatroom/internal/service"
+	"chatroom/internal/unfurl"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
 	"gorm.io/gorm"
 )
 
 type Client struct {
-	room   *RoomHub
-	conn   *websocket.Conn
-	send   chan []byte
-	db     *gorm.DB
-	userID uint
-	uname  string
+	hub   *Hub
+	conn  *websocket.Conn
+	out   transport
+	codec Codec
+	send  *sendQueue
+	// persist 是异步落库管道，为 nil 时消息在 readPump 中同步写入。
+	persist *persister
+	// mod 用于检查封禁与禁言。
+	mod *service.ModerationService
+	// protocol 是协商的协议版本，lang 是错误消息的语言。
+	protocol int
+	lang     string
+	// compress 表示连接协商了 permessage-deflate，只有不小于 compressMin 字节的帧才压缩。
+	compress    bool
+	compressMin int
+	db          *gorm.DB
+	userID      uint
+	uname       string
+	// boundRoom 非零表示连接使用了绑定房间的票据，只能订阅该房间。
+	boundRoom uint
+
+	// 凭证过期：过期前 reauthLead 下发 reauth_required，到期仍未续期则断开。
+	authn      *authenticator
+	reauthLead time.Duration
+	authMu     sync.Mutex
+	authExpiry time.Time
+	authWarned bool
+	authTimer  *time.Timer
+
+	// 上行帧限流：rlUser 是该用户在本实例所有连接共享的令牌桶，connLimit 限制本连接的总帧数；rl 为 nil 表示不限流。
+	rl        *rateLimiter
+	rlUser    *userLimiter
+	connLimit *rate.Limiter
+
+	msgSvc   *service.MessageService
+	unfurler *unfurl.Unfurler
+
+	// done 关闭后 writePump 发送关闭帧并退出；任意 RoomHub 都可能触发，因此只关闭一次。
+	// closeCode 非零时作为关闭帧的关闭码，在关闭 done 之前写入。
+	done        chan struct{}
+	closeOnce   sync.Once
+	closeCode   int
+	closeReason string
+
+	// rooms 是当前连接订阅的房间，holding 是正在等待续传批次的房间。
+	mu       sync.Mutex
+	rooms    map[uint]*RoomHub
+	holding  map[uint]bool
+	maxRooms int
+
+	// control 把房间的暂存 / 放行指令按顺序交给 writePump。
+	control     chan roomControl
+	resumeLimit int
+
+	// 空闲检测：超过 idleAfter 没有操作的连接视为空闲，所有连接都空闲的用户显示为 away。
+	statusSvc  *service.StatusService
+	idleAfter  time.Duration
+	idleTimer  *time.Timer
+	idleMu     sync.Mutex
+	idle       atomic.Bool
+	lastActive atomic.Int64
+}
+
+// frame 是发往客户端的一帧数据；roomID 为零表示只发给当前连接的控制帧，
+// seq 仅在聊天消息帧上非零，用于续传去重；remote 表示事件来自其它实例，不再转发。
+// ephemeral 表示积压时可以丢弃的帧（如输入提示），key 非空时同 key 的旧帧可被新帧合并。
+// data 总是 JSON，enc 由广播时创建，缓存转换成其它编码后的结果。
+type frame struct {
+	data      []byte
+	roomID    uint
+	seq       uint64
+	remote    bool
+	ephemeral bool
+	key       string
+	enc       *encoded
+}
+
+// resumeBatch 是续传时需要先于该房间实时帧写出的帧，lastSeq 及之前的实时消息会被跳过。
+type resumeBatch struct {
+	frames  []frame
+	lastSeq uint64
+}
+
+// roomControl 通知 writePump 开始暂存某个房间的实时帧，或写出续传批次后放行。
+type roomControl struct {
+	roomID uint
+	hold   bool
+	batch  resumeBatch
+}
+
+// resumeWindow 是等待客户端首帧 resume 的最长时间，超时后直接进入实时投递。
+const resumeWindow = 500 * time.Millisecond
+
+// defaultMaxRooms 是未配置时单个连接最多订阅的房间数。
+const defaultMaxRooms = 50
+
+func newClient(h *Hub, conn *websocket.Conn, db *gorm.DB, user models.User, cfg config.Config, msgSvc *service.MessageService, statusSvc *service.StatusService, unf *unfurl.Unfurler) *Client {
+	maxRooms := cfg.WSMaxRoomsPerConn
+	if maxRooms <= 0 {
+		maxRooms = defaultMaxRooms
+	}
+	idleAfter := time.Duration(cfg.WSIdleSeconds) * time.Second
+	if idleAfter <= 0 {
+		idleAfter = defaultIdleAfter
+	}
+	reauthLead := time.Duration(cfg.WSReauthLeadSeconds) * time.Second
+	if reauthLead <= 0 {
+		reauthLead = defaultReauthLead
+	}
+	policy, _ := ParsePolicy(cfg.WSSlowConsumerPolicy)
+	c := &Client{
+		hub: h, conn: conn, codec: JSONCodec, send: newSendQueue(cfg.WSSendQueueSize, policy), db: db, userID: user.ID, uname: user.Username,
+		msgSvc: msgSvc, unfurler: unf,
+		done: make(chan struct{}), rooms: make(map[uint]*RoomHub), holding: make(map[uint]bool), maxRooms: maxRooms,
+		control: make(chan roomControl, 16), resumeLimit: cfg.WSResumeMaxMessages,
+		statusSvc: statusSvc, idleAfter: idleAfter, reauthLead: reauthLead,
+	}
+	c.lastActive.Store(time.Now().UnixNano())
+	return c
+}
+
+// close 通知 writePump 关闭连接，可被多个 goroutine 重复调用。
+func (c *Client) close() {
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
+// closeWith 以指定关闭码关闭连接，连接已在关闭时不会覆盖原有关闭码。
+func (c *Client) closeWith(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeCode, c.closeReason = code, reason
+		close(c.done)
+	})
 }
 
-// upgrader 将 HTTP 请求升级为 WebSocket 连接（教学场景放宽跨域校验）。
-var upgrader = websocket.Upgrader{
-	CheckOrigin: checkOrigin,
+// newUpgrader 创建将 HTTP 请求升级为 WebSocket 连接的 Upgrader（教学场景放宽跨域校验）。
+func newUpgrader(cfg config.Config) *websocket.Upgrader {
+	return &websocket.Upgrader{
+		CheckOrigin:       checkOrigin,
+		Subprotocols:      subprotocols(),
+		EnableCompression: cfg.WSCompressionEnabled,
+	}
 }
 
 var (
@@ -60,77 +194,205 @@ func checkOrigin(r *http.Request) bool {
 	return strings.EqualFold(u.Host, r.Host)
 }
 
-type InboundMessage struct {
-	Type     string `json:"type"`
-	Content  string `json:"content"`
-	IsTyping bool   `json:"is_typing"`
-}
-
-type OutboundMessage struct {
-	Type      string    `json:"type"`
-	ID        uint      `json:"id"`
-	RoomID    uint      `json:"room_id"`
-	UserID    uint      `json:"user_id"`
-	Username  string    `json:"username"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
+// NewUnfurler 按配置创建链接预览抓取器，未开启时返回 nil。每个进程只需一个，
+// 由 WebSocket 与 REST 发送共用，预览缓存与外部抓取才不会重复。
+func NewUnfurler(cfg config.Config) *unfurl.Unfurler {
+	if !cfg.LinkPreviewEnabled {
+		return nil
+	}
+	return unfurl.New(unfurl.Options{Timeout: time.Duration(cfg.LinkPreviewTimeoutSeconds) * time.Second})
 }
 
-// Serve 返回 Gin 处理函数，用于校验用户、加入房间并启动读写循环。
-func Serve(h *Hub, db *gorm.DB, cfg config.Config) gin.HandlerFunc {
+// Serve 返回 Gin 处理函数，用于校验用户并启动读写循环。
+// 带 room_id 时连接建立后自动订阅该房间，否则由客户端通过 subscribe 帧订阅任意多个房间。
+// mod 应与 REST 接口共用同一个实例，处罚的下达与撤销才能立即作用于实时连接；unf 为 nil 时不抓取链接预览。
+func Serve(h *Hub, db *gorm.DB, cfg config.Config, msgSvc *service.MessageService, mod *service.ModerationService, unf *unfurl.Unfurler) gin.HandlerFunc {
 	initUpgrader(cfg)
+	upgrader := newUpgrader(cfg)
+	compression := newCompression(cfg)
+	statusSvc := service.NewStatusService(db, h)
+	authn := newAuthenticator(db, cfg)
+	limiter := newRateLimiter(cfg)
+	persist := newPersister(msgSvc, cfg)
+	h.setPersister(persist)
 	return func(c *gin.Context) {
-		roomIDStr := c.Query("room_id")
-		rid64, err := strconv.ParseUint(roomIDStr, 10, 64)
-		if err != nil || rid64 == 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid room_id"})
+		if h.Draining() {
+			rejectDraining(c)
 			return
 		}
-		var room models.Room
-		if err := db.First(&room, uint(rid64)).Error; err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+		var roomID uint
+		if roomIDStr := c.Query("room_id"); roomIDStr != "" {
+			rid64, err := strconv.ParseUint(roomIDStr, 10, 64)
+			if err != nil || rid64 == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid room_id"})
+				return
+			}
+			var room models.Room
+			if err := db.First(&room, uint(rid64)).Error; err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+				return
+			}
+			roomID = room.ID
+		}
+		lastSeen, resume, ok := parseLastSeenID(c)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid last_seen_id"})
 			return
 		}
 
-		// 兼容 Authorization 头与 token 查询参数两种传递方式，方便调试。
-		authz := c.GetHeader("Authorization")
-		token := c.Query("token")
-		if token == "" && len(authz) > 7 && (authz[:7] == "Bearer " || authz[:7] == "bearer ") {
-			token = authz[7:]
+		// 握手未携带凭证时先升级连接，再等待首帧 auth。
+		id, err := authn.fromRequest(c)
+		firstFrame := errors.Is(err, errMissingCredentials)
+		if err == nil {
+			roomID, err = id.bindRoom(roomID)
 		}
-		if token == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "missing token"})
+		if err != nil && !firstFrame {
+			rejectRequest(c, err)
 			return
 		}
-		claims, err := auth.ParseAccessToken(token, cfg.JWTSecret)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		if err == nil && !checkBan(c, mod, id.user.ID, roomID) {
 			return
 		}
-		var user models.User
-		if err := db.First(&user, claims.UserID).Error; err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+
+		protocol, ok := negotiateProtocol(c.Query("protocol"))
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported protocol version"})
 			return
 		}
 
-		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		// 连接数上限：首帧鉴权的连接在鉴权完成后才计入用户名额。
+		ip := c.ClientIP()
+		if roomID != 0 && h.roomFull(roomID) {
+			rejectConn(c, limitRoom)
+			return
+		}
+		if reason := h.conns.admit(id.user.ID, ip); reason != "" {
+			rejectConn(c, reason)
+			return
+		}
+		admitted := id.user.ID
+		defer func() { h.conns.release(admitted, ip) }()
+
+		compress := compression.negotiate(c.Request)
+		conn, err := upgrader.Upgrade(countWireBytes(c.Writer, compress), c.Request, nil)
 		if err != nil {
-			log.Error().Err(err).Uint64("room_id", rid64).Str("remote", c.Request.RemoteAddr).Msg("ws upgrade")
+			log.Error().Err(err).Uint("room_id", roomID).Str("remote", c.Request.RemoteAddr).Msg("ws upgrade")
+			return
+		}
+		if firstFrame {
+			id, err = authn.fromFirstFrame(conn, codecFor(conn.Subprotocol()))
+			if err == nil {
+				roomID, err = id.bindRoom(roomID)
+			}
+			if err != nil {
+				rejectUpgraded(conn, err)
+				return
+			}
+			if err := mod.Check(id.user.ID, roomID, false); err != nil {
+				if !errors.Is(err, service.ErrUserBanned) {
+					log.Error().Err(err).Uint("user_id", id.user.ID).Msg("ws check ban")
+				}
+				closeUpgraded(conn, CloseKicked, "banned")
+				return
+			}
+			// 握手时未带 room_id 的连接到这里才由票据绑定房间，需要补上房间名额检查。
+			if roomID != 0 && h.roomFull(roomID) {
+				metrics.WsConnectionsRejected.WithLabelValues(limitRoom).Inc()
+				closeUpgraded(conn, CloseTryAgainLater, "room is full")
+				return
+			}
+			if reason := h.conns.admitUser(id.user.ID); reason != "" {
+				metrics.WsConnectionsRejected.WithLabelValues(reason).Inc()
+				closeUpgraded(conn, CloseTryAgainLater, "too many connections")
+				return
+			}
+			admitted = id.user.ID
+		}
+		user := id.user
+		metrics.WsConnections.Inc()
+		defer metrics.WsConnections.Dec()
+		client := newClient(h, conn, db, user, cfg, msgSvc, statusSvc, unf)
+		client.codec = codecFor(conn.Subprotocol())
+		client.out = wsTransport{client}
+		client.boundRoom = id.room
+		client.authn = authn
+		client.persist = persist
+		client.mod = mod
+		limiter.attach(client)
+		defer limiter.detach(client)
+		client.protocol = protocol
+		client.setCompression(compression, compress)
+		client.lang = negotiateLang(c.Query("lang"), c.GetHeader("Accept-Language"))
+		if !h.track(client) {
+			// 握手期间实例开始排空。
+			closeUpgraded(conn, CloseServiceRestart, "server restarting")
+			return
+		}
+		defer h.untrack(client)
+		if protocol >= ProtocolV2 && !client.writeWelcome() {
+			client.close()
+			_ = conn.Close()
 			return
 		}
-		rh := h.GetRoom(uint(rid64))
-		client := &Client{room: rh, conn: conn, send: make(chan []byte, 256), db: db, userID: user.ID, uname: user.Username}
-		rh.register <- client
+		if h.connect(client) {
+			// 用户此前所有连接都已空闲，新连接让其恢复在线。
+			h.tracker.SetIdle(user.ID, false)
+			client.announceStatus()
+		}
+		client.idleTimer = time.AfterFunc(client.idleAfter, client.checkIdle)
+		client.watchExpiry(id.expiresAt)
+
+		if roomID != 0 {
+			// 续传：优先使用 last_seen_id 查询参数，否则在短暂窗口内等待首帧 resume。
+			client.holdRoom(roomID)
+			if err := client.joinRoom(roomID); err != nil {
+				// 握手后房间才满员，连接保留，客户端可稍后重新订阅。
+				client.releaseRoom(roomID, resumeBatch{})
+				client.sendError(roomID, joinError(err))
+				roomID = 0
+			} else if resume {
+				client.releaseRoom(roomID, client.buildResume(roomID, lastSeen))
+			} else {
+				time.AfterFunc(resumeWindow, func() { client.releaseRoom(roomID, resumeBatch{}) })
+			}
+		}
 
 		go client.writePump()
-		client.readPump()
+		client.readPump(roomID)
+	}
+}
+
+// parseLastSeenID 读取 last_seen_id 查询参数，present 表示请求携带了该参数；
+// 参数无法解析时 ok 为 false，不能当作 0 从头补发。
+func parseLastSeenID(c *gin.Context) (id uint, present, ok bool) {
+	v := c.Query("last_seen_id")
+	if v == "" {
+		return 0, false, true
 	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, true, false
+	}
+	return uint(n), true, true
+}
+
+// writeWelcome 在 writePump 启动前直接写出 welcome 帧，保证它是连接上的第一帧。
+func (c *Client) writeWelcome() bool {
+	b, err := frame{data: marshalEvent(WelcomeEvent{Type: TypeWelcome, Protocol: c.protocol, Codec: c.codec.Name(), Lang: c.lang, UserID: c.userID})}.encode(c.codec)
+	if err != nil {
+		return false
+	}
+	return c.writeData(b)
 }
 
 // readPump 负责读取客户端信息、校验输入并推送到房间广播。
-func (c *Client) readPump() {
+// resumeRoom 是首帧 resume 针对的房间，为零表示连接建立时没有自动订阅房间。
+func (c *Client) readPump(resumeRoom uint) {
 	defer func() {
-		c.room.unregister <- c
+		c.stopExpiry()
+		c.disconnectActivity()
+		c.leaveAll()
+		c.close()
 		_ = c.conn.Close()
 	}()
 	c.conn.SetReadLimit(1 << 20) // 1MB
@@ -139,117 +401,356 @@ func (c *Client) readPump() {
 		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 		return nil
 	})
+	first := true
 	for {
 		_, data, err := c.conn.ReadMessage()
 		if err != nil {
 			break
 		}
 		var in InboundMessage
-		if err := json.Unmarshal(data, &in); err != nil {
+		err = c.codec.Unmarshal(data, &in)
+		// 无法解析的帧同样计入限流，避免用垃圾数据绕过限制。
+		if !c.allowFrame(in.Type) {
+			continue
+		}
+		if err != nil {
+			if c.protocol >= ProtocolV2 {
+				c.sendError(0, ErrCodeInvalidFrame)
+			}
 			continue
 		}
 
-		switch in.Type {
-		case "ping":
-			// 响应客户端心跳检测
-			pong := map[string]string{"type": "pong"}
-			if b, err := json.Marshal(pong); err == nil {
-				select {
-				case c.send <- b:
-				default:
+		// 客户端心跳不算用户操作。
+		if in.Type != TypePing {
+			c.touch()
+		}
+
+		if first {
+			first = false
+			if resumeRoom != 0 {
+				if in.Type == TypeResume {
+					if !c.releaseRoom(resumeRoom, c.buildResume(resumeRoom, in.LastSeenID)) {
+						// 等待窗口已过，实时消息已开始投递，只能让客户端自行全量同步。
+						c.trySend(resyncRequired(resumeRoom, in.LastSeenID))
+					}
+					continue
 				}
+				c.releaseRoom(resumeRoom, resumeBatch{})
 			}
+		}
+
+		switch in.Type {
+		case TypePing:
+			// 响应客户端心跳检测
+			c.send.tryPush(frame{data: marshalEvent(PongEvent{Type: TypePong}), ephemeral: true})
+
+		case TypeSubscribe:
+			c.handleSubscribe(in)
+
+		case TypeUnsubscribe:
+			c.handleUnsubscribe(in)
+
+		case TypeActive:
+			// 仅用于上报用户操作，touch 已在上面处理。
 
-		case "typing":
+		case TypeStatus:
+			c.handleStatus(in)
+
+		case TypeResume:
+			c.sendError(in.RoomID, ErrCodeResumeNotFirst)
+
+		case TypeAuth:
+			// 已完成鉴权的连接重复发送 auth 帧时忽略。
+
+		case TypeReauth:
+			c.handleReauth(in)
+
+		case TypeTyping:
 			// 输入法提示只做广播，不入库
-			evt := map[string]interface{}{"type": "typing", "room_id": c.room.roomID, "user_id": c.userID, "username": c.uname, "is_typing": in.IsTyping}
-			if b, err := json.Marshal(evt); err == nil {
-				c.room.broadcast <- b
+			rh := c.targetRoom(in.RoomID)
+			if rh == nil {
+				c.sendError(in.RoomID, ErrCodeNotSubscribed)
+				continue
 			}
+			evt := TypingEvent{Type: TypeTyping, RoomID: rh.roomID, UserID: c.userID, Username: c.uname, IsTyping: in.IsTyping}
+			rh.publish(frame{data: marshalEvent(evt), roomID: rh.roomID, ephemeral: true, key: coalesceKey("typing", rh.roomID, c.userID)})
 
-		case "message":
-			c.handleMessage(in.Content)
+		case TypeMessage:
+			c.handleMessage(in)
 
 		default:
-			// 向后兼容：无type时当作message处理
-			c.handleMessage(in.Content)
+			if c.protocol >= ProtocolV2 {
+				c.sendError(in.RoomID, ErrCodeUnknownType)
+				continue
+			}
+			// 向后兼容：v1 中无 type 或未知 type 的帧当作 message 处理
+			c.handleMessage(in)
 		}
 	}
 }
 
 // handleMessage 校验并持久化聊天消息，然后广播给房间内的所有客户端。
-func (c *Client) handleMessage(content string) {
-	if content == "" {
+// 携带 client_msg_id 的消息会额外给发送方回一个 ack，重放时只回 ack 不再广播。
+func (c *Client) handleMessage(in InboundMessage) {
+	rh := c.targetRoom(in.RoomID)
+	if rh == nil {
+		c.sendError(in.RoomID, ErrCodeNotSubscribed)
 		return
 	}
-	if len(content) > 2000 {
-		errMsg := map[string]string{"type": "error", "content": "消息长度不能超过2000字符"}
-		if b, err := json.Marshal(errMsg); err == nil {
-			select {
-			case c.send <- b:
-			default:
-			}
+	if code := c.sanctionError(rh.roomID, true); code != "" {
+		c.sendError(rh.roomID, code)
+		return
+	}
+	release, code, wait := c.postPolicyError(rh.roomID)
+	if code != "" {
+		if !c.replayed(rh, in.ClientMsgID) {
+			c.sendRetryError(rh.roomID, code, wait)
 		}
 		return
 	}
-	msg := models.Message{RoomID: c.room.roomID, UserID: c.userID, Content: content}
-	if err := c.db.Create(&msg).Error; err != nil {
-		log.Error().Err(err).Uint("room_id", c.room.roomID).Uint("user_id", c.userID).Msg("ws persist message")
-		errMsg := map[string]string{"type": "error", "content": "消息发送失败"}
-		if b, err := json.Marshal(errMsg); err == nil {
-			select {
-			case c.send <- b:
-			default:
-			}
+	input := service.CreateMessageInput{
+		RoomID:      rh.roomID,
+		UserID:      c.userID,
+		Content:     in.Content,
+		Format:      in.Format,
+		ClientMsgID: in.ClientMsgID,
+	}
+	done := func(res service.CreateResult) {
+		if res.Err != nil || res.Duplicate {
+			release()
+		}
+		// 批量落库期间房间可能已被回收，广播时按房间号重新查找。
+		c.finishMessage(input.RoomID, res)
+	}
+	if c.persist != nil {
+		// 异步落库：写入成功后才确认并广播，失败时把错误回给发送方。
+		if err := c.persist.enqueue(input, done); err != nil {
+			done(service.CreateResult{Err: err})
+		}
+		return
+	}
+	msg, duplicate, err := c.msgSvc.Create(input)
+	done(service.CreateResult{Message: msg, Duplicate: duplicate, Err: err})
+}
+
+// finishMessage 根据落库结果回复发送方并广播新消息。
+func (c *Client) finishMessage(roomID uint, res service.CreateResult) {
+	if err := res.Err; err != nil {
+		switch {
+		case errors.Is(err, service.ErrMessageEmpty):
+		case errors.Is(err, service.ErrMessageTooLong):
+			c.sendError(roomID, ErrCodeMessageTooLong)
+		case errors.Is(err, service.ErrUnsupportedFormat):
+			c.sendError(roomID, ErrCodeUnsupportedFormat)
+		case errors.Is(err, service.ErrRoomNotFound):
+			c.sendError(roomID, ErrCodeRoomNotFound)
+		case errors.Is(err, service.ErrInvalidClientMsgID):
+			c.sendError(roomID, ErrCodeInvalidClientMsgID)
+		case errors.Is(err, service.ErrClientMsgIDConflict):
+			c.sendError(roomID, ErrCodeClientMsgIDConflict)
+		case errors.Is(err, service.ErrMessageRejected):
+			c.sendError(roomID, ErrCodeMessageRejected)
+		case errors.Is(err, errPersistBusy):
+			log.Warn().Uint("room_id", roomID).Uint("user_id", c.userID).Msg("ws persist queue full")
+			c.sendRetryError(roomID, ErrCodeServerBusy, persistBusyRetry)
+		case errors.Is(err, errPersistClosed):
+			log.Debug().Uint("room_id", roomID).Uint("user_id", c.userID).Msg("ws persist closed while draining")
+			c.sendRetryError(roomID, ErrCodeServerDraining, persistDrainRetry)
+		default:
+			log.Error().Err(err).Uint("room_id", roomID).Uint("user_id", c.userID).Msg("ws persist message")
+			c.sendError(roomID, ErrCodeMessageFailed)
 		}
 		return
 	}
-	out := OutboundMessage{Type: "message", ID: msg.ID, RoomID: msg.RoomID, UserID: msg.UserID, Username: c.uname, Content: msg.Content, CreatedAt: msg.CreatedAt}
+	out := newOutbound(res.Message, c.uname)
+	if out.ClientMsgID != "" {
+		c.sendAck(out, res.Duplicate)
+	}
+	if res.Duplicate {
+		return
+	}
+	broadcastMessage(c.hub, out, c.unfurler, c.db)
+}
+
+// newOutbound 把落库的消息转换为 message 事件。
+func newOutbound(msg *models.Message, username string) OutboundMessage {
+	out := OutboundMessage{Type: TypeMessage, ID: msg.ID, RoomID: msg.RoomID, Seq: msg.Seq, UserID: msg.UserID, Username: username, Content: msg.Content, Format: msg.Format, ContentHTML: msg.ContentHTML, CreatedAt: msg.CreatedAt}
+	if msg.ClientMsgID != nil {
+		out.ClientMsgID = *msg.ClientMsgID
+	}
+	return out
+}
+
+// broadcastMessage 向房间广播新消息，并在后台抓取链接预览。
+func broadcastMessage(h *Hub, out OutboundMessage, unf *unfurl.Unfurler, db *gorm.DB) {
 	b, _ := json.Marshal(out)
 	metrics.WsMessagesTotal.Inc()
-	c.room.broadcast <- b
+	h.publish(frame{data: b, roomID: out.RoomID, seq: out.Seq})
+
+	if unf != nil {
+		go attachPreviews(unf, db, h, out)
+	}
+}
+
+// sendAck 向发送方确认消息已落库。
+func (c *Client) sendAck(out OutboundMessage, duplicate bool) {
+	ack := AckMessage{Type: TypeAck, RoomID: out.RoomID, ClientMsgID: out.ClientMsgID, ID: out.ID, Duplicate: duplicate, Message: out}
+	if b, err := json.Marshal(ack); err == nil {
+		c.trySend(b)
+	}
+}
+
+// sendError 向当前客户端发送错误事件，roomID 非零时附带所属房间，发送队列已满时直接丢弃。
+func (c *Client) sendError(roomID uint, code ErrorCode) {
+	evt := ErrorEvent{Type: TypeError, RoomID: roomID, Code: code, Message: localize(code, c.lang)}
+	if c.protocol < ProtocolV2 {
+		evt.Content = evt.Message
+	}
+	c.trySend(marshalEvent(evt))
+}
+
+// trySend 把仅发给当前客户端的帧放入发送队列，队列已满时直接丢弃。
+func (c *Client) trySend(b []byte) {
+	if b == nil {
+		return
+	}
+	c.send.tryPush(frame{data: b})
+}
+
+// transport 是连接的下行通道，WebSocket 与 SSE 各有一种实现，writePump 只通过它写出数据。
+type transport interface {
+	// write 按连接的编码写出一帧，返回 false 表示连接已不可写。
+	write(f frame) bool
+	// heartbeat 发送保活信号。
+	heartbeat() bool
+	// farewell 在主动关闭前告知客户端关闭原因，code 为零表示正常关闭。
+	farewell(code int, reason string)
+	// shutdown 关闭底层连接。
+	shutdown()
 }
 
+// wsTransport 通过 WebSocket 连接写出数据。
+type wsTransport struct{ c *Client }
+
+func (t wsTransport) write(f frame) bool {
+	b, err := f.encode(t.c.codec)
+	if err != nil {
+		log.Error().Err(err).Str("codec", t.c.codec.Name()).Uint("room_id", f.roomID).Msg("ws encode frame")
+		return true
+	}
+	return t.c.writeData(b)
+}
+
+func (t wsTransport) heartbeat() bool {
+	t.c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return t.c.conn.WriteMessage(websocket.PingMessage, nil) == nil
+}
+
+func (t wsTransport) farewell(code int, reason string) {
+	t.c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	msg := []byte{}
+	if code != 0 {
+		msg = websocket.FormatCloseMessage(code, reason)
+	}
+	_ = t.c.conn.WriteMessage(websocket.CloseMessage, msg)
+}
+
+func (t wsTransport) shutdown() { _ = t.c.conn.Close() }
+
 // writePump 周期性发送服务端数据与心跳，防止浏览器断线。
-// 每次写入时会批量排空 send channel 中的待发消息，减少系统调用次数。
+// 每次唤醒时会批量取出发送队列中的待发消息，减少调度次数。
+// 处于续传中的房间，其实时帧会先暂存，等续传批次写出后再按序投递。
 func (c *Client) writePump() {
 	ticker := time.NewTicker(30 * time.Second)
 	defer func() {
 		ticker.Stop()
-		_ = c.conn.Close()
+		c.close()
+		c.out.shutdown()
 	}()
+
+	held := make(map[uint][]frame)
+	skipUntil := make(map[uint]uint64)
+
+	// deliver 写出一帧；续传已经包含的消息在实时队列中再次出现时跳过，避免客户端收到重复消息。
+	deliver := func(f frame) bool {
+		if pending, ok := held[f.roomID]; ok {
+			if len(pending) >= c.send.size {
+				// 续传迟迟未完成且积压过多，按慢消费者处理。
+				return false
+			}
+			held[f.roomID] = append(pending, f)
+			return true
+		}
+		if f.seq != 0 && f.seq <= skipUntil[f.roomID] {
+			return true
+		}
+		return c.out.write(f)
+	}
+	apply := func(ctl roomControl) bool {
+		if ctl.hold {
+			held[ctl.roomID] = nil
+			return true
+		}
+		for _, f := range ctl.batch.frames {
+			if !c.out.write(f) {
+				return false
+			}
+		}
+		if ctl.batch.lastSeq > skipUntil[ctl.roomID] {
+			skipUntil[ctl.roomID] = ctl.batch.lastSeq
+		}
+		pending := held[ctl.roomID]
+		delete(held, ctl.roomID)
+		for _, f := range pending {
+			if !deliver(f) {
+				return false
+			}
+		}
+		return true
+	}
+	// drainControl 先处理已排队的控制指令：暂存指令总是在房间注册之前发出，
+	// 因此拿到某个房间的实时帧时，对应的暂存指令一定已经在队列中。
+	drainControl := func() bool {
+		for {
+			select {
+			case ctl := <-c.control:
+				if !apply(ctl) {
+					return false
+				}
+			default:
+				return true
+			}
+		}
+	}
+
 	for {
 		select {
-		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if !ok {
-				_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+		case ctl := <-c.control:
+			if !apply(ctl) {
 				return
 			}
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
-			_, _ = w.Write(message)
-			_ = w.Close()
-
-			// 批量排空 channel 中积压的消息，每条单独帧发送以保证客户端逐条解析。
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				msg, ok := <-c.send
-				if !ok {
-					return
-				}
-				c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-				if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+		case <-c.send.notify:
+			// 批量取出积压的消息，每条单独帧发送以保证客户端逐条解析。
+			for _, f := range c.send.drain() {
+				if !drainControl() || !deliver(f) {
 					return
 				}
 			}
 		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if !c.out.heartbeat() {
 				return
 			}
+		case <-c.done:
+			if c.closeCode == CloseServiceRestart {
+				// 排空停服时先写完已排队的帧，包括 server_restarting 事件。
+				for _, f := range c.send.drain() {
+					if !drainControl() || !deliver(f) {
+						break
+					}
+				}
+			}
+			c.out.farewell(c.closeCode, c.closeReason)
+			return
 		}
 	}
 }
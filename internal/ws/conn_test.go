@@ -0,0 +1,704 @@
+package ws
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chatroom/internal/auth"
+	"chatroom/internal/broker"
+	"chatroom/internal/config"
+	"chatroom/internal/db"
+	"chatroom/internal/metrics"
+	"chatroom/internal/models"
+	"chatroom/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/ugorji/go/codec"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// testEnv 启动一个挂载了 /ws 的测试服务，并准备好一个房间与一个用户。
+type testEnv struct {
+	t      *testing.T
+	db     *gorm.DB
+	cfg    config.Config
+	hub    *Hub
+	msgSvc *service.MessageService
+	mod    *service.ModerationService
+	srv    *httptest.Server
+	roomID uint
+	userID uint
+	token  string
+}
+
+func newTestEnv(t *testing.T, mutate ...func(*config.Config)) *testEnv {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"))
+	gdb, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		if strings.Contains(err.Error(), "requires cgo") {
+			t.Skipf("skipping ws tests in current environment: %v", err)
+		}
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	if sqlDB, err := gdb.DB(); err == nil {
+		sqlDB.SetMaxOpenConns(1)
+		t.Cleanup(func() { _ = sqlDB.Close() })
+	}
+	if err := db.Migrate(gdb); err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+
+	cfg := config.Config{JWTSecret: "test-secret", Env: "dev", AccessTokenTTLMinutes: 15, WSResumeMaxMessages: 5, WSQueryTokenEnabled: true}
+	for _, fn := range mutate {
+		fn(&cfg)
+	}
+
+	env := &testEnv{t: t, db: gdb, cfg: cfg, hub: NewHub(), msgSvc: service.NewMessageService(gdb)}
+	t.Cleanup(env.hub.Shutdown)
+
+	env.userID, env.token = env.createUser("alice")
+	env.roomID = env.createRoom("general")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	env.mod = service.NewModerationService(gdb, cfg.ModeratorIDs, env.hub)
+	r.GET("/ws", Serve(env.hub, gdb, cfg, env.msgSvc, env.mod, NewUnfurler(cfg)))
+	r.GET("/rooms/:id/events", ServeEvents(env.hub, gdb, cfg, env.msgSvc, env.mod))
+	env.srv = httptest.NewServer(r)
+	t.Cleanup(env.srv.Close)
+	return env
+}
+
+func (e *testEnv) createUser(name string) (uint, string) {
+	e.t.Helper()
+	user := models.User{Username: name, PasswordHash: "x"}
+	if err := e.db.Create(&user).Error; err != nil {
+		e.t.Fatalf("failed to create user: %v", err)
+	}
+	token, err := auth.GenerateAccessToken(user.ID, e.cfg.JWTSecret, 15)
+	if err != nil {
+		e.t.Fatalf("failed to sign token: %v", err)
+	}
+	return user.ID, token
+}
+
+func (e *testEnv) createRoom(name string) uint {
+	e.t.Helper()
+	room := models.Room{Name: name, OwnerID: e.userID}
+	if err := e.db.Create(&room).Error; err != nil {
+		e.t.Fatalf("failed to create room: %v", err)
+	}
+	return room.ID
+}
+
+func (e *testEnv) dial(query string, subprotocols ...string) *websocket.Conn {
+	e.t.Helper()
+	url := "ws" + strings.TrimPrefix(e.srv.URL, "http") + "/ws?" + query
+	dialer := *websocket.DefaultDialer
+	dialer.Subprotocols = subprotocols
+	conn, resp, err := dialer.Dial(url, nil)
+	if err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		e.t.Fatalf("dial %s: %v (status %d)", query, err, status)
+	}
+	e.t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func (e *testEnv) seedMessages(n int) []uint {
+	e.t.Helper()
+	ids := make([]uint, 0, n)
+	for i := 0; i < n; i++ {
+		m, _, err := e.msgSvc.Create(service.CreateMessageInput{RoomID: e.roomID, UserID: e.userID, Content: fmt.Sprintf("m%d", i)})
+		if err != nil {
+			e.t.Fatalf("seed message: %v", err)
+		}
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+// readEvent 读取下一帧并解析为通用 map，超时视为失败。
+func readEvent(t *testing.T, conn *websocket.Conn) map[string]interface{} {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read event: %v", err)
+	}
+	var evt map[string]interface{}
+	if err := json.Unmarshal(data, &evt); err != nil {
+		t.Fatalf("decode event %s: %v", data, err)
+	}
+	return evt
+}
+
+// readUntil 跳过其它事件，直到读到指定类型的事件。
+func readUntil(t *testing.T, conn *websocket.Conn, typ string) map[string]interface{} {
+	t.Helper()
+	for i := 0; i < 50; i++ {
+		evt := readEvent(t, conn)
+		if evt["type"] == typ {
+			return evt
+		}
+	}
+	t.Fatalf("did not receive %q event", typ)
+	return nil
+}
+
+func TestServe_SendMessage(t *testing.T) {
+	env := newTestEnv(t)
+	conn := env.dial(fmt.Sprintf("room_id=%d&token=%s", env.roomID, env.token))
+
+	if err := conn.WriteJSON(map[string]string{"type": "message", "content": "hello", "client_msg_id": "c1"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	ack := readUntil(t, conn, "ack")
+	if ack["client_msg_id"] != "c1" || ack["duplicate"] != false {
+		t.Errorf("ack = %v, want client_msg_id c1 and duplicate false", ack)
+	}
+	msg := readUntil(t, conn, "message")
+	if msg["content"] != "hello" || msg["seq"] != float64(1) {
+		t.Errorf("message = %v, want content hello and seq 1", msg)
+	}
+}
+
+func TestServe_ResumeQueryParam(t *testing.T) {
+	env := newTestEnv(t)
+	ids := env.seedMessages(3)
+
+	conn := env.dial(fmt.Sprintf("room_id=%d&token=%s&last_seen_id=%d", env.roomID, env.token, ids[0]))
+	for _, want := range []string{"m1", "m2"} {
+		evt := readEvent(t, conn)
+		if evt["type"] != "message" || evt["content"] != want {
+			t.Fatalf("replayed event = %v, want message %s", evt, want)
+		}
+	}
+	done := readEvent(t, conn)
+	if done["type"] != "resumed" || done["count"] != float64(2) {
+		t.Fatalf("event = %v, want resumed with count 2", done)
+	}
+	// 续传结束后才开始投递实时事件。
+	if evt := readEvent(t, conn); evt["type"] != "join" {
+		t.Errorf("first live event = %v, want join", evt)
+	}
+}
+
+func TestServe_ResumeFirstFrame(t *testing.T) {
+	env := newTestEnv(t)
+	ids := env.seedMessages(2)
+
+	conn := env.dial(fmt.Sprintf("room_id=%d&token=%s", env.roomID, env.token))
+	if err := conn.WriteJSON(map[string]interface{}{"type": "resume", "last_seen_id": ids[0]}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	evt := readEvent(t, conn)
+	if evt["type"] != "message" || evt["content"] != "m1" {
+		t.Fatalf("replayed event = %v, want message m1", evt)
+	}
+	if done := readEvent(t, conn); done["type"] != "resumed" {
+		t.Fatalf("event = %v, want resumed", done)
+	}
+}
+
+func TestServe_ResumeTooManyRequiresResync(t *testing.T) {
+	env := newTestEnv(t)
+	ids := env.seedMessages(env.cfg.WSResumeMaxMessages + 2)
+
+	conn := env.dial(fmt.Sprintf("room_id=%d&token=%s&last_seen_id=%d", env.roomID, env.token, ids[0]))
+	evt := readEvent(t, conn)
+	if evt["type"] != "resync_required" {
+		t.Fatalf("event = %v, want resync_required", evt)
+	}
+}
+
+func TestServe_RejectsInvalidLastSeenID(t *testing.T) {
+	env := newTestEnv(t)
+	for _, path := range []string{
+		fmt.Sprintf("/ws?room_id=%d&token=%s&last_seen_id=abc", env.roomID, env.token),
+		fmt.Sprintf("/rooms/%d/events?token=%s&last_seen_id=-1", env.roomID, env.token),
+	} {
+		resp, err := http.Get(env.srv.URL + path)
+		if err != nil {
+			t.Fatalf("get %s: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestServe_MultiplexedRooms(t *testing.T) {
+	env := newTestEnv(t)
+	other := env.createRoom("random")
+	conn := env.dial("token=" + env.token)
+
+	for _, id := range []uint{env.roomID, other} {
+		if err := conn.WriteJSON(map[string]interface{}{"type": "subscribe", "room_id": id}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if evt := readUntil(t, conn, "subscribed"); evt["room_id"] != float64(id) {
+			t.Fatalf("subscribed = %v, want room_id %d", evt, id)
+		}
+	}
+	if env.hub.Online(env.roomID) != 1 || env.hub.Online(other) != 1 {
+		t.Fatalf("Online() = %d/%d, want 1/1", env.hub.Online(env.roomID), env.hub.Online(other))
+	}
+
+	// 订阅多个房间时必须指明 room_id。
+	if err := conn.WriteJSON(map[string]interface{}{"type": "message", "content": "where"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	readUntil(t, conn, "error")
+
+	if err := conn.WriteJSON(map[string]interface{}{"type": "message", "room_id": other, "content": "hi"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if msg := readUntil(t, conn, "message"); msg["room_id"] != float64(other) || msg["content"] != "hi" {
+		t.Errorf("message = %v, want content hi in room %d", msg, other)
+	}
+
+	if err := conn.WriteJSON(map[string]interface{}{"type": "unsubscribe", "room_id": other}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	readUntil(t, conn, "unsubscribed")
+	if env.hub.Online(other) != 0 {
+		t.Errorf("Online(other) after unsubscribe = %d, want 0", env.hub.Online(other))
+	}
+	if err := conn.WriteJSON(map[string]interface{}{"type": "message", "room_id": other, "content": "gone"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if evt := readUntil(t, conn, "error"); evt["room_id"] != float64(other) {
+		t.Errorf("error = %v, want room_id %d", evt, other)
+	}
+
+	// 断开后从所有房间注销。
+	_ = conn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for env.hub.Online(env.roomID) != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if env.hub.Online(env.roomID) != 0 {
+		t.Errorf("Online() after disconnect = %d, want 0", env.hub.Online(env.roomID))
+	}
+}
+
+func TestServe_SubscribeWithResume(t *testing.T) {
+	env := newTestEnv(t)
+	ids := env.seedMessages(3)
+	conn := env.dial("token=" + env.token)
+
+	if err := conn.WriteJSON(map[string]interface{}{"type": "subscribe", "room_id": env.roomID, "last_seen_id": ids[1]}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	for _, want := range []string{"subscribed", "message", "resumed", "join"} {
+		if evt := readEvent(t, conn); evt["type"] != want {
+			t.Fatalf("event = %v, want %s", evt, want)
+		}
+	}
+}
+
+func TestServe_PresenceDedupesTabs(t *testing.T) {
+	env := newTestEnv(t)
+	query := fmt.Sprintf("room_id=%d&token=%s", env.roomID, env.token)
+	first := env.dial(query)
+	readUntil(t, first, "join")
+	// 第二个标签页不会再广播 join，用 ping 确认连接已注册。
+	second := env.dial(query)
+	if err := second.WriteJSON(map[string]string{"type": "ping"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	readUntil(t, second, "pong")
+
+	members, err := env.hub.Members(env.roomID)
+	if err != nil {
+		t.Fatalf("Members() error = %v", err)
+	}
+	if len(members) != 1 || members[0].Username != "alice" {
+		t.Errorf("Members() = %+v, want only alice", members)
+	}
+
+	// 关闭一个标签页后用户仍然在线，不应广播 leave。
+	_ = second.Close()
+	if err := first.WriteJSON(map[string]string{"type": "ping"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if evt := readEvent(t, first); evt["type"] != "pong" {
+		t.Errorf("event = %v, want pong without leave", evt)
+	}
+	if counts, _ := env.hub.OnlineCounts([]uint{env.roomID}); counts[env.roomID] != 1 {
+		t.Errorf("OnlineCounts() = %v, want 1", counts)
+	}
+}
+
+func TestServe_IdleAndStatus(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) { cfg.WSIdleSeconds = 1 })
+	conn := env.dial(fmt.Sprintf("room_id=%d&token=%s", env.roomID, env.token))
+	readUntil(t, conn, "join")
+
+	// 一段时间无操作后自动显示为 away。
+	evt := readUntil(t, conn, "presence")
+	if evt["status"] != "away" || evt["idle"] != true || evt["room_id"] != float64(env.roomID) {
+		t.Fatalf("presence = %v, want idle away", evt)
+	}
+
+	if err := conn.WriteJSON(map[string]string{"type": "active"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if evt := readUntil(t, conn, "presence"); evt["status"] != "online" || evt["idle"] != false {
+		t.Fatalf("presence = %v, want online after activity", evt)
+	}
+
+	if err := conn.WriteJSON(map[string]interface{}{"type": "status", "status": "busy", "status_text": "focus", "expires_in": 60}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	evt = readUntil(t, conn, "presence")
+	if evt["status"] != "busy" || evt["status_text"] != "focus" || evt["status_expires_at"] == nil {
+		t.Errorf("presence = %v, want busy with text and expiry", evt)
+	}
+}
+
+func TestServe_MsgpackSubprotocol(t *testing.T) {
+	env := newTestEnv(t)
+	conn := env.dial(fmt.Sprintf("room_id=%d&token=%s", env.roomID, env.token), SubprotocolMsgpack, SubprotocolJSON)
+	if conn.Subprotocol() != SubprotocolMsgpack {
+		t.Fatalf("Subprotocol() = %q, want %q", conn.Subprotocol(), SubprotocolMsgpack)
+	}
+
+	var req []byte
+	if err := codec.NewEncoderBytes(&req, msgpackHandle).Encode(map[string]interface{}{"type": "message", "content": "hello"}); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if err := conn.WriteMessage(websocket.BinaryMessage, req); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		typ, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if typ != websocket.BinaryMessage {
+			t.Fatalf("message type = %d, want binary", typ)
+		}
+		var evt map[string]interface{}
+		if err := codec.NewDecoderBytes(data, msgpackHandle).Decode(&evt); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if evt["type"] == "message" {
+			if evt["content"] != "hello" || fmt.Sprint(evt["seq"]) != "1" {
+				t.Errorf("message = %v, want content hello and seq 1", evt)
+			}
+			return
+		}
+	}
+	t.Fatal("did not receive message event")
+}
+
+func TestServe_DefaultsToJSON(t *testing.T) {
+	env := newTestEnv(t)
+	conn := env.dial(fmt.Sprintf("room_id=%d&token=%s", env.roomID, env.token), "chatroom.v1.unknown")
+	if conn.Subprotocol() != "" {
+		t.Errorf("Subprotocol() = %q, want none", conn.Subprotocol())
+	}
+	if evt := readEvent(t, conn); evt["type"] != "join" {
+		t.Errorf("event = %v, want JSON join", evt)
+	}
+}
+
+func TestServe_ProtocolV2(t *testing.T) {
+	env := newTestEnv(t)
+	conn := env.dial(fmt.Sprintf("room_id=%d&token=%s&protocol=2&lang=en", env.roomID, env.token))
+
+	welcome := readEvent(t, conn)
+	if welcome["type"] != "welcome" || welcome["protocol"] != float64(2) || welcome["lang"] != "en" || welcome["codec"] != SubprotocolJSON {
+		t.Fatalf("first event = %v, want welcome for protocol 2 in en", welcome)
+	}
+
+	if err := conn.WriteJSON(map[string]string{"type": "shout", "content": "hi"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	evt := readUntil(t, conn, "error")
+	if evt["code"] != string(ErrCodeUnknownType) || evt["message"] != "unsupported frame type" {
+		t.Errorf("error = %v, want unknown_type in English", evt)
+	}
+	if _, ok := evt["content"]; ok {
+		t.Errorf("error = %v, v2 should not carry content", evt)
+	}
+	var count int64
+	env.db.Model(&models.Message{}).Count(&count)
+	if count != 0 {
+		t.Errorf("unknown frame persisted %d messages, want 0", count)
+	}
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("not json")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if evt := readUntil(t, conn, "error"); evt["code"] != string(ErrCodeInvalidFrame) {
+		t.Errorf("error = %v, want invalid_frame", evt)
+	}
+}
+
+func TestServe_ProtocolV1TreatsUnknownAsMessage(t *testing.T) {
+	env := newTestEnv(t)
+	conn := env.dial(fmt.Sprintf("room_id=%d&token=%s", env.roomID, env.token))
+
+	if err := conn.WriteJSON(map[string]string{"content": ""}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := conn.WriteJSON(map[string]string{"content": strings.Repeat("x", 2001)}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	evt := readUntil(t, conn, "error")
+	if evt["code"] != string(ErrCodeMessageTooLong) || evt["content"] != "消息长度不能超过2000字符" || evt["message"] != evt["content"] {
+		t.Errorf("error = %v, want message_too_long with Chinese content", evt)
+	}
+	if err := conn.WriteJSON(map[string]string{"content": "legacy"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if msg := readUntil(t, conn, "message"); msg["content"] != "legacy" {
+		t.Errorf("message = %v, want content legacy", msg)
+	}
+}
+
+func TestServe_RejectsUnsupportedProtocol(t *testing.T) {
+	env := newTestEnv(t)
+	url := "ws" + strings.TrimPrefix(env.srv.URL, "http") + fmt.Sprintf("/ws?token=%s&protocol=9", env.token)
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil {
+		t.Fatal("dial succeeded, want rejection")
+	}
+	if resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("response = %v, want 400", resp)
+	}
+}
+
+func TestServe_TicketBoundToRoom(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) { cfg.WSQueryTokenEnabled = false })
+	other := env.createRoom("random")
+	dto, err := service.NewTicketService(env.db).Issue(env.userID, env.roomID, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+
+	// 票据绑定了房间，未带 room_id 时自动订阅该房间。
+	conn := env.dial("protocol=2&ticket=" + dto.Ticket)
+	if evt := readUntil(t, conn, "join"); evt["room_id"] != float64(env.roomID) {
+		t.Fatalf("join = %v, want room_id %d", evt, env.roomID)
+	}
+	if err := conn.WriteJSON(map[string]interface{}{"type": "subscribe", "room_id": other}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if evt := readUntil(t, conn, "error"); evt["code"] != string(ErrCodeRoomNotAllowed) {
+		t.Errorf("error = %v, want room_not_allowed", evt)
+	}
+
+	// 票据只能使用一次。
+	url := "ws" + strings.TrimPrefix(env.srv.URL, "http") + "/ws?ticket=" + dto.Ticket
+	if _, resp, err := websocket.DefaultDialer.Dial(url, nil); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("reused ticket: err = %v, resp = %v, want 401", err, resp)
+	}
+}
+
+func TestServe_FirstFrameAuth(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) { cfg.WSQueryTokenEnabled = false })
+
+	conn := env.dial(fmt.Sprintf("room_id=%d", env.roomID))
+	if err := conn.WriteJSON(map[string]string{"type": "auth", "token": env.token}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := conn.WriteJSON(map[string]string{"type": "message", "content": "hello", "client_msg_id": "c1"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if ack := readUntil(t, conn, "ack"); ack["client_msg_id"] != "c1" {
+		t.Errorf("ack = %v, want client_msg_id c1", ack)
+	}
+
+	bad := env.dial(fmt.Sprintf("room_id=%d", env.roomID))
+	if err := bad.WriteJSON(map[string]string{"type": "auth", "token": "bogus"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = bad.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, _, err := bad.ReadMessage()
+	if !websocket.IsCloseError(err, CloseUnauthorized) {
+		t.Errorf("read error = %v, want close %d", err, CloseUnauthorized)
+	}
+}
+
+func TestServe_QueryTokenDisabled(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) { cfg.WSQueryTokenEnabled = false })
+	url := "ws" + strings.TrimPrefix(env.srv.URL, "http") + "/ws?token=" + env.token
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil {
+		t.Fatal("dial succeeded, want rejection")
+	}
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("response = %v, want 401", resp)
+	}
+}
+
+func TestServe_Compression(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) {
+		cfg.WSCompressionEnabled = true
+		cfg.WSCompressionMinBytes = 64
+	})
+	dial := func(query string) (*websocket.Conn, string) {
+		t.Helper()
+		dialer := *websocket.DefaultDialer
+		dialer.EnableCompression = true
+		url := "ws" + strings.TrimPrefix(env.srv.URL, "http") + "/ws?" + query
+		conn, resp, err := dialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+		return conn, resp.Header.Get("Sec-WebSocket-Extensions")
+	}
+
+	conn, ext := dial(fmt.Sprintf("room_id=%d&token=%s", env.roomID, env.token))
+	if !strings.Contains(ext, "permessage-deflate") {
+		t.Fatalf("Sec-WebSocket-Extensions = %q, want permessage-deflate", ext)
+	}
+	payload := metrics.WsPayloadBytes.WithLabelValues("true")
+	wire := metrics.WsWireBytes.WithLabelValues("true")
+	payloadBefore, wireBefore := testutil.ToFloat64(payload), testutil.ToFloat64(wire)
+
+	content := strings.Repeat("compressible ", 150)
+	if err := conn.WriteJSON(map[string]string{"type": "message", "content": content}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if msg := readUntil(t, conn, "message"); msg["content"] != content {
+		t.Fatalf("message content mismatch")
+	}
+	sent := testutil.ToFloat64(payload) - payloadBefore
+	written := testutil.ToFloat64(wire) - wireBefore
+	if sent <= float64(len(content)) || written <= 0 || written >= sent/2 {
+		t.Errorf("payload %v bytes, wire %v bytes; want wire well below payload", sent, written)
+	}
+
+	_, ext = dial(fmt.Sprintf("room_id=%d&token=%s&compress=false", env.roomID, env.token))
+	if ext != "" {
+		t.Errorf("Sec-WebSocket-Extensions = %q with compress=false, want none", ext)
+	}
+}
+
+// sseEvent 读取下一个 SSE 事件，跳过注释行。
+func sseEvent(t *testing.T, r *bufio.Reader) (id string, evt map[string]interface{}) {
+	t.Helper()
+	var data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read sse: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "":
+			if data == "" {
+				continue
+			}
+			if err := json.Unmarshal([]byte(data), &evt); err != nil {
+				t.Fatalf("decode sse data %s: %v", data, err)
+			}
+			return id, evt
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestServeEvents_ResumeAndLiveDelivery(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedMessages(3)
+
+	req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/rooms/%d/events?token=%s", env.srv.URL, env.roomID, env.token), nil)
+	req.Header.Set("Last-Event-ID", "1")
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("get events: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+
+	for _, want := range []struct{ id, content string }{{"2", "m1"}, {"3", "m2"}} {
+		id, evt := sseEvent(t, r)
+		if id != want.id || evt["type"] != "message" || evt["content"] != want.content {
+			t.Fatalf("replayed event id=%s %v, want id %s message %s", id, evt, want.id, want.content)
+		}
+	}
+	if _, evt := sseEvent(t, r); evt["type"] != "resumed" {
+		t.Fatalf("event = %v, want resumed", evt)
+	}
+	if _, evt := sseEvent(t, r); evt["type"] != "join" {
+		t.Fatalf("event = %v, want join", evt)
+	}
+
+	// 通过 REST 入口发送的消息与 WebSocket 共用同一个 RoomHub 分发。
+	msg, _, err := env.msgSvc.Create(service.CreateMessageInput{RoomID: env.roomID, UserID: env.userID, Content: "live"})
+	if err != nil {
+		t.Fatalf("create message: %v", err)
+	}
+	NewPublisher(env.hub, env.db, NewUnfurler(env.cfg)).PublishMessage(msg, "alice")
+	id, evt := sseEvent(t, r)
+	if id != "4" || evt["content"] != "live" || evt["username"] != "alice" {
+		t.Errorf("live event id=%s %v, want id 4 content live", id, evt)
+	}
+}
+
+func TestPublisher_WithoutLocalRoom(t *testing.T) {
+	env := newTestEnv(t)
+	relayed := make(chan broker.Envelope, 1)
+	unsubscribe := env.hub.broker.Subscribe(func(e broker.Envelope) { relayed <- e })
+	t.Cleanup(unsubscribe)
+
+	msg, _, err := env.msgSvc.Create(service.CreateMessageInput{RoomID: env.roomID, UserID: env.userID, Content: "rest"})
+	if err != nil {
+		t.Fatalf("create message: %v", err)
+	}
+	NewPublisher(env.hub, env.db, nil).PublishMessage(msg, "alice")
+	select {
+	case e := <-relayed:
+		if e.RoomID != env.roomID || e.Seq != msg.Seq {
+			t.Errorf("relayed %+v, want message seq %d", e, msg.Seq)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("message was not published to the broker")
+	}
+	env.hub.mu.RLock()
+	defer env.hub.mu.RUnlock()
+	if _, ok := env.hub.rooms[env.roomID]; ok {
+		t.Error("PublishMessage created a RoomHub without subscribers")
+	}
+}
+
+func TestServeEvents_RequiresToken(t *testing.T) {
+	env := newTestEnv(t)
+	resp, err := http.Get(fmt.Sprintf("%s/rooms/%d/events", env.srv.URL, env.roomID))
+	if err != nil {
+		t.Fatalf("get events: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", resp.StatusCode)
+	}
+}
@@ -0,0 +1,105 @@
+package ws
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// drainPoll 是排空期间检查剩余连接数的间隔。
+const drainPoll = 50 * time.Millisecond
+
+// track 登记一个实时连接，Hub 正在排空时返回 false，调用方应立即断开。
+func (h *Hub) track(c *Client) bool {
+	h.lmu.Lock()
+	defer h.lmu.Unlock()
+	if h.draining {
+		return false
+	}
+	h.live[c] = struct{}{}
+	return true
+}
+
+// untrack 在连接结束时注销登记。
+func (h *Hub) untrack(c *Client) {
+	h.lmu.Lock()
+	delete(h.live, c)
+	h.lmu.Unlock()
+}
+
+// Draining 表示 Hub 是否处于排空模式，就绪探针据此摘除流量。
+func (h *Hub) Draining() bool {
+	h.lmu.Lock()
+	defer h.lmu.Unlock()
+	return h.draining
+}
+
+// Drain 进入排空模式：就绪探针立即转为未就绪并拒绝新连接，等待 grace 让负载均衡摘除本实例后，
+// 再通知现有连接在 delay 加上不超过 jitter 的随机时长后重连，写完发送队列后以 1012 关闭。
+// grace 应不短于一个探针周期，否则立即重连的客户端可能又被路由回本实例。
+// 所有连接结束或 ctx 到期后返回，后者返回 ctx 的错误。
+func (h *Hub) Drain(ctx context.Context, grace, delay, jitter time.Duration) error {
+	h.lmu.Lock()
+	h.draining = true
+	h.lmu.Unlock()
+
+	if grace > 0 {
+		log.Info().Dur("grace", grace).Msg("ws draining, waiting for load balancers")
+		timer := time.NewTimer(grace)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	h.lmu.Lock()
+	clients := make([]*Client, 0, len(h.live))
+	for c := range h.live {
+		clients = append(clients, c)
+	}
+	h.lmu.Unlock()
+
+	log.Info().Int("connections", len(clients)).Msg("ws draining")
+	for _, c := range clients {
+		wait := delay
+		if jitter > 0 {
+			wait += rand.N(jitter)
+		}
+		c.restart(wait)
+	}
+
+	ticker := time.NewTicker(drainPoll)
+	defer ticker.Stop()
+	for {
+		h.lmu.Lock()
+		left := len(h.live)
+		h.lmu.Unlock()
+		if left == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			log.Warn().Int("connections", left).Msg("ws drain timed out")
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// restart 推送 server_restarting 事件并以 CloseServiceRestart 关闭连接，writePump 会先写完发送队列。
+func (c *Client) restart(wait time.Duration) {
+	c.trySend(marshalEvent(ServerRestartingEvent{Type: TypeServerRestart, ReconnectAfter: int(wait / time.Millisecond)}))
+	c.closeWith(CloseServiceRestart, "server restarting")
+}
+
+// rejectDraining 在排空期间以 503 拒绝新连接。
+func rejectDraining(c *gin.Context) {
+	c.Header("Retry-After", "5")
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": "server is restarting"})
+}
@@ -0,0 +1,82 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHub_DrainClosesConnections(t *testing.T) {
+	env := newTestEnv(t)
+	conn := env.dial(fmt.Sprintf("room_id=%d&token=%s&protocol=2", env.roomID, env.token))
+	readUntil(t, conn, "join")
+
+	drained := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		drained <- env.hub.Drain(ctx, 300*time.Millisecond, time.Second, time.Second)
+	}()
+
+	// 宽限期内已标记为未就绪，但现有连接尚未收到通知。
+	time.Sleep(100 * time.Millisecond)
+	if !env.hub.Draining() {
+		t.Error("Draining() = false during grace period")
+	}
+	if err := conn.WriteJSON(map[string]string{"type": "ping"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if evt := readEvent(t, conn); evt["type"] != "pong" {
+		t.Errorf("event during grace = %v, want pong", evt)
+	}
+
+	evt := readUntil(t, conn, TypeServerRestart)
+	if ms, _ := evt["reconnect_after_ms"].(float64); ms < 1000 || ms >= 2000 {
+		t.Errorf("reconnect_after_ms = %v, want within [1000, 2000)", evt["reconnect_after_ms"])
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, CloseServiceRestart) {
+				t.Errorf("read error = %v, want close %d", err, CloseServiceRestart)
+			}
+			break
+		}
+	}
+	if err := <-drained; err != nil {
+		t.Fatalf("Drain() = %v", err)
+	}
+	if !env.hub.Draining() {
+		t.Error("Draining() = false after Drain")
+	}
+
+	// 排空后拒绝新连接。
+	url := "ws" + strings.TrimPrefix(env.srv.URL, "http") + "/ws?token=" + env.token
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("dial while draining: err = %v, resp = %v, want 503", err, resp)
+	}
+}
+
+func TestHub_DrainTimeout(t *testing.T) {
+	hub := NewHub()
+	t.Cleanup(hub.Shutdown)
+	// 没有 writePump 的连接不会自行结束，Drain 应在 ctx 到期时返回。
+	c := &Client{send: newSendQueue(8, PolicyDisconnect), done: make(chan struct{})}
+	if !hub.track(c) {
+		t.Fatal("track() = false before drain")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := hub.Drain(ctx, 0, 0, 0); err != context.DeadlineExceeded {
+		t.Fatalf("Drain() = %v, want deadline exceeded", err)
+	}
+	if hub.track(&Client{}) {
+		t.Error("track() = true while draining")
+	}
+}
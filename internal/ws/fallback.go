@@ -0,0 +1,203 @@
+package ws
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"chatroom/internal/config"
+	"chatroom/internal/metrics"
+	"chatroom/internal/models"
+	"chatroom/internal/service"
+	"chatroom/internal/unfurl"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// ServeEvents 返回 SSE 处理函数，供无法使用 WebSocket 的客户端接收房间的实时事件。
+// SSE 连接与 WebSocket 连接一样注册到 RoomHub，事件格式完全相同；发送消息改用 REST 接口。
+// 消息事件以 seq 作为事件 ID，浏览器重连时带上 Last-Event-ID 即可补发错过的消息。
+// mod 与 Serve 共用同一个实例。
+func ServeEvents(h *Hub, db *gorm.DB, cfg config.Config, msgSvc *service.MessageService, mod *service.ModerationService) gin.HandlerFunc {
+	statusSvc := service.NewStatusService(db, h)
+	authn := newAuthenticator(db, cfg)
+	return func(c *gin.Context) {
+		if h.Draining() {
+			rejectDraining(c)
+			return
+		}
+		rid, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil || rid == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid room id"})
+			return
+		}
+		if _, _, ok := parseLastSeenID(c); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid last_seen_id"})
+			return
+		}
+		id, err := authn.fromRequest(c)
+		if err == nil {
+			_, err = id.bindRoom(uint(rid))
+		}
+		if err != nil {
+			rejectRequest(c, err)
+			return
+		}
+		if !checkBan(c, mod, id.user.ID, uint(rid)) {
+			return
+		}
+		user := id.user
+		var room models.Room
+		if err := db.Select("id").First(&room, uint(rid)).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+			return
+		}
+		lastSeen, err := sseLastSeenID(c, db, room.ID)
+		if err != nil {
+			log.Error().Err(err).Uint("room_id", room.ID).Msg("sse resolve last event id")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to resume"})
+			return
+		}
+		ip := c.ClientIP()
+		if h.roomFull(room.ID) {
+			rejectConn(c, limitRoom)
+			return
+		}
+		if reason := h.conns.admit(user.ID, ip); reason != "" {
+			rejectConn(c, reason)
+			return
+		}
+		defer h.conns.release(user.ID, ip)
+
+		header := c.Writer.Header()
+		header.Set("Content-Type", "text/event-stream")
+		header.Set("Cache-Control", "no-cache")
+		header.Set("Connection", "keep-alive")
+		// 关闭 nginx 等反向代理的响应缓冲，否则事件会被攒批下发。
+		header.Set("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+
+		metrics.SseConnections.Inc()
+		defer metrics.SseConnections.Dec()
+		client := newClient(h, nil, db, user, cfg, msgSvc, statusSvc, nil)
+		client.lang = negotiateLang(c.Query("lang"), c.GetHeader("Accept-Language"))
+		t := &sseTransport{w: c.Writer, rc: http.NewResponseController(c.Writer)}
+		client.out = t
+		client.authn = authn
+		if !h.track(client) {
+			t.farewell(CloseServiceRestart, "server restarting")
+			return
+		}
+		defer h.untrack(client)
+		if !t.comment("connected") {
+			return
+		}
+		// SSE 连接没有上行帧，无法判断用户是否在操作，因此不参与空闲检测。
+		if h.connect(client) {
+			h.tracker.SetIdle(user.ID, false)
+			client.announceStatus()
+		}
+		// SSE 无法发送 reauth 帧，凭证到期时断开，客户端用新票据重连。
+		client.watchExpiry(id.expiresAt)
+		defer func() {
+			client.stopExpiry()
+			client.disconnectActivity()
+			client.leaveAll()
+		}()
+
+		client.holdRoom(room.ID)
+		if err := client.joinRoom(room.ID); err != nil {
+			return
+		}
+		client.releaseRoom(room.ID, client.buildResume(room.ID, lastSeen))
+
+		go func() {
+			select {
+			case <-c.Request.Context().Done():
+				client.close()
+			case <-client.done:
+			}
+		}()
+		client.writePump()
+	}
+}
+
+// sseLastSeenID 把 Last-Event-ID（消息 seq）换算为续传使用的消息 ID；
+// 也接受与 /ws 相同的 last_seen_id 查询参数。
+func sseLastSeenID(c *gin.Context, db *gorm.DB, roomID uint) (uint, error) {
+	if id, present, _ := parseLastSeenID(c); present {
+		return id, nil
+	}
+	seq, err := strconv.ParseUint(c.GetHeader("Last-Event-ID"), 10, 64)
+	if err != nil || seq == 0 {
+		return 0, nil
+	}
+	var ids []uint
+	err = db.Model(&models.Message{}).Where("room_id = ? AND seq <= ?", roomID, seq).
+		Order("seq desc").Limit(1).Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+// sseTransport 以 text/event-stream 写出事件，数据始终是 JSON。
+type sseTransport struct {
+	w  gin.ResponseWriter
+	rc *http.ResponseController
+}
+
+func (t *sseTransport) write(f frame) bool {
+	_ = t.rc.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if f.seq != 0 {
+		if _, err := fmt.Fprintf(t.w, "id: %d\n", f.seq); err != nil {
+			return false
+		}
+	}
+	if _, err := fmt.Fprintf(t.w, "data: %s\n\n", f.data); err != nil {
+		return false
+	}
+	return t.rc.Flush() == nil
+}
+
+// comment 写出注释行，浏览器会忽略它，但能让代理认为连接仍然活跃。
+func (t *sseTransport) comment(text string) bool {
+	_ = t.rc.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := fmt.Fprintf(t.w, ": %s\n\n", text); err != nil {
+		return false
+	}
+	return t.rc.Flush() == nil
+}
+
+func (t *sseTransport) heartbeat() bool { return t.comment("ping") }
+
+// farewell 在服务端主动断开时推送 closed 事件，客户端据此决定是否重连。
+func (t *sseTransport) farewell(code int, reason string) {
+	if code == 0 {
+		return
+	}
+	t.write(frame{data: marshalEvent(ClosedEvent{Type: TypeClosed, Code: code, Reason: reason})})
+}
+
+// shutdown 无需处理：处理函数返回后由 net/http 结束响应。
+func (t *sseTransport) shutdown() {}
+
+// Publisher 把通过 REST 接口创建的消息推送给房间内的实时连接（WebSocket 与 SSE）。
+type Publisher struct {
+	hub      *Hub
+	db       *gorm.DB
+	unfurler *unfurl.Unfurler
+}
+
+// NewPublisher 创建 Publisher，unf 应与 Serve 使用同一个抓取器。
+func NewPublisher(h *Hub, db *gorm.DB, unf *unfurl.Unfurler) *Publisher {
+	return &Publisher{hub: h, db: db, unfurler: unf}
+}
+
+// PublishMessage 广播一条新消息。本实例没有该房间的 RoomHub 时直接发布到 broker，不会为此创建空房间。
+func (p *Publisher) PublishMessage(msg *models.Message, username string) {
+	broadcastMessage(p.hub, newOutbound(msg, username), p.unfurler, p.db)
+}
@@ -1,20 +1,165 @@
 package ws
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"chatroom/internal/broker"
 	"chatroom/internal/metrics"
+	"chatroom/internal/presence"
+
+	"github.com/rs/zerolog/log"
 )
 
 // Hub 管理房间级别的子 Hub，实现延迟创建与并发安全。
+// 本地产生的房间事件会经由 broker 转发给其它实例，其它实例的事件也从 broker 注入本地房间。
 type Hub struct {
 	mu    sync.RWMutex
 	rooms map[uint]*RoomHub
+
+	broker      broker.Broker
+	instanceID  string
+	outbox      chan broker.Envelope
+	unsubscribe func()
+	done        chan struct{}
+	doneOnce    sync.Once
+
+	tracker *presence.Tracker
+
+	// activity 记录每个用户在本实例上的连接与空闲情况，用于自动 away 检测。
+	amu      sync.Mutex
+	activity map[uint]*userActivity
+
+	// conns 统计本实例的连接数并执行 ConnLimits，roomLimit 是单个房间的连接上限。
+	conns     *connCounter
+	roomLimit int
+
+	// live 是本实例上所有实时连接，draining 表示已进入排空模式，二者由 lmu 保护。
+	lmu      sync.Mutex
+	live     map[*Client]struct{}
+	draining bool
+
+	// persist 是可选的异步落库管道，由 Serve 创建。
+	persist *persister
+
+	// roomIdle 是房间没有本地连接后保留 RoomHub 的时长，超时后回收其 goroutine。
+	roomIdle time.Duration
 }
 
-func NewHub() *Hub { return &Hub{rooms: make(map[uint]*RoomHub)} }
+// HubOptions 配置 Hub 的跨实例依赖，零值表示单实例运行。
+type HubOptions struct {
+	// Broker 用于实例间转发房间事件，为 nil 时使用进程内实现；由调用方负责关闭。
+	Broker broker.Broker
+	// Presence 用于汇总集群在线状态，为 nil 时在线人数只统计本实例。
+	Presence *presence.Tracker
+	// Limits 限制本实例的连接数，零值表示不限制。
+	Limits ConnLimits
+	// RoomIdle 是空房间回收前的等待时长，零值使用 defaultRoomIdle。
+	RoomIdle time.Duration
+}
+
+// defaultRoomIdle 是空房间默认保留的时长。
+const defaultRoomIdle = 5 * time.Minute
+
+// outboxSize 是等待发布到 broker 的事件队列长度。
+const outboxSize = 1024
+
+// NewHub 创建只在当前进程内分发事件的 Hub。
+func NewHub() *Hub { return NewHubWithOptions(HubOptions{}) }
+
+// NewHubWithOptions 按配置创建 Hub。
+func NewHubWithOptions(opts HubOptions) *Hub {
+	if opts.Broker == nil {
+		opts.Broker = broker.NewMemory()
+	}
+	if opts.RoomIdle <= 0 {
+		opts.RoomIdle = defaultRoomIdle
+	}
+	h := &Hub{
+		rooms:      make(map[uint]*RoomHub),
+		broker:     opts.Broker,
+		instanceID: newInstanceID(),
+		outbox:     make(chan broker.Envelope, outboxSize),
+		done:       make(chan struct{}),
+		tracker:    opts.Presence,
+		activity:   make(map[uint]*userActivity),
+		conns:      newConnCounter(opts.Limits),
+		roomLimit:  opts.Limits.PerRoom,
+		roomIdle:   opts.RoomIdle,
+		live:       make(map[*Client]struct{}),
+	}
+	h.unsubscribe = h.broker.Subscribe(h.receive)
+	go h.relayLoop()
+	return h
+}
+
+func newInstanceID() string {
+	buf := make([]byte, 8)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// relay 把本地房间事件放入发布队列；队列已满时丢弃，避免阻塞 RoomHub。
+func (h *Hub) relay(f frame) {
+	env := broker.Envelope{Origin: h.instanceID, RoomID: f.roomID, Seq: f.seq, Data: f.data, Ephemeral: f.ephemeral, Key: f.key}
+	select {
+	case h.outbox <- env:
+	default:
+		log.Warn().Uint("room_id", f.roomID).Msg("ws broker outbox full, dropping event")
+	}
+}
+
+// relayLoop 按顺序把发布队列中的事件写入 broker。
+func (h *Hub) relayLoop() {
+	for {
+		select {
+		case env := <-h.outbox:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := h.broker.Publish(ctx, env); err != nil {
+				log.Error().Err(err).Uint("room_id", env.RoomID).Msg("ws broker publish")
+			}
+			cancel()
+		case <-h.done:
+			return
+		}
+	}
+}
+
+// receive 把其它实例发布的事件投递给本地房间；本地没有该房间的连接时直接忽略。
+func (h *Hub) receive(env broker.Envelope) {
+	if env.Origin == h.instanceID {
+		return
+	}
+	if env.Kick != 0 {
+		// 踢人可能要等待房间注销连接，不阻塞 broker 的投递。
+		go h.kickLocal(env.RoomID, env.Kick, env.Data)
+		return
+	}
+	h.mu.RLock()
+	room := h.rooms[env.RoomID]
+	h.mu.RUnlock()
+	if room == nil {
+		return
+	}
+	room.publish(frame{data: env.Data, roomID: env.RoomID, seq: env.Seq, remote: true, ephemeral: env.Ephemeral, key: env.Key})
+}
+
+// publish 把本地产生的事件投递给房间：本实例有该房间时经由 RoomHub 分发，
+// 否则（房间已被回收或从未创建）直接转发到 broker，不为此创建空的 RoomHub。
+func (h *Hub) publish(f frame) {
+	h.mu.RLock()
+	room := h.rooms[f.roomID]
+	h.mu.RUnlock()
+	if room != nil && room.publish(f) {
+		return
+	}
+	h.relay(f)
+}
 
 // GetRoom 若房间未初始化则懒加载一个 RoomHub。
 func (h *Hub) GetRoom(roomID uint) *RoomHub {
@@ -31,11 +176,40 @@ func (h *Hub) GetRoom(roomID uint) *RoomHub {
 		return room
 	}
 	room = NewRoomHub(roomID)
+	room.maxConns = h.roomLimit
+	room.relay = h.relay
+	room.tracker = h.tracker
+	room.idleAfter = h.roomIdle
+	room.reap = h.removeRoom
 	h.rooms[roomID] = room
+	metrics.WsRoomHubs.Inc()
 	go room.run()
 	return room
 }
 
+// removeRoom 在房间空闲超时后把它移出 Hub；房间已被 Shutdown 移除时返回 false。
+// 移除后新的 GetRoom 会创建新的 RoomHub，仍持有旧实例的 join 会因其停止而重试。
+func (h *Hub) removeRoom(rh *RoomHub) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.rooms[rh.roomID] != rh {
+		return false
+	}
+	delete(h.rooms, rh.roomID)
+	return true
+}
+
+// closed 表示 Hub 已关闭。
+func (h *Hub) closed() bool {
+	select {
+	case <-h.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Online 返回本实例上房间的在线用户数。
 func (h *Hub) Online(roomID uint) int {
 	h.mu.RLock()
 	room := h.rooms[roomID]
@@ -46,14 +220,46 @@ func (h *Hub) Online(roomID uint) int {
 	return room.Online()
 }
 
-// Shutdown 关闭所有 RoomHub goroutine，用于优雅停服。
+// OnlineCounts 返回各房间的在线用户数；配置了 presence 时为集群范围，否则只统计本实例。
+func (h *Hub) OnlineCounts(roomIDs []uint) (map[uint]int, error) {
+	if h.tracker != nil {
+		return h.tracker.OnlineCounts(roomIDs)
+	}
+	out := make(map[uint]int, len(roomIDs))
+	for _, id := range roomIDs {
+		out[id] = h.Online(id)
+	}
+	return out, nil
+}
+
+// Members 返回房间的在线成员；配置了 presence 时为集群范围，否则只包含本实例的连接。
+func (h *Hub) Members(roomID uint) ([]presence.Member, error) {
+	if h.tracker != nil {
+		return h.tracker.Members(roomID)
+	}
+	h.mu.RLock()
+	room := h.rooms[roomID]
+	h.mu.RUnlock()
+	if room == nil {
+		return []presence.Member{}, nil
+	}
+	return room.members(), nil
+}
+
+// Shutdown 关闭所有 RoomHub goroutine 并停止与 broker 的互通，用于优雅停服。
 func (h *Hub) Shutdown() {
+	// 先写完排队的消息，回调中的广播仍需要房间在运行。
+	h.flushPersister()
 	h.mu.Lock()
 	defer h.mu.Unlock()
 	for id, room := range h.rooms {
 		room.Stop()
 		delete(h.rooms, id)
 	}
+	h.doneOnce.Do(func() {
+		h.unsubscribe()
+		close(h.done)
+	})
 }
 
 type RoomHub struct {
@@ -61,89 +267,256 @@ type RoomHub struct {
 	clients    map[*Client]bool
 	register   chan *Client
 	unregister chan *Client
-	broadcast  chan []byte
+	broadcast  chan frame
 	stop       chan struct{}
+	stopOnce   sync.Once
 	online     int32
+
+	// pubMu 让 publish 与停止互斥，停止后不会再有事件进入 broadcast。
+	pubMu sync.RWMutex
+
+	// idleAfter 是没有本地连接后等待回收的时长，reap 把房间移出 Hub；二者为零值时不回收。
+	idleAfter time.Duration
+	reap      func(*RoomHub) bool
+
+	// maxConns 是本实例上房间的连接上限，conns 是已占用的名额，均只在 maxConns 大于零时维护。
+	maxConns int
+	conns    atomic.Int32
+
+	// users 记录每个用户在本房间的连接数，同一用户的多个标签页只计一次在线。
+	mu    sync.Mutex
+	users map[uint]*member
+
+	// relay 把本地产生的事件转发到其它实例，为 nil 时只在本地分发。
+	relay func(frame)
+	// tracker 汇总集群在线状态，为 nil 时不上报。
+	tracker *presence.Tracker
+}
+
+type member struct {
+	name  string
+	conns int
 }
 
 func NewRoomHub(roomID uint) *RoomHub {
 	return &RoomHub{
 		roomID:     roomID,
 		clients:    make(map[*Client]bool),
+		users:      make(map[uint]*member),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
-		broadcast:  make(chan []byte, 256),
+		broadcast:  make(chan frame, roomBacklog),
 		stop:       make(chan struct{}),
 	}
 }
 
 func (rh *RoomHub) run() {
+	defer metrics.WsRoomHubs.Dec()
+	idle := rh.idleTimer()
 	for {
 		select {
+		case <-idle:
+			idle = nil
+			if len(rh.clients) > 0 {
+				continue
+			}
+			if len(rh.broadcast) > 0 || rh.reap == nil || !rh.reap(rh) {
+				// 还有待分发的事件，或房间已被 Shutdown 接管，稍后再处理。
+				idle = rh.idleTimer()
+				continue
+			}
+			rh.halt()
+			return
 		case <-rh.stop:
 			// 关闭所有客户端连接
 			for c := range rh.clients {
-				close(c.send)
+				c.close()
 				delete(rh.clients, c)
 			}
+			rh.mu.Lock()
+			for id := range rh.users {
+				rh.tracker.Leave(rh.roomID, id)
+				delete(rh.users, id)
+			}
+			rh.mu.Unlock()
 			atomic.StoreInt32(&rh.online, 0)
+			rh.conns.Store(0)
 			return
 		case c := <-rh.register:
+			if rh.clients[c] {
+				rh.unadmit()
+				continue
+			}
 			rh.clients[c] = true
-			atomic.StoreInt32(&rh.online, int32(len(rh.clients)))
-			metrics.WsConnections.Inc()
-			evt := map[string]interface{}{"type": "join", "room_id": rh.roomID, "user_id": c.userID, "username": c.uname, "online": int(atomic.LoadInt32(&rh.online))}
-			if b, err := json.Marshal(evt); err == nil {
-				for cli := range rh.clients {
-					select {
-					case cli.send <- b:
-					default:
-						close(cli.send)
-						delete(rh.clients, cli)
-					}
-				}
+			idle = nil
+			// 同一用户再开一个标签页不再重复广播 join。
+			if rh.addUser(c) {
+				rh.tracker.Join(rh.roomID, c.userID)
+				rh.emit(rh.presenceEvent(TypeJoin, c))
 			}
 		case c := <-rh.unregister:
-			if _, ok := rh.clients[c]; ok {
-				delete(rh.clients, c)
-				close(c.send)
-				atomic.StoreInt32(&rh.online, int32(len(rh.clients)))
-				metrics.WsConnections.Dec()
-				evt := map[string]interface{}{"type": "leave", "room_id": rh.roomID, "user_id": c.userID, "username": c.uname, "online": int(atomic.LoadInt32(&rh.online))}
-				if b, err := json.Marshal(evt); err == nil {
-					for cli := range rh.clients {
-						select {
-						case cli.send <- b:
-						default:
-							close(cli.send)
-							delete(rh.clients, cli)
-						}
-					}
-				}
+			rh.remove(c)
+		case msg := <-rh.broadcast:
+			if msg.remote {
+				rh.fanout(msg)
+			} else {
+				rh.emit(msg)
 			}
+		}
+		if idle == nil && len(rh.clients) == 0 {
+			idle = rh.idleTimer()
+		}
+	}
+}
+
+// idleTimer 返回空闲回收的计时通道，未启用回收时返回 nil。
+func (rh *RoomHub) idleTimer() <-chan time.Time {
+	if rh.idleAfter <= 0 || rh.reap == nil {
+		return nil
+	}
+	return time.After(rh.idleAfter)
+}
+
+// halt 在回收空房间时停止 RoomHub，并把停止前已进入队列的事件转发出去。
+func (rh *RoomHub) halt() {
+	rh.pubMu.Lock()
+	rh.Stop()
+	rh.pubMu.Unlock()
+	for {
+		select {
 		case msg := <-rh.broadcast:
-			for c := range rh.clients {
-				select {
-				case c.send <- msg:
-				default:
-					close(c.send)
-					delete(rh.clients, c)
-					metrics.WsConnections.Dec()
-				}
+			if !msg.remote {
+				rh.emit(msg)
 			}
+		default:
+			return
 		}
 	}
 }
 
-// Stop 停止 RoomHub 的 run goroutine。
-func (rh *RoomHub) Stop() {
+// emit 把本地产生的事件投递给本地客户端，并转发到其它实例。
+func (rh *RoomHub) emit(f frame) {
+	rh.fanout(f)
+	if rh.relay != nil && f.data != nil {
+		rh.relay(f)
+	}
+}
+
+// fanout 把一帧投递给房间内所有客户端；发送队列已满时按客户端的慢消费者策略处理，
+// 策略要求断开的客户端以 CloseSlowConsumer 关闭。
+// 客户端可能同时订阅多个房间，因此这里只关闭连接，发送队列由连接自身持有。
+func (rh *RoomHub) fanout(f frame) {
+	if f.data == nil {
+		return
+	}
+	if f.enc == nil {
+		// 所有接收方共享同一份编码缓存，每种编码每次广播只转换一次。
+		f.enc = &encoded{}
+	}
+	var dropped []*Client
+	for c := range rh.clients {
+		if !c.send.push(f) {
+			metrics.WsSlowConsumerDisconnects.Inc()
+			log.Warn().Uint("room_id", rh.roomID).Uint("user_id", c.userID).Int("queue_depth", c.send.len()).Msg("ws slow consumer disconnected")
+			c.closeWith(CloseSlowConsumer, "slow consumer")
+			dropped = append(dropped, c)
+		}
+	}
+	for _, c := range dropped {
+		rh.remove(c)
+	}
+}
+
+// remove 把客户端移出房间，用户的最后一个连接离开时广播 leave。
+func (rh *RoomHub) remove(c *Client) {
+	if !rh.clients[c] {
+		return
+	}
+	delete(rh.clients, c)
+	rh.unadmit()
+	if rh.removeUser(c) {
+		rh.tracker.Leave(rh.roomID, c.userID)
+		rh.emit(rh.presenceEvent(TypeLeave, c))
+	}
+}
+
+// addUser 记录一个新连接，返回该用户是否刚刚上线。
+func (rh *RoomHub) addUser(c *Client) bool {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	m := rh.users[c.userID]
+	if m == nil {
+		m = &member{name: c.uname}
+		rh.users[c.userID] = m
+	}
+	m.conns++
+	atomic.StoreInt32(&rh.online, int32(len(rh.users)))
+	return m.conns == 1
+}
+
+// removeUser 移除一个连接，返回该用户是否已没有其它连接。
+func (rh *RoomHub) removeUser(c *Client) bool {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	m := rh.users[c.userID]
+	if m == nil {
+		return false
+	}
+	m.conns--
+	if m.conns > 0 {
+		return false
+	}
+	delete(rh.users, c.userID)
+	atomic.StoreInt32(&rh.online, int32(len(rh.users)))
+	return true
+}
+
+// members 返回本实例上房间的在线成员，按用户名排序。
+func (rh *RoomHub) members() []presence.Member {
+	rh.mu.Lock()
+	out := make([]presence.Member, 0, len(rh.users))
+	for id, m := range rh.users {
+		out = append(out, presence.Member{UserID: id, Username: m.name, Status: presence.StatusOnline})
+	}
+	rh.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Username < out[j].Username })
+	return out
+}
+
+// presenceEvent 构造 join / leave 事件帧。
+func (rh *RoomHub) presenceEvent(typ string, c *Client) frame {
+	evt := MemberEvent{Type: typ, RoomID: rh.roomID, UserID: c.userID, Username: c.uname, Online: int(atomic.LoadInt32(&rh.online))}
+	return frame{data: marshalEvent(evt), roomID: rh.roomID}
+}
+
+// roomBacklog 是每个房间待分发事件的缓冲长度。
+const roomBacklog = 1024
+
+// publish 向房间广播一帧，从不阻塞调用方：房间已停止或积压已满时直接丢弃，
+// 避免一个繁忙的房间拖住订阅了它的所有连接的 readPump。
+// 被丢弃的聊天消息已经落库，客户端可以通过 seq 缺口重新拉取。
+// 房间已停止时返回 false，调用方可改由 Hub.publish 转发。
+func (rh *RoomHub) publish(f frame) bool {
+	rh.pubMu.RLock()
+	defer rh.pubMu.RUnlock()
 	select {
 	case <-rh.stop:
-		// 已停止
+		return false
+	default:
+	}
+	select {
+	case rh.broadcast <- f:
 	default:
-		close(rh.stop)
+		metrics.WsDroppedFrames.WithLabelValues("room_backlog").Inc()
+		log.Warn().Uint("room_id", rh.roomID).Uint64("seq", f.seq).Msg("ws room backlog full, dropping event")
 	}
+	return true
+}
+
+// Stop 停止 RoomHub 的 run goroutine。
+func (rh *RoomHub) Stop() {
+	rh.stopOnce.Do(func() { close(rh.stop) })
 }
 
-// Online 返回房间在线客户端数量，供 REST 接口复用。
+// Online 返回房间在本实例上的在线用户数（按用户去重）。
 func (rh *RoomHub) Online() int { return int(atomic.LoadInt32(&rh.online)) }
@@ -4,6 +4,8 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"chatroom/internal/broker"
 )
 
 func startTestRoomHub(t *testing.T, roomID uint) *RoomHub {
@@ -45,10 +47,10 @@ func TestRoomHub_Register(t *testing.T) {
 
 	// Create a fake client
 	client := &Client{
-		room:   rh,
+		done:   make(chan struct{}),
 		userID: 1,
 		uname:  "testuser",
-		send:   make(chan []byte, 256),
+		send:   newSendQueue(256, PolicyDisconnect),
 	}
 
 	// Register client
@@ -66,10 +68,10 @@ func TestRoomHub_Unregister(t *testing.T) {
 	rh := startTestRoomHub(t, 1)
 
 	client := &Client{
-		room:   rh,
+		done:   make(chan struct{}),
 		userID: 1,
 		uname:  "testuser",
-		send:   make(chan []byte, 256),
+		send:   newSendQueue(256, PolicyDisconnect),
 	}
 
 	// Register then unregister
@@ -91,10 +93,10 @@ func TestRoomHub_Broadcast(t *testing.T) {
 	clients := make([]*Client, 3)
 	for i := 0; i < 3; i++ {
 		clients[i] = &Client{
-			room:   rh,
+			done:   make(chan struct{}),
 			userID: uint(i + 1),
 			uname:  "user" + string(rune('0'+i)),
-			send:   make(chan []byte, 256),
+			send:   newSendQueue(256, PolicyDisconnect),
 		}
 	}
 
@@ -106,7 +108,7 @@ func TestRoomHub_Broadcast(t *testing.T) {
 
 	// Broadcast a message
 	testMsg := []byte(`{"type":"message","content":"hello"}`)
-	rh.broadcast <- testMsg
+	rh.broadcast <- frame{data: testMsg}
 
 	// Check all clients received the message
 	var wg sync.WaitGroup
@@ -116,15 +118,13 @@ func TestRoomHub_Broadcast(t *testing.T) {
 		wg.Add(1)
 		go func(idx int, client *Client) {
 			defer wg.Done()
-			deadline := time.After(100 * time.Millisecond)
 			for {
-				select {
-				case msg := <-client.send:
-					if string(msg) == string(testMsg) {
-						received[idx] = true
-						return
-					}
-				case <-deadline:
+				msg, ok := recvFrame(client.send, 100*time.Millisecond)
+				if !ok {
+					return
+				}
+				if string(msg.data) == string(testMsg) {
+					received[idx] = true
 					return
 				}
 			}
@@ -149,16 +149,16 @@ func TestRoomHub_MultipleRooms(t *testing.T) {
 	rh2 := hub.GetRoom(2)
 
 	client1 := &Client{
-		room:   rh1,
+		done:   make(chan struct{}),
 		userID: 1,
 		uname:  "user1",
-		send:   make(chan []byte, 256),
+		send:   newSendQueue(256, PolicyDisconnect),
 	}
 	client2 := &Client{
-		room:   rh2,
+		done:   make(chan struct{}),
 		userID: 2,
 		uname:  "user2",
-		send:   make(chan []byte, 256),
+		send:   newSendQueue(256, PolicyDisconnect),
 	}
 
 	rh1.register <- client1
@@ -175,32 +175,27 @@ func TestRoomHub_MultipleRooms(t *testing.T) {
 }
 
 func TestClient_Send(t *testing.T) {
-	rh := startTestRoomHub(t, 1)
 	client := &Client{
-		room:   rh,
+		done:   make(chan struct{}),
 		userID: 1,
 		uname:  "testuser",
-		send:   make(chan []byte, 256),
+		send:   newSendQueue(256, PolicyDisconnect),
 	}
 
 	testMsg := []byte("test message")
 
 	// Send should not block
-	select {
-	case client.send <- testMsg:
-		// OK
-	default:
-		t.Error("Send channel blocked unexpectedly")
+	if !client.send.push(frame{data: testMsg}) {
+		t.Error("Send queue rejected frame unexpectedly")
 	}
 
 	// Verify message received
-	select {
-	case msg := <-client.send:
-		if string(msg) != string(testMsg) {
-			t.Errorf("Received message = %s, want %s", msg, testMsg)
-		}
-	default:
-		t.Error("No message in send channel")
+	msg, ok := recvFrame(client.send, 0)
+	if !ok {
+		t.Fatal("No message in send queue")
+	}
+	if string(msg.data) != string(testMsg) {
+		t.Errorf("Received message = %s, want %s", msg.data, testMsg)
 	}
 }
 
@@ -216,10 +211,10 @@ func TestRoomHub_Concurrent(t *testing.T) {
 		go func(id int) {
 			defer wg.Done()
 			client := &Client{
-				room:   rh,
+				done:   make(chan struct{}),
 				userID: uint(id),
 				uname:  "user",
-				send:   make(chan []byte, 256),
+				send:   newSendQueue(256, PolicyDisconnect),
 			}
 			rh.register <- client
 		}(i)
@@ -232,3 +227,131 @@ func TestRoomHub_Concurrent(t *testing.T) {
 		t.Errorf("Online() after concurrent register = %d, want %d", rh.Online(), numClients)
 	}
 }
+
+func TestRoomHub_ClientInManyRooms(t *testing.T) {
+	rh1 := startTestRoomHub(t, 1)
+	rh2 := startTestRoomHub(t, 2)
+	client := &Client{
+		userID: 1,
+		uname:  "user1",
+		send:   newSendQueue(256, PolicyDisconnect),
+		done:   make(chan struct{}),
+	}
+
+	rh1.register <- client
+	rh2.register <- client
+	rh1.unregister <- client
+	time.Sleep(10 * time.Millisecond)
+
+	// 离开一个房间不应影响另一个房间的投递。
+	rh2.broadcast <- frame{data: []byte("hi"), roomID: 2}
+	for {
+		f, ok := recvFrame(client.send, 100*time.Millisecond)
+		if !ok {
+			t.Fatal("client did not receive broadcast from remaining room")
+		}
+		select {
+		case <-client.done:
+			t.Fatal("client closed after leaving one room")
+		default:
+		}
+		if string(f.data) == "hi" {
+			if rh1.Online() != 0 || rh2.Online() != 1 {
+				t.Errorf("Online() = %d/%d, want 0/1", rh1.Online(), rh2.Online())
+			}
+			return
+		}
+	}
+}
+
+func TestHub_RelaysThroughBroker(t *testing.T) {
+	bus := broker.NewMemory()
+	hubA := NewHubWithOptions(HubOptions{Broker: bus})
+	hubB := NewHubWithOptions(HubOptions{Broker: bus})
+	t.Cleanup(hubA.Shutdown)
+	t.Cleanup(hubB.Shutdown)
+
+	client := &Client{
+		userID: 1,
+		uname:  "user1",
+		send:   newSendQueue(256, PolicyDisconnect),
+		done:   make(chan struct{}),
+	}
+	hubB.GetRoom(1).register <- client
+	hubA.GetRoom(1).publish(frame{data: []byte(`{"type":"message"}`), roomID: 1, seq: 7})
+
+	for {
+		f, ok := recvFrame(client.send, time.Second)
+		if !ok {
+			t.Fatal("client on another hub did not receive the event")
+		}
+		if string(f.data) != `{"type":"message"}` {
+			continue
+		}
+		if !f.remote || f.seq != 7 {
+			t.Errorf("relayed frame = %+v, want remote frame with seq 7", f)
+		}
+		return
+	}
+}
+
+func TestHub_ReapsIdleRoom(t *testing.T) {
+	hub := NewHubWithOptions(HubOptions{RoomIdle: 30 * time.Millisecond})
+	t.Cleanup(hub.Shutdown)
+	client := &Client{
+		userID: 1,
+		uname:  "user1",
+		send:   newSendQueue(256, PolicyDisconnect),
+		done:   make(chan struct{}),
+	}
+	rh := hub.GetRoom(1)
+	rh.register <- client
+
+	// 仍有连接的房间不会被回收。
+	time.Sleep(100 * time.Millisecond)
+	if hub.GetRoom(1) != rh {
+		t.Fatal("room with clients was reaped")
+	}
+
+	rh.unregister <- client
+	select {
+	case <-rh.stop:
+	case <-time.After(time.Second):
+		t.Fatal("idle room was not reaped")
+	}
+	hub.mu.RLock()
+	_, ok := hub.rooms[1]
+	hub.mu.RUnlock()
+	if ok {
+		t.Error("reaped room is still registered")
+	}
+}
+
+func TestClient_JoinRoomRetriesReapedRoom(t *testing.T) {
+	hub := NewHub()
+	t.Cleanup(hub.Shutdown)
+	old := hub.GetRoom(1)
+	// 模拟回收与 join 并发：调用方拿到旧实例后它才停止。
+	if !hub.removeRoom(old) {
+		t.Fatal("removeRoom() = false")
+	}
+	old.halt()
+
+	client := &Client{
+		hub:    hub,
+		userID: 1,
+		uname:  "user1",
+		send:   newSendQueue(256, PolicyDisconnect),
+		done:   make(chan struct{}),
+		rooms:  make(map[uint]*RoomHub),
+	}
+	if err := client.join(old); err != errRoomStopped {
+		t.Fatalf("join(stopped) = %v, want errRoomStopped", err)
+	}
+	if err := client.joinRoom(1); err != nil {
+		t.Fatalf("joinRoom() = %v", err)
+	}
+	if rh := client.rooms[1]; rh == nil || rh == old {
+		t.Error("joinRoom() did not register with a fresh room hub")
+	}
+}
@@ -0,0 +1,138 @@
+package ws
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"chatroom/internal/metrics"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// ConnLimits 是本实例上实时连接（WebSocket 与 SSE）的数量上限，零值表示不限制。
+type ConnLimits struct {
+	PerUser int
+	PerIP   int
+	PerRoom int
+	Total   int
+}
+
+// 连接被拒绝的原因，同时作为指标标签。
+const (
+	limitUser   = "user"
+	limitIP     = "ip"
+	limitRoom   = "room"
+	limitGlobal = "global"
+)
+
+// errRoomFull 表示房间在本实例上的连接数已达上限。
+var errRoomFull = errors.New("room is full")
+
+// connCounter 统计本实例上按用户、按 IP 与全部的连接数。
+type connCounter struct {
+	limits ConnLimits
+	mu     sync.Mutex
+	total  int
+	users  map[uint]int
+	ips    map[string]int
+}
+
+func newConnCounter(limits ConnLimits) *connCounter {
+	return &connCounter{limits: limits, users: make(map[uint]int), ips: make(map[string]int)}
+}
+
+// admit 为新连接占用名额，超限时返回被拒绝的原因；userID 为零表示用户尚未鉴权，稍后通过 admitUser 计入。
+func (cc *connCounter) admit(userID uint, ip string) string {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	switch {
+	case cc.limits.Total > 0 && cc.total >= cc.limits.Total:
+		return limitGlobal
+	case cc.limits.PerIP > 0 && cc.ips[ip] >= cc.limits.PerIP:
+		return limitIP
+	case userID != 0 && cc.limits.PerUser > 0 && cc.users[userID] >= cc.limits.PerUser:
+		return limitUser
+	}
+	cc.total++
+	cc.ips[ip]++
+	if userID != 0 {
+		cc.users[userID]++
+	}
+	return ""
+}
+
+// admitUser 在首帧鉴权完成后为用户占用名额。
+func (cc *connCounter) admitUser(userID uint) string {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.limits.PerUser > 0 && cc.users[userID] >= cc.limits.PerUser {
+		return limitUser
+	}
+	cc.users[userID]++
+	return ""
+}
+
+// release 归还 admit 占用的名额。
+func (cc *connCounter) release(userID uint, ip string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.total--
+	if cc.ips[ip]--; cc.ips[ip] <= 0 {
+		delete(cc.ips, ip)
+	}
+	if userID == 0 {
+		return
+	}
+	if cc.users[userID]--; cc.users[userID] <= 0 {
+		delete(cc.users, userID)
+	}
+}
+
+// admit 在注册前为连接占用房间名额，房间已满时返回 false。
+func (rh *RoomHub) admit() bool {
+	if rh.maxConns <= 0 {
+		return true
+	}
+	for {
+		n := rh.conns.Load()
+		if int(n) >= rh.maxConns {
+			return false
+		}
+		if rh.conns.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// unadmit 归还 admit 占用的房间名额。
+func (rh *RoomHub) unadmit() {
+	if rh.maxConns > 0 {
+		rh.conns.Add(-1)
+	}
+}
+
+// full 表示房间在本实例上的连接数已达上限，用于握手前提前拒绝。
+func (rh *RoomHub) full() bool {
+	return rh.maxConns > 0 && int(rh.conns.Load()) >= rh.maxConns
+}
+
+// roomFull 表示房间在本实例上的连接数已达上限，房间尚未创建时返回 false。
+func (h *Hub) roomFull(roomID uint) bool {
+	h.mu.RLock()
+	rh := h.rooms[roomID]
+	h.mu.RUnlock()
+	return rh != nil && rh.full()
+}
+
+// rejectConn 以 429（用户、IP 超限）或 503（房间、全局超限）拒绝握手。
+func rejectConn(c *gin.Context, reason string) {
+	metrics.WsConnectionsRejected.WithLabelValues(reason).Inc()
+	log.Warn().Str("reason", reason).Str("remote", c.ClientIP()).Msg("ws connection rejected")
+	status := http.StatusServiceUnavailable
+	if reason == limitUser || reason == limitIP {
+		status = http.StatusTooManyRequests
+	}
+	c.JSON(status, gin.H{"error": "too many connections", "limit": reason})
+}
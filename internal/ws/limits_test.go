@@ -0,0 +1,126 @@
+package ws
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"chatroom/internal/service"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestConnCounter_Limits(t *testing.T) {
+	cc := newConnCounter(ConnLimits{PerUser: 2, PerIP: 3, Total: 4})
+
+	steps := []struct {
+		user uint
+		ip   string
+		want string
+	}{
+		{1, "a", ""},
+		{1, "a", ""},
+		{1, "b", limitUser},
+		{2, "a", ""},
+		{3, "a", limitIP},
+		{3, "b", ""},
+		{4, "c", limitGlobal},
+	}
+	for i, st := range steps {
+		if got := cc.admit(st.user, st.ip); got != st.want {
+			t.Fatalf("step %d: admit(%d, %s) = %q, want %q", i, st.user, st.ip, got, st.want)
+		}
+	}
+
+	cc.release(1, "a")
+	if got := cc.admit(1, "c"); got != "" {
+		t.Errorf("admit() after release = %q, want admitted", got)
+	}
+	// 首帧鉴权的连接先按 IP 计入，鉴权后再计入用户。
+	cc.release(3, "b")
+	if got := cc.admit(0, "b"); got != "" {
+		t.Fatalf("admit(0) = %q, want admitted", got)
+	}
+	if got := cc.admitUser(1); got != limitUser {
+		t.Errorf("admitUser(1) = %q, want %q", got, limitUser)
+	}
+}
+
+func TestRoomHub_Admit(t *testing.T) {
+	rh := NewRoomHub(1)
+	rh.maxConns = 1
+	if !rh.admit() {
+		t.Fatal("admit() = false for empty room")
+	}
+	if rh.admit() || !rh.full() {
+		t.Fatal("admit() = true for full room")
+	}
+	rh.unadmit()
+	if rh.full() {
+		t.Error("full() = true after unadmit")
+	}
+}
+
+func TestServe_ConnectionLimits(t *testing.T) {
+	env := newTestEnv(t)
+	env.hub.conns = newConnCounter(ConnLimits{PerUser: 1})
+	env.hub.roomLimit = 1
+
+	env.dial(fmt.Sprintf("room_id=%d&token=%s", env.roomID, env.token))
+	base := "ws" + strings.TrimPrefix(env.srv.URL, "http") + "/ws?"
+	_, resp, err := websocket.DefaultDialer.Dial(base+"token="+env.token, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("second connection: err = %v, resp = %v, want 429", err, resp)
+	}
+
+	// 第一个连接注册到房间后，其他用户也无法再加入该房间。
+	deadline := time.Now().Add(2 * time.Second)
+	for env.hub.Online(env.roomID) != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, bobToken := env.createUser("bob")
+	_, resp, err = websocket.DefaultDialer.Dial(base+fmt.Sprintf("room_id=%d&token=%s", env.roomID, bobToken), nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("room over limit: err = %v, resp = %v, want 503", err, resp)
+	}
+
+	conn := env.dial("protocol=2&token=" + bobToken)
+	if err := conn.WriteJSON(map[string]interface{}{"type": "subscribe", "room_id": env.roomID}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if evt := readUntil(t, conn, "error"); evt["code"] != string(ErrCodeRoomFull) {
+		t.Errorf("error = %v, want room_full", evt)
+	}
+}
+
+func TestServe_FirstFrameTicketRoomLimit(t *testing.T) {
+	env := newTestEnv(t)
+	env.hub.roomLimit = 1
+	env.dial(fmt.Sprintf("room_id=%d&token=%s", env.roomID, env.token))
+	deadline := time.Now().Add(2 * time.Second)
+	for env.hub.Online(env.roomID) != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 握手不带 room_id，房间由首帧中的票据绑定，同样受房间名额限制。
+	bobID, _ := env.createUser("bob")
+	dto, err := service.NewTicketService(env.db).Issue(bobID, env.roomID, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	conn := env.dial("protocol=2")
+	if err := conn.WriteJSON(map[string]string{"type": "auth", "ticket": dto.Ticket}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, CloseTryAgainLater) {
+				t.Errorf("read error = %v, want close %d", err, CloseTryAgainLater)
+			}
+			return
+		}
+	}
+}
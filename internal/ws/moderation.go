@@ -0,0 +1,143 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"chatroom/internal/broker"
+	"chatroom/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// Kick 把用户踢出房间，roomID 为零时断开该用户的所有连接；其它实例经由 broker 收到同样的指令。
+func (h *Hub) Kick(roomID, userID uint, reason string) {
+	evt := marshalEvent(KickedEvent{Type: TypeKicked, RoomID: roomID, Reason: reason})
+	h.kickLocal(roomID, userID, evt)
+	select {
+	case h.outbox <- broker.Envelope{Origin: h.instanceID, RoomID: roomID, Kick: userID, Data: evt}:
+	default:
+		log.Warn().Uint("room_id", roomID).Uint("user_id", userID).Msg("ws broker outbox full, dropping kick")
+	}
+}
+
+// MessageUpdated 向房间推送 message_updated 事件，用于消息被隐藏或恢复；隐藏时内容为空且 hidden 为 true。
+func (h *Hub) MessageUpdated(dto service.MessageDTO) {
+	dto.Type = TypeMessageUpdated
+	b, err := json.Marshal(dto)
+	if err != nil {
+		return
+	}
+	h.GetRoom(dto.RoomID).publish(frame{data: b, roomID: dto.RoomID})
+}
+
+// kickLocal 处理本实例上该用户的所有连接。
+func (h *Hub) kickLocal(roomID, userID uint, evt []byte) {
+	h.lmu.Lock()
+	var targets []*Client
+	for c := range h.live {
+		if c.userID == userID {
+			targets = append(targets, c)
+		}
+	}
+	h.lmu.Unlock()
+	for _, c := range targets {
+		c.kick(roomID, evt)
+	}
+}
+
+// kick 把连接移出房间并推送 kicked 事件。v1 与 SSE 连接只对应一个房间，与 roomID 为零时一样直接断开。
+func (c *Client) kick(roomID uint, evt []byte) {
+	if roomID != 0 {
+		c.mu.Lock()
+		_, joined := c.rooms[roomID]
+		c.mu.Unlock()
+		if !joined {
+			return
+		}
+	}
+	if roomID == 0 || c.protocol < ProtocolV2 || c.conn == nil {
+		c.trySend(evt)
+		c.closeWith(CloseKicked, "kicked")
+		return
+	}
+	c.leave(roomID)
+	c.trySend(evt)
+}
+
+// checkBan 在握手前拒绝被封禁的用户，已返回响应时返回 false。
+func checkBan(c *gin.Context, mod *service.ModerationService, userID, roomID uint) bool {
+	err := mod.Check(userID, roomID, false)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, service.ErrUserBanned):
+		c.JSON(http.StatusForbidden, gin.H{"error": "banned"})
+	default:
+		log.Error().Err(err).Uint("user_id", userID).Uint("room_id", roomID).Msg("ws check ban")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to authenticate"})
+	}
+	return false
+}
+
+// sanctionError 把处罚检查的结果映射为错误码，未受处罚时返回空字符串；mute 为 true 时同时检查禁言。
+func (c *Client) sanctionError(roomID uint, mute bool) ErrorCode {
+	if c.mod == nil {
+		return ""
+	}
+	err := c.mod.Check(c.userID, roomID, mute)
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, service.ErrUserBanned):
+		return ErrCodeBanned
+	case errors.Is(err, service.ErrUserMuted):
+		return ErrCodeMuted
+	default:
+		log.Error().Err(err).Uint("user_id", c.userID).Uint("room_id", roomID).Msg("ws check sanction")
+		if mute {
+			return ErrCodeMessageFailed
+		}
+		return ErrCodeRoomUnavailable
+	}
+}
+
+// postPolicyError 检查房间的公告模式与慢速模式。放行时返回 release，消息最终没有写入时调用以归还慢速模式的名额；
+// 否则返回错误码与慢速模式下还需等待的时长。
+func (c *Client) postPolicyError(roomID uint) (release func(), code ErrorCode, wait time.Duration) {
+	moderator := c.mod != nil && c.mod.IsModerator(c.userID)
+	release, wait, err := c.msgSvc.Policy().Check(c.userID, roomID, moderator)
+	switch {
+	case err == nil:
+		return release, "", 0
+	case errors.Is(err, service.ErrAnnouncementOnly):
+		return nil, ErrCodeAnnouncementOnly, 0
+	case errors.Is(err, service.ErrSlowMode):
+		return nil, ErrCodeSlowMode, wait
+	case errors.Is(err, service.ErrRoomNotFound):
+		return nil, ErrCodeRoomNotFound, 0
+	default:
+		log.Error().Err(err).Uint("user_id", c.userID).Uint("room_id", roomID).Msg("ws check post policy")
+		return nil, ErrCodeMessageFailed, 0
+	}
+}
+
+// replayed 在发言受限时确认帧是否为已写入消息的重发，是则照常回复 ack 并返回 true。
+func (c *Client) replayed(rh *RoomHub, clientMsgID string) bool {
+	msg, err := c.msgSvc.FindByClientMsgID(c.userID, rh.roomID, clientMsgID)
+	if errors.Is(err, service.ErrClientMsgIDConflict) {
+		return false
+	}
+	if err != nil {
+		log.Error().Err(err).Uint("user_id", c.userID).Uint("room_id", rh.roomID).Msg("ws find replayed message")
+		return false
+	}
+	if msg == nil {
+		return false
+	}
+	c.finishMessage(rh.roomID, service.CreateResult{Message: msg, Duplicate: true})
+	return true
+}
@@ -0,0 +1,218 @@
+package ws
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chatroom/internal/filter"
+	"chatroom/internal/models"
+	"chatroom/internal/service"
+	"chatroom/internal/unfurl"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestServe_KickFromRoom(t *testing.T) {
+	env := newTestEnv(t)
+	conn := env.dial(fmt.Sprintf("room_id=%d&token=%s&protocol=2", env.roomID, env.token))
+	readUntil(t, conn, "join")
+
+	env.hub.Kick(env.roomID, env.userID, "spam")
+	if evt := readUntil(t, conn, TypeKicked); evt["reason"] != "spam" || evt["room_id"] != float64(env.roomID) {
+		t.Errorf("kicked = %v, want room kick with reason", evt)
+	}
+	// v2 连接被移出房间后仍然可用。
+	if err := conn.WriteJSON(map[string]interface{}{"type": "message", "room_id": env.roomID, "content": "hi"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if evt := readUntil(t, conn, "error"); evt["code"] != string(ErrCodeNotSubscribed) {
+		t.Errorf("error = %v, want not_subscribed", evt)
+	}
+
+	// roomID 为零时断开该用户的所有连接。
+	env.hub.Kick(0, env.userID, "")
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, CloseKicked) {
+				t.Errorf("read error = %v, want close %d", err, CloseKicked)
+			}
+			return
+		}
+	}
+}
+
+func TestServe_SanctionsEnforced(t *testing.T) {
+	env := newTestEnv(t)
+	bobID, bobToken := env.createUser("bob")
+	for _, s := range []models.Sanction{
+		{Kind: service.SanctionBan, UserID: bobID, RoomID: env.roomID, CreatedBy: env.userID},
+		{Kind: service.SanctionMute, UserID: env.userID, CreatedBy: bobID},
+	} {
+		if err := env.db.Create(&s).Error; err != nil {
+			t.Fatalf("create sanction: %v", err)
+		}
+	}
+
+	url := "ws" + strings.TrimPrefix(env.srv.URL, "http") + fmt.Sprintf("/ws?room_id=%d&token=%s", env.roomID, bobToken)
+	if _, resp, err := websocket.DefaultDialer.Dial(url, nil); err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("banned dial: err = %v, resp = %v, want 403", err, resp)
+	}
+	conn := env.dial("protocol=2&token=" + bobToken)
+	if err := conn.WriteJSON(map[string]interface{}{"type": "subscribe", "room_id": env.roomID}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if evt := readUntil(t, conn, "error"); evt["code"] != string(ErrCodeBanned) {
+		t.Errorf("subscribe error = %v, want banned", evt)
+	}
+
+	muted := env.dial(fmt.Sprintf("room_id=%d&token=%s&protocol=2", env.roomID, env.token))
+	if err := muted.WriteJSON(map[string]string{"type": "message", "content": "hi"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if evt := readUntil(t, muted, "error"); evt["code"] != string(ErrCodeMuted) {
+		t.Errorf("message error = %v, want muted", evt)
+	}
+}
+
+func TestServe_MuteAppliesImmediately(t *testing.T) {
+	env := newTestEnv(t)
+	bobID, bobToken := env.createUser("bob")
+	conn := env.dial(fmt.Sprintf("room_id=%d&token=%s&protocol=2", env.roomID, bobToken))
+	if err := conn.WriteJSON(map[string]string{"type": "message", "content": "hi"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	readUntil(t, conn, "message")
+
+	// 处罚经由共用的 ModerationService 下达，已缓存的检查结果立即失效。
+	if _, err := env.mod.Apply(env.userID, service.SanctionInput{Kind: service.SanctionMute, UserID: bobID, RoomID: env.roomID}); err != nil {
+		t.Fatalf("apply mute: %v", err)
+	}
+	if err := conn.WriteJSON(map[string]string{"type": "message", "content": "again"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if evt := readUntil(t, conn, "error"); evt["code"] != string(ErrCodeMuted) {
+		t.Errorf("message error = %v, want muted", evt)
+	}
+}
+
+func TestServe_MessageFilterRejects(t *testing.T) {
+	env := newTestEnv(t)
+	cfg := filter.Config{Repeat: &filter.RepeatConfig{Max: 3, Action: filter.Rewrite}, Blocklist: &filter.BlocklistConfig{Words: []string{"spam"}, Action: filter.Reject}}
+	if err := env.msgSvc.Filters().Set(env.userID, env.roomID, cfg); err != nil {
+		t.Fatalf("set filters: %v", err)
+	}
+	conn := env.dial(fmt.Sprintf("room_id=%d&token=%s&protocol=2", env.roomID, env.token))
+	if err := conn.WriteJSON(map[string]string{"type": "message", "content": "sp4m"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if evt := readUntil(t, conn, "error"); evt["code"] != string(ErrCodeMessageRejected) {
+		t.Errorf("error = %v, want message_rejected", evt)
+	}
+	if err := conn.WriteJSON(map[string]string{"type": "message", "content": "hiiiiii"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if evt := readUntil(t, conn, "message"); evt["content"] != "hiii" {
+		t.Errorf("message = %v, want rewritten content", evt)
+	}
+}
+
+func TestHub_MessageUpdatedHidesMessage(t *testing.T) {
+	env := newTestEnv(t)
+	conn := env.dial(fmt.Sprintf("room_id=%d&token=%s&protocol=2", env.roomID, env.token))
+	readUntil(t, conn, "join")
+
+	env.hub.MessageUpdated(service.MessageDTO{ID: 7, RoomID: env.roomID, Seq: 3, UserID: env.userID, Hidden: true})
+	evt := readUntil(t, conn, "message_updated")
+	if evt["id"] != float64(7) || evt["hidden"] != true || evt["content"] != "" {
+		t.Errorf("message_updated = %v, want hidden placeholder", evt)
+	}
+}
+
+func TestAttachPreviews_SkipsHiddenMessage(t *testing.T) {
+	env := newTestEnv(t)
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><head><title>Page</title></head></html>`)
+	}))
+	defer page.Close()
+	u := unfurl.New(unfurl.Options{AllowPrivate: true})
+
+	for _, hidden := range []bool{false, true} {
+		msg, _, err := env.msgSvc.Create(service.CreateMessageInput{RoomID: env.roomID, UserID: env.userID, Content: "see " + page.URL})
+		if err != nil {
+			t.Fatalf("create message: %v", err)
+		}
+		env.db.Model(&models.Message{}).Where("id = ?", msg.ID).Update("hidden", hidden)
+		h := NewHub()
+		rh := NewRoomHub(env.roomID)
+		h.rooms[env.roomID] = rh
+		attachPreviews(u, env.db, h, OutboundMessage{Type: TypeMessage, ID: msg.ID, RoomID: env.roomID, Content: msg.Content})
+		if got := len(rh.broadcast); got != map[bool]int{false: 1, true: 0}[hidden] {
+			t.Errorf("hidden=%v: published %d updates", hidden, got)
+		}
+		var n int64
+		env.db.Model(&models.LinkPreview{}).Where("message_id = ?", msg.ID).Count(&n)
+		if n != 1 {
+			t.Errorf("hidden=%v: stored %d previews, want 1", hidden, n)
+		}
+		h.Shutdown()
+	}
+}
+
+func TestServe_SlowModeAndAnnouncement(t *testing.T) {
+	env := newTestEnv(t)
+	policy := env.msgSvc.Policy()
+	if err := policy.Update(env.roomID, service.RoomSettings{SlowModeSeconds: 30}); err != nil {
+		t.Fatalf("update settings: %v", err)
+	}
+	_, bobToken := env.createUser("bob")
+	conn := env.dial(fmt.Sprintf("room_id=%d&token=%s&protocol=2", env.roomID, bobToken))
+	// 被拒绝的消息不占用慢速模式的名额。
+	if err := conn.WriteJSON(map[string]string{"type": "message", "content": strings.Repeat("x", service.MaxContentLength+1)}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if evt := readUntil(t, conn, "error"); evt["code"] != string(ErrCodeMessageTooLong) {
+		t.Fatalf("error = %v, want message_too_long", evt)
+	}
+	if err := conn.WriteJSON(map[string]string{"type": "message", "content": "first", "client_msg_id": "c1"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	readUntil(t, conn, "ack")
+	if err := conn.WriteJSON(map[string]string{"type": "message", "content": "second"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	evt := readUntil(t, conn, "error")
+	if evt["code"] != string(ErrCodeSlowMode) || evt["retry_after"] != float64(30) || evt["room_id"] != float64(env.roomID) {
+		t.Errorf("error = %v, want slow_mode with retry_after 30", evt)
+	}
+	// 慢速模式下重发已写入的消息仍然收到 ack。
+	if err := conn.WriteJSON(map[string]string{"type": "message", "content": "first", "client_msg_id": "c1"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if evt := readUntil(t, conn, "ack"); evt["duplicate"] != true || evt["client_msg_id"] != "c1" {
+		t.Errorf("ack = %v, want duplicate ack for c1", evt)
+	}
+
+	// 房主不受慢速模式限制，公告模式下普通成员不能发言。
+	owner := env.dial(fmt.Sprintf("room_id=%d&token=%s&protocol=2", env.roomID, env.token))
+	for _, content := range []string{"a", "b"} {
+		if err := owner.WriteJSON(map[string]string{"type": "message", "content": content, "client_msg_id": content}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		readUntil(t, owner, "ack")
+	}
+	if err := policy.Update(env.roomID, service.RoomSettings{Announcement: true}); err != nil {
+		t.Fatalf("update settings: %v", err)
+	}
+	if err := conn.WriteJSON(map[string]string{"type": "message", "content": "third"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if evt := readUntil(t, conn, "error"); evt["code"] != string(ErrCodeAnnouncementOnly) {
+		t.Errorf("error = %v, want announcement_only", evt)
+	}
+}
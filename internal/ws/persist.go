@@ -0,0 +1,201 @@
+package ws
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"chatroom/internal/config"
+	"chatroom/internal/metrics"
+	"chatroom/internal/service"
+
+	"github.com/rs/zerolog/log"
+)
+
+// 异步落库的默认参数。
+const (
+	defaultPersistBatch = 100
+	defaultPersistDelay = 20 * time.Millisecond
+	defaultPersistQueue = 1000
+	persistWriterIdle   = time.Minute
+	persistFlushTimeout = 5 * time.Second
+	// 队列已满或停服时告知发送方多久后重发；停服时与拒绝新连接的 Retry-After 一致。
+	persistBusyRetry  = time.Second
+	persistDrainRetry = 5 * time.Second
+)
+
+var (
+	// errPersistBusy 表示房间的待写队列已满。
+	errPersistBusy = errors.New("persist queue full")
+	// errPersistClosed 表示实例正在停服，不再接受新消息。
+	errPersistClosed = errors.New("persister closed")
+)
+
+// persistDone 在消息落库（或失败）后调用，同一房间按入队顺序依次回调。
+type persistDone func(service.CreateResult)
+
+type pendingWrite struct {
+	in   service.CreateMessageInput
+	done persistDone
+}
+
+// persister 是可选的异步落库管道：每个房间一个写协程，把排队的消息攒批后在一个事务内写入，
+// 单批最多 maxBatch 条，第一条入队后最多等待 maxDelay。同一房间只有一个写协程，seq 与入队顺序一致。
+type persister struct {
+	svc      *service.MessageService
+	maxBatch int
+	maxDelay time.Duration
+	queue    int
+
+	mu      sync.Mutex
+	rooms   map[uint]chan pendingWrite
+	closed  bool
+	closing chan struct{}
+	wg      sync.WaitGroup
+}
+
+// newPersister 按配置创建异步落库管道，未开启时返回 nil，消息改为同步写入。
+func newPersister(svc *service.MessageService, cfg config.Config) *persister {
+	if !cfg.WSAsyncPersist {
+		return nil
+	}
+	p := &persister{
+		svc:      svc,
+		maxBatch: cfg.WSPersistBatchSize,
+		maxDelay: time.Duration(cfg.WSPersistMaxDelayMs) * time.Millisecond,
+		queue:    cfg.WSPersistQueueSize,
+		rooms:    make(map[uint]chan pendingWrite),
+		closing:  make(chan struct{}),
+	}
+	if p.maxBatch <= 0 {
+		p.maxBatch = defaultPersistBatch
+	}
+	if p.maxDelay <= 0 {
+		p.maxDelay = defaultPersistDelay
+	}
+	if p.queue <= 0 {
+		p.queue = defaultPersistQueue
+	}
+	return p
+}
+
+// enqueue 把消息放入房间的待写队列；队列已满或管道已关闭时返回错误，调用方应立即告知发送方。
+func (p *persister) enqueue(in service.CreateMessageInput, done persistDone) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return errPersistClosed
+	}
+	q, ok := p.rooms[in.RoomID]
+	if !ok {
+		q = make(chan pendingWrite, p.queue)
+		p.rooms[in.RoomID] = q
+		p.wg.Add(1)
+		go p.run(in.RoomID, q)
+	}
+	select {
+	case q <- pendingWrite{in: in, done: done}:
+		return nil
+	default:
+		metrics.WsDroppedFrames.WithLabelValues("persist_queue").Inc()
+		return errPersistBusy
+	}
+}
+
+// run 是房间的写协程，空闲一段时间后退出；关闭时写完队列中剩余的消息再退出。
+func (p *persister) run(roomID uint, q chan pendingWrite) {
+	defer p.wg.Done()
+	idle := time.NewTimer(persistWriterIdle)
+	defer idle.Stop()
+	for {
+		select {
+		case w := <-q:
+			p.flush(roomID, p.collect(w, q))
+			idle.Reset(persistWriterIdle)
+		case <-idle.C:
+			// 在锁内确认队列为空再退出，避免与 enqueue 竞争。
+			p.mu.Lock()
+			if len(q) == 0 {
+				delete(p.rooms, roomID)
+				p.mu.Unlock()
+				return
+			}
+			p.mu.Unlock()
+			idle.Reset(persistWriterIdle)
+		case <-p.closing:
+			for len(q) > 0 {
+				p.flush(roomID, p.collect(<-q, q))
+			}
+			return
+		}
+	}
+}
+
+// collect 以 first 开头攒一批消息，达到 maxBatch 或等待超过 maxDelay 时返回。
+func (p *persister) collect(first pendingWrite, q chan pendingWrite) []pendingWrite {
+	batch := []pendingWrite{first}
+	timer := time.NewTimer(p.maxDelay)
+	defer timer.Stop()
+	for len(batch) < p.maxBatch {
+		select {
+		case w := <-q:
+			batch = append(batch, w)
+		case <-timer.C:
+			return batch
+		}
+	}
+	return batch
+}
+
+// flush 在一个事务内写入整批消息，并按顺序回调。
+func (p *persister) flush(roomID uint, batch []pendingWrite) {
+	metrics.WsPersistBatchSize.Observe(float64(len(batch)))
+	ins := make([]service.CreateMessageInput, len(batch))
+	for i, w := range batch {
+		ins[i] = w.in
+	}
+	for i, res := range p.svc.CreateBatch(roomID, ins) {
+		batch[i].done(res)
+	}
+}
+
+// close 停止接受新消息并等待已排队的消息写完，最多等待 timeout，返回是否全部写完。
+func (p *persister) close(timeout time.Duration) bool {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return true
+	}
+	p.closed = true
+	close(p.closing)
+	p.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// setPersister 登记 Serve 创建的异步落库管道，Shutdown 时先写完其中的消息。
+func (h *Hub) setPersister(p *persister) {
+	h.mu.Lock()
+	h.persist = p
+	h.mu.Unlock()
+}
+
+// flushPersister 关闭异步落库管道，等待已排队的消息写完。
+func (h *Hub) flushPersister() {
+	h.mu.RLock()
+	p := h.persist
+	h.mu.RUnlock()
+	if p != nil && !p.close(persistFlushTimeout) {
+		log.Warn().Msg("ws persist flush timed out")
+	}
+}
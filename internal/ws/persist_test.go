@@ -0,0 +1,144 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"chatroom/internal/broker"
+	"chatroom/internal/config"
+	"chatroom/internal/service"
+)
+
+func TestPersister_OrderedBatches(t *testing.T) {
+	env := newTestEnv(t)
+	p := newPersister(env.msgSvc, config.Config{WSAsyncPersist: true, WSPersistBatchSize: 3, WSPersistMaxDelayMs: 50})
+
+	results := make(chan service.CreateResult, 5)
+	for i := 0; i < 5; i++ {
+		in := service.CreateMessageInput{RoomID: env.roomID, UserID: env.userID, Content: fmt.Sprintf("m%d", i)}
+		if err := p.enqueue(in, func(r service.CreateResult) { results <- r }); err != nil {
+			t.Fatalf("enqueue() = %v", err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		select {
+		case r := <-results:
+			if r.Err != nil || r.Message.Seq != uint64(i+1) || r.Message.Content != fmt.Sprintf("m%d", i) {
+				t.Fatalf("result %d = %+v, want m%d with seq %d", i, r, i, i+1)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("result %d not delivered", i)
+		}
+	}
+
+	if !p.close(time.Second) {
+		t.Fatal("close() timed out")
+	}
+	if err := p.enqueue(service.CreateMessageInput{RoomID: env.roomID}, func(service.CreateResult) {}); err != errPersistClosed {
+		t.Errorf("enqueue() after close = %v, want errPersistClosed", err)
+	}
+}
+
+func TestPersister_DatabaseUnavailable(t *testing.T) {
+	env := newTestEnv(t)
+	p := newPersister(env.msgSvc, config.Config{WSAsyncPersist: true})
+	t.Cleanup(func() { p.close(time.Second) })
+	sqlDB, _ := env.db.DB()
+	_ = sqlDB.Close()
+
+	results := make(chan service.CreateResult, 1)
+	in := service.CreateMessageInput{RoomID: env.roomID, UserID: env.userID, Content: "lost"}
+	if err := p.enqueue(in, func(r service.CreateResult) { results <- r }); err != nil {
+		t.Fatalf("enqueue() = %v", err)
+	}
+	select {
+	case r := <-results:
+		if r.Err == nil {
+			t.Errorf("result = %+v, want error while database is down", r)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("failure not reported")
+	}
+}
+
+func TestServe_AsyncPersistAcks(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) { cfg.WSAsyncPersist = true })
+	conn := env.dial(fmt.Sprintf("room_id=%d&token=%s&protocol=2", env.roomID, env.token))
+	readUntil(t, conn, "join")
+
+	if err := conn.WriteJSON(map[string]string{"type": "message", "content": "hello", "client_msg_id": "c-1"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	ack := readUntil(t, conn, "ack")
+	if ack["client_msg_id"] != "c-1" || ack["id"] == float64(0) {
+		t.Errorf("ack = %v, want stored message", ack)
+	}
+	if evt := readUntil(t, conn, "message"); evt["content"] != "hello" || evt["seq"] != float64(1) {
+		t.Errorf("message = %v, want hello with seq 1", evt)
+	}
+}
+
+func TestFinishMessage_PersistErrorsAreRetryable(t *testing.T) {
+	tests := []struct {
+		err   error
+		code  ErrorCode
+		retry int
+	}{
+		{errPersistBusy, ErrCodeServerBusy, 1},
+		{errPersistClosed, ErrCodeServerDraining, 5},
+	}
+	for _, tt := range tests {
+		c := &Client{userID: 1, protocol: ProtocolV2, send: newSendQueue(4, PolicyDisconnect)}
+		c.finishMessage(1, service.CreateResult{Err: tt.err})
+		frames := c.send.drain()
+		if len(frames) != 1 {
+			t.Fatalf("%v: sent %d frames, want 1", tt.err, len(frames))
+		}
+		var evt ErrorEvent
+		if err := json.Unmarshal(frames[0].data, &evt); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if evt.Code != tt.code || evt.RetryAfter != tt.retry || evt.RoomID != 1 {
+			t.Errorf("%v: error event = %+v, want %s with retry_after %d", tt.err, evt, tt.code, tt.retry)
+		}
+	}
+}
+
+func TestFinishMessage_BroadcastsAfterRoomReaped(t *testing.T) {
+	env := newTestEnv(t)
+	p := newPersister(env.msgSvc, config.Config{WSAsyncPersist: true, WSPersistMaxDelayMs: 200})
+	t.Cleanup(func() { p.close(time.Second) })
+	relayed := make(chan broker.Envelope, 4)
+	unsubscribe := env.hub.broker.Subscribe(func(e broker.Envelope) { relayed <- e })
+	t.Cleanup(unsubscribe)
+
+	rh := env.hub.GetRoom(env.roomID)
+	c := &Client{hub: env.hub, db: env.db, userID: env.userID, uname: "alice", protocol: ProtocolV2, send: newSendQueue(4, PolicyDisconnect)}
+	in := service.CreateMessageInput{RoomID: env.roomID, UserID: env.userID, Content: "late", ClientMsgID: "c-1"}
+	if err := p.enqueue(in, func(r service.CreateResult) { c.finishMessage(in.RoomID, r) }); err != nil {
+		t.Fatalf("enqueue() = %v", err)
+	}
+	// 批次尚未写入时房间被空闲回收。
+	if !env.hub.removeRoom(rh) {
+		t.Fatal("removeRoom() = false")
+	}
+	rh.halt()
+
+	select {
+	case e := <-relayed:
+		var out OutboundMessage
+		if err := json.Unmarshal(e.Data, &out); err != nil || out.Type != TypeMessage || out.Content != "late" || e.RoomID != env.roomID {
+			t.Errorf("relayed %+v (%s), want the late message", e, e.Data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("message persisted after the room was reaped was never broadcast")
+	}
+	env.hub.mu.RLock()
+	_, recreated := env.hub.rooms[env.roomID]
+	env.hub.mu.RUnlock()
+	if recreated {
+		t.Error("broadcast recreated the reaped RoomHub")
+	}
+}
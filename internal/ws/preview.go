@@ -0,0 +1,74 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"chatroom/internal/models"
+	"chatroom/internal/unfurl"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// maxPreviewsPerMessage 限制单条消息最多抓取的链接数量。
+const maxPreviewsPerMessage = 3
+
+// attachPreviews 在后台抓取消息中的链接预览，写库后通过 message_updated 事件推送给房间；
+// 消息已被隐藏或删除时只写库不推送。
+func attachPreviews(u *unfurl.Unfurler, db *gorm.DB, h *Hub, out OutboundMessage) {
+	urls := unfurl.ExtractURLs(out.Content, maxPreviewsPerMessage)
+	if len(urls) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	previews := make([]unfurl.Preview, 0, len(urls))
+	for _, raw := range urls {
+		p, err := u.Fetch(ctx, raw)
+		if err != nil {
+			log.Debug().Err(err).Str("url", raw).Uint("message_id", out.ID).Msg("ws unfurl")
+			continue
+		}
+		previews = append(previews, *p)
+	}
+	if len(previews) == 0 {
+		return
+	}
+
+	rows := make([]models.LinkPreview, 0, len(previews))
+	for _, p := range previews {
+		rows = append(rows, models.LinkPreview{
+			MessageID:   out.ID,
+			URL:         p.URL,
+			Title:       p.Title,
+			Description: p.Description,
+			Image:       p.Image,
+			SiteName:    p.SiteName,
+		})
+	}
+	if err := db.Create(&rows).Error; err != nil {
+		log.Error().Err(err).Uint("message_id", out.ID).Msg("ws persist link previews")
+		return
+	}
+
+	// 抓取期间消息可能已被隐藏或删除，此时不能再推送原文；预览已写库，消息恢复后随消息一起返回。
+	var msgs []models.Message
+	if err := db.Select("id", "hidden").Where("id = ?", out.ID).Limit(1).Find(&msgs).Error; err != nil {
+		log.Error().Err(err).Uint("message_id", out.ID).Msg("ws reload message for previews")
+		return
+	}
+	if len(msgs) == 0 || msgs[0].Hidden {
+		return
+	}
+
+	out.Type = TypeMessageUpdated
+	out.Previews = previews
+	b, err := json.Marshal(out)
+	if err != nil {
+		return
+	}
+	h.publish(frame{data: b, roomID: out.RoomID})
+}
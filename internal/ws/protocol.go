@@ -0,0 +1,366 @@
+package ws
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"chatroom/internal/unfurl"
+
+	"github.com/gorilla/websocket"
+)
+
+// 协议版本：v1 是最初的协议，未知帧类型按聊天消息处理；
+// v2 起连接建立后先下发 welcome，拒绝未知帧类型，错误事件只携带 code 与本地化的 message。
+const (
+	ProtocolV1      = 1
+	ProtocolV2      = 2
+	CurrentProtocol = ProtocolV2
+)
+
+// 应用自定义的关闭码。
+const (
+	// CloseUnauthorized 表示首帧鉴权失败或超时，或连接期间用户已被删除。
+	CloseUnauthorized = 4001
+	// CloseTokenExpired 表示令牌到期前客户端没有通过 reauth 帧续期。
+	CloseTokenExpired = 4002
+	// CloseSlowConsumer 表示因发送队列积压而断开连接。
+	CloseSlowConsumer = 4008
+	// CloseTryAgainLater 是标准关闭码 1013，首帧鉴权后才发现连接数超限时使用。
+	CloseTryAgainLater = websocket.CloseTryAgainLater
+	// CloseRateLimited 表示多次被临时禁言后仍持续超限。
+	CloseRateLimited = 4029
+	// CloseKicked 表示用户被管理员踢出或封禁。
+	CloseKicked = 4003
+	// CloseServiceRestart 是标准关闭码 1012，实例排空停服时使用。
+	CloseServiceRestart = websocket.CloseServiceRestart
+)
+
+// 帧类型。
+const (
+	TypePing           = "ping"
+	TypePong           = "pong"
+	TypeWelcome        = "welcome"
+	TypeError          = "error"
+	TypeMessage        = "message"
+	TypeMessageUpdated = "message_updated"
+	TypeAck            = "ack"
+	TypeTyping         = "typing"
+	TypeJoin           = "join"
+	TypeLeave          = "leave"
+	TypePresence       = "presence"
+	TypeStatus         = "status"
+	TypeActive         = "active"
+	TypeSubscribe      = "subscribe"
+	TypeSubscribed     = "subscribed"
+	TypeUnsubscribe    = "unsubscribe"
+	TypeUnsubscribed   = "unsubscribed"
+	TypeResume         = "resume"
+	TypeResumed        = "resumed"
+	TypeResyncRequired = "resync_required"
+	TypeClosed         = "closed"
+	TypeAuth           = "auth"
+	TypeReauth         = "reauth"
+	TypeReauthRequired = "reauth_required"
+	TypeReauthed       = "reauthed"
+	TypeServerRestart  = "server_restarting"
+	TypeKicked         = "kicked"
+)
+
+// InboundMessage 是客户端发来的帧，不同类型只使用其中部分字段。
+type InboundMessage struct {
+	Type        string `json:"type"`
+	RoomID      uint   `json:"room_id"`
+	LastSeenID  uint   `json:"last_seen_id"`
+	Content     string `json:"content"`
+	Format      string `json:"format"`
+	ClientMsgID string `json:"client_msg_id"`
+	IsTyping    bool   `json:"is_typing"`
+	Status      string `json:"status"`
+	StatusText  string `json:"status_text"`
+	ExpiresIn   int    `json:"expires_in"`
+	// Token / Ticket 仅用于 auth 帧，reauth 帧只使用 Token。
+	Token  string `json:"token"`
+	Ticket string `json:"ticket"`
+}
+
+// OutboundMessage 是 message / message_updated 事件。
+type OutboundMessage struct {
+	Type        string           `json:"type"`
+	ID          uint             `json:"id"`
+	RoomID      uint             `json:"room_id"`
+	Seq         uint64           `json:"seq"`
+	UserID      uint             `json:"user_id"`
+	Username    string           `json:"username"`
+	Content     string           `json:"content"`
+	Format      string           `json:"format"`
+	ContentHTML string           `json:"content_html"`
+	ClientMsgID string           `json:"client_msg_id,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+	Previews    []unfurl.Preview `json:"previews,omitempty"`
+}
+
+// AckMessage 告知发送方消息已持久化；Duplicate 为 true 表示这是一次重放。
+type AckMessage struct {
+	Type        string          `json:"type"`
+	RoomID      uint            `json:"room_id"`
+	ClientMsgID string          `json:"client_msg_id"`
+	ID          uint            `json:"id"`
+	Duplicate   bool            `json:"duplicate"`
+	Message     OutboundMessage `json:"message"`
+}
+
+// WelcomeEvent 是 v2 连接建立后的第一帧，告知协商结果。
+type WelcomeEvent struct {
+	Type     string `json:"type"`
+	Protocol int    `json:"protocol"`
+	Codec    string `json:"codec"`
+	Lang     string `json:"lang"`
+	UserID   uint   `json:"user_id"`
+}
+
+// PongEvent 响应客户端的 ping。
+type PongEvent struct {
+	Type string `json:"type"`
+}
+
+// ErrorEvent 是发给当前连接的错误；Content 只在 v1 中保留，内容与 Message 相同。
+type ErrorEvent struct {
+	Type    string    `json:"type"`
+	RoomID  uint      `json:"room_id,omitempty"`
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	Content string    `json:"content,omitempty"`
+	// RetryAfter 是限流禁言或慢速模式下还需等待的秒数。
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+// TypingEvent 广播用户正在输入。
+type TypingEvent struct {
+	Type     string `json:"type"`
+	RoomID   uint   `json:"room_id"`
+	UserID   uint   `json:"user_id"`
+	Username string `json:"username"`
+	IsTyping bool   `json:"is_typing"`
+}
+
+// MemberEvent 是 join / leave 事件，Online 为房间在本实例上的在线人数。
+type MemberEvent struct {
+	Type     string `json:"type"`
+	RoomID   uint   `json:"room_id"`
+	UserID   uint   `json:"user_id"`
+	Username string `json:"username"`
+	Online   int    `json:"online"`
+}
+
+// PresenceEvent 推送用户状态变化。
+type PresenceEvent struct {
+	Type            string     `json:"type"`
+	RoomID          uint       `json:"room_id"`
+	UserID          uint       `json:"user_id"`
+	Username        string     `json:"username"`
+	Status          string     `json:"status"`
+	StatusText      string     `json:"status_text"`
+	StatusExpiresAt *time.Time `json:"status_expires_at"`
+	Idle            bool       `json:"idle"`
+}
+
+// SubscriptionEvent 是 subscribed / unsubscribed 确认。
+type SubscriptionEvent struct {
+	Type   string `json:"type"`
+	RoomID uint   `json:"room_id"`
+}
+
+// ResumedEvent 标记续传批次结束，LastID 是补发的最后一条消息 ID。
+type ResumedEvent struct {
+	Type   string `json:"type"`
+	RoomID uint   `json:"room_id"`
+	Count  int    `json:"count"`
+	LastID uint   `json:"last_id"`
+}
+
+// ResyncRequiredEvent 提示客户端错过的消息过多，需要通过 REST 接口全量同步。
+type ResyncRequiredEvent struct {
+	Type       string `json:"type"`
+	RoomID     uint   `json:"room_id"`
+	LastSeenID uint   `json:"last_seen_id"`
+}
+
+// ReauthEvent 是 reauth_required / reauthed 事件，ExpiresAt 是当前令牌的过期时间。
+type ReauthEvent struct {
+	Type      string    `json:"type"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// KickedEvent 通知用户已被管理员移出房间，RoomID 为零表示被断开所有连接。
+type KickedEvent struct {
+	Type   string `json:"type"`
+	RoomID uint   `json:"room_id,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// ServerRestartingEvent 通知客户端实例即将停服，ReconnectAfter 是建议的重连等待毫秒数，已按连接加入随机抖动。
+type ServerRestartingEvent struct {
+	Type           string `json:"type"`
+	ReconnectAfter int    `json:"reconnect_after_ms"`
+}
+
+// ClosedEvent 是 SSE 连接被服务端主动断开前的最后一个事件，Code 与 WebSocket 关闭码一致。
+type ClosedEvent struct {
+	Type   string `json:"type"`
+	Code   int    `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// marshalEvent 序列化事件；事件都是固定结构，失败只可能是编程错误，返回 nil 由调用方丢弃。
+func marshalEvent(v interface{}) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// ErrorCode 是可供客户端分支判断的错误码。
+type ErrorCode string
+
+const (
+	ErrCodeInvalidFrame        ErrorCode = "invalid_frame"
+	ErrCodeUnknownType         ErrorCode = "unknown_type"
+	ErrCodeResumeNotFirst      ErrorCode = "resume_not_first"
+	ErrCodeRoomIDRequired      ErrorCode = "room_id_required"
+	ErrCodeNotSubscribed       ErrorCode = "not_subscribed"
+	ErrCodeTooManyRooms        ErrorCode = "too_many_rooms"
+	ErrCodeRoomNotFound        ErrorCode = "room_not_found"
+	ErrCodeRoomUnavailable     ErrorCode = "room_unavailable"
+	ErrCodeMessageTooLong      ErrorCode = "message_too_long"
+	ErrCodeUnsupportedFormat   ErrorCode = "unsupported_format"
+	ErrCodeInvalidClientMsgID  ErrorCode = "invalid_client_msg_id"
+	ErrCodeClientMsgIDConflict ErrorCode = "client_msg_id_conflict"
+	ErrCodeMessageFailed       ErrorCode = "message_failed"
+	ErrCodeInvalidStatus       ErrorCode = "invalid_status"
+	ErrCodeStatusTextTooLong   ErrorCode = "status_text_too_long"
+	ErrCodeInvalidStatusExpiry ErrorCode = "invalid_status_expiry"
+	ErrCodeStatusFailed        ErrorCode = "status_failed"
+	ErrCodeRoomNotAllowed      ErrorCode = "room_not_allowed"
+	ErrCodeReauthFailed        ErrorCode = "reauth_failed"
+	ErrCodeRateLimited         ErrorCode = "rate_limited"
+	ErrCodeRateMuted           ErrorCode = "rate_muted"
+	ErrCodeRoomFull            ErrorCode = "room_full"
+	ErrCodeBanned              ErrorCode = "banned"
+	ErrCodeMuted               ErrorCode = "muted"
+	ErrCodeMessageRejected     ErrorCode = "message_rejected"
+	ErrCodeSlowMode            ErrorCode = "slow_mode"
+	ErrCodeAnnouncementOnly    ErrorCode = "announcement_only"
+	ErrCodeServerBusy          ErrorCode = "server_busy"
+	ErrCodeServerDraining      ErrorCode = "server_draining"
+)
+
+// 支持的错误消息语言，默认中文。
+const (
+	LangZH      = "zh"
+	LangEN      = "en"
+	defaultLang = LangZH
+)
+
+var errorMessages = map[string]map[ErrorCode]string{
+	LangZH: {
+		ErrCodeInvalidFrame:        "无法解析的帧",
+		ErrCodeUnknownType:         "不支持的帧类型",
+		ErrCodeResumeNotFirst:      "resume 只能作为连接后的第一帧发送",
+		ErrCodeRoomIDRequired:      "room_id 不能为空",
+		ErrCodeNotSubscribed:       "请先订阅该房间",
+		ErrCodeTooManyRooms:        "订阅的房间数量已达上限",
+		ErrCodeRoomNotFound:        "房间不存在",
+		ErrCodeRoomUnavailable:     "房间不可用",
+		ErrCodeMessageTooLong:      "消息长度不能超过2000字符",
+		ErrCodeUnsupportedFormat:   "不支持的消息格式",
+		ErrCodeInvalidClientMsgID:  "client_msg_id 不能超过64字符",
+		ErrCodeClientMsgIDConflict: "client_msg_id 已在其他房间使用过",
+		ErrCodeMessageFailed:       "消息发送失败",
+		ErrCodeInvalidStatus:       "状态只能是 available、busy 或 away",
+		ErrCodeStatusTextTooLong:   "状态文字不能超过128字符",
+		ErrCodeInvalidStatusExpiry: "状态有效期不能超过7天",
+		ErrCodeStatusFailed:        "状态设置失败",
+		ErrCodeRoomNotAllowed:      "连接凭证只能用于另一个房间",
+		ErrCodeReauthFailed:        "令牌无效或不属于当前用户",
+		ErrCodeRateLimited:         "发送过于频繁，请稍后再试",
+		ErrCodeRateMuted:           "发送过于频繁，已被临时禁言",
+		ErrCodeRoomFull:            "房间连接数已达上限",
+		ErrCodeBanned:              "你已被管理员封禁",
+		ErrCodeMuted:               "你已被管理员禁言",
+		ErrCodeMessageRejected:     "消息包含不允许的内容",
+		ErrCodeSlowMode:            "房间已开启慢速模式，请稍后再发言",
+		ErrCodeAnnouncementOnly:    "公告房间只有房主和管理员可以发言",
+		ErrCodeServerBusy:          "服务器繁忙，请稍后重发",
+		ErrCodeServerDraining:      "服务器正在重启，请重连后重发",
+	},
+	LangEN: {
+		ErrCodeInvalidFrame:        "frame could not be decoded",
+		ErrCodeUnknownType:         "unsupported frame type",
+		ErrCodeResumeNotFirst:      "resume must be the first frame after connecting",
+		ErrCodeRoomIDRequired:      "room_id is required",
+		ErrCodeNotSubscribed:       "not subscribed to this room",
+		ErrCodeTooManyRooms:        "too many subscribed rooms",
+		ErrCodeRoomNotFound:        "room not found",
+		ErrCodeRoomUnavailable:     "room is unavailable",
+		ErrCodeMessageTooLong:      "message must not exceed 2000 characters",
+		ErrCodeUnsupportedFormat:   "unsupported message format",
+		ErrCodeInvalidClientMsgID:  "client_msg_id must not exceed 64 characters",
+		ErrCodeClientMsgIDConflict: "client_msg_id was already used in another room",
+		ErrCodeMessageFailed:       "failed to send message",
+		ErrCodeInvalidStatus:       "status must be available, busy or away",
+		ErrCodeStatusTextTooLong:   "status text must not exceed 128 characters",
+		ErrCodeInvalidStatusExpiry: "status expiry must not exceed 7 days",
+		ErrCodeStatusFailed:        "failed to set status",
+		ErrCodeRoomNotAllowed:      "connection credentials are bound to another room",
+		ErrCodeReauthFailed:        "token is invalid or belongs to another user",
+		ErrCodeRateLimited:         "sending too fast, please slow down",
+		ErrCodeRateMuted:           "temporarily muted for sending too fast",
+		ErrCodeRoomFull:            "room has too many connections",
+		ErrCodeBanned:              "you are banned",
+		ErrCodeMuted:               "you are muted",
+		ErrCodeMessageRejected:     "message contains disallowed content",
+		ErrCodeSlowMode:            "slow mode is on, please wait before sending again",
+		ErrCodeAnnouncementOnly:    "only the owner and moderators can post in this room",
+		ErrCodeServerBusy:          "server is busy, please resend later",
+		ErrCodeServerDraining:      "server is restarting, please reconnect and resend",
+	},
+}
+
+// localize 返回错误码在指定语言下的描述，缺少翻译时回退到中文。
+func localize(code ErrorCode, lang string) string {
+	if msg, ok := errorMessages[lang][code]; ok {
+		return msg
+	}
+	if msg, ok := errorMessages[defaultLang][code]; ok {
+		return msg
+	}
+	return string(code)
+}
+
+// negotiateLang 优先使用 lang 参数，其次是 Accept-Language 中第一个支持的语言。
+func negotiateLang(query, acceptLanguage string) string {
+	candidates := []string{query}
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		candidates = append(candidates, strings.SplitN(strings.TrimSpace(part), ";", 2)[0])
+	}
+	for _, c := range candidates {
+		base := strings.ToLower(strings.SplitN(c, "-", 2)[0])
+		if _, ok := errorMessages[base]; ok {
+			return base
+		}
+	}
+	return defaultLang
+}
+
+// negotiateProtocol 解析 protocol 参数，未指定时为 v1，不支持的版本返回 false。
+func negotiateProtocol(v string) (int, bool) {
+	switch v {
+	case "", "1":
+		return ProtocolV1, true
+	case "2":
+		return ProtocolV2, true
+	}
+	return 0, false
+}
@@ -0,0 +1,30 @@
+package ws
+
+import "testing"
+
+func TestNegotiateLang(t *testing.T) {
+	tests := []struct {
+		query, header, want string
+	}{
+		{"", "", LangZH},
+		{"en", "zh-CN", LangEN},
+		{"", "en-US,en;q=0.9", LangEN},
+		{"", "fr-FR, en;q=0.5", LangEN},
+		{"fr", "de", LangZH},
+	}
+	for _, tt := range tests {
+		if got := negotiateLang(tt.query, tt.header); got != tt.want {
+			t.Errorf("negotiateLang(%q, %q) = %q, want %q", tt.query, tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestErrorMessages_Complete(t *testing.T) {
+	for code := range errorMessages[defaultLang] {
+		for lang, msgs := range errorMessages {
+			if msgs[code] == "" {
+				t.Errorf("error code %q has no %s message", code, lang)
+			}
+		}
+	}
+}
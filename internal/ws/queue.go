@@ -0,0 +1,134 @@
+package ws
+
+import (
+	"strconv"
+	"sync"
+
+	"chatroom/internal/metrics"
+)
+
+// SlowConsumerPolicy 决定客户端发送队列写满时如何处理新帧。
+type SlowConsumerPolicy string
+
+const (
+	// PolicyDisconnect 直接断开慢消费者，关闭码为 CloseSlowConsumer。
+	PolicyDisconnect SlowConsumerPolicy = "disconnect"
+	// PolicyDropOldest 丢弃队列中最旧的一帧，客户端可以通过 seq 缺口补齐消息。
+	PolicyDropOldest SlowConsumerPolicy = "drop_oldest"
+	// PolicyDropTyping 优先丢弃输入提示等可丢弃的帧，没有可丢弃的帧时断开。
+	PolicyDropTyping SlowConsumerPolicy = "drop_typing"
+	// PolicyCoalesce 用同一用户最新的输入提示 / 在线状态替换队列中的旧帧，仍然放不下时按 drop_typing 处理。
+	PolicyCoalesce SlowConsumerPolicy = "coalesce"
+)
+
+// defaultSendQueueSize 是未配置时每个客户端发送队列的长度。
+const defaultSendQueueSize = 256
+
+// ParsePolicy 解析配置中的慢消费者策略，无法识别时返回 false。
+func ParsePolicy(s string) (SlowConsumerPolicy, bool) {
+	switch p := SlowConsumerPolicy(s); p {
+	case PolicyDisconnect, PolicyDropOldest, PolicyDropTyping, PolicyCoalesce:
+		return p, true
+	case "":
+		return PolicyDisconnect, true
+	}
+	return "", false
+}
+
+// sendQueue 是客户端的有界发送队列，push 从不阻塞，写满时按策略腾出空间或拒绝。
+type sendQueue struct {
+	mu     sync.Mutex
+	items  []frame
+	size   int
+	policy SlowConsumerPolicy
+	// notify 在队列由空变为非空时收到信号，供 writePump 等待。
+	notify chan struct{}
+}
+
+func newSendQueue(size int, policy SlowConsumerPolicy) *sendQueue {
+	if size <= 0 {
+		size = defaultSendQueueSize
+	}
+	if policy == "" {
+		policy = PolicyDisconnect
+	}
+	return &sendQueue{items: make([]frame, 0, size), size: size, policy: policy, notify: make(chan struct{}, 1)}
+}
+
+// push 放入一帧，返回 false 表示队列已满且策略要求断开连接。
+func (q *sendQueue) push(f frame) bool {
+	q.mu.Lock()
+	ok := q.pushLocked(f)
+	q.mu.Unlock()
+	if ok {
+		select {
+		case q.notify <- struct{}{}:
+		default:
+		}
+	}
+	return ok
+}
+
+func (q *sendQueue) pushLocked(f frame) bool {
+	if q.policy == PolicyCoalesce && f.key != "" {
+		for i := range q.items {
+			if q.items[i].key == f.key {
+				q.items[i] = f
+				metrics.WsDroppedFrames.WithLabelValues("coalesced").Inc()
+				return true
+			}
+		}
+	}
+	if len(q.items) < q.size {
+		q.items = append(q.items, f)
+		return true
+	}
+
+	switch q.policy {
+	case PolicyDropOldest:
+		q.items = append(q.items[1:], f)
+		metrics.WsDroppedFrames.WithLabelValues("drop_oldest").Inc()
+		return true
+	case PolicyDropTyping, PolicyCoalesce:
+		for i := range q.items {
+			if q.items[i].ephemeral {
+				q.items = append(append(q.items[:i], q.items[i+1:]...), f)
+				metrics.WsDroppedFrames.WithLabelValues("drop_typing").Inc()
+				return true
+			}
+		}
+		if f.ephemeral {
+			metrics.WsDroppedFrames.WithLabelValues("drop_typing").Inc()
+			return true
+		}
+	}
+	return false
+}
+
+// tryPush 放入只发给当前客户端的帧，队列已满且腾不出空间时直接丢弃，不会触发断开。
+// 确认与错误事件不是可丢弃的帧，drop_typing 与 coalesce 策略下会挤掉积压的输入提示。
+func (q *sendQueue) tryPush(f frame) {
+	q.push(f)
+}
+
+// drain 取出队列中的全部帧，并记录取出前的队列深度。
+func (q *sendQueue) drain() []frame {
+	q.mu.Lock()
+	items := q.items
+	q.items = make([]frame, 0, q.size)
+	q.mu.Unlock()
+	metrics.WsSendQueueDepth.Observe(float64(len(items)))
+	return items
+}
+
+// len 返回当前积压的帧数。
+func (q *sendQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// coalesceKey 生成可合并帧的 key，同一房间同一用户的同类事件只保留最新一帧。
+func coalesceKey(kind string, roomID, userID uint) string {
+	return kind + ":" + strconv.FormatUint(uint64(roomID), 10) + ":" + strconv.FormatUint(uint64(userID), 10)
+}
@@ -0,0 +1,161 @@
+package ws
+
+import (
+	"testing"
+	"time"
+)
+
+// recvFrame 从发送队列取出最早的一帧，超时返回 false。
+func recvFrame(q *sendQueue, timeout time.Duration) (frame, bool) {
+	deadline := time.After(timeout)
+	for {
+		q.mu.Lock()
+		if len(q.items) > 0 {
+			f := q.items[0]
+			q.items = q.items[1:]
+			q.mu.Unlock()
+			return f, true
+		}
+		q.mu.Unlock()
+		select {
+		case <-q.notify:
+		case <-deadline:
+			return frame{}, false
+		}
+	}
+}
+
+func queued(q *sendQueue) []string {
+	out := make([]string, 0, q.len())
+	for _, f := range q.drain() {
+		out = append(out, string(f.data))
+	}
+	return out
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSendQueue_Policies(t *testing.T) {
+	msg := func(s string) frame { return frame{data: []byte(s), roomID: 1} }
+	typing := func(s string, user uint) frame {
+		return frame{data: []byte(s), roomID: 1, ephemeral: true, key: coalesceKey("typing", 1, user)}
+	}
+
+	tests := []struct {
+		name   string
+		policy SlowConsumerPolicy
+		fill   []frame
+		push   frame
+		wantOK bool
+		want   []string
+	}{
+		{"disconnect rejects when full", PolicyDisconnect, []frame{msg("a"), msg("b")}, msg("c"), false, []string{"a", "b"}},
+		{"drop oldest evicts head", PolicyDropOldest, []frame{msg("a"), msg("b")}, msg("c"), true, []string{"b", "c"}},
+		{"drop typing evicts typing first", PolicyDropTyping, []frame{msg("a"), typing("t", 2)}, msg("c"), true, []string{"a", "c"}},
+		{"drop typing discards incoming typing", PolicyDropTyping, []frame{msg("a"), msg("b")}, typing("t", 2), true, []string{"a", "b"}},
+		{"drop typing disconnects without typing", PolicyDropTyping, []frame{msg("a"), msg("b")}, msg("c"), false, []string{"a", "b"}},
+		{"coalesce replaces same key", PolicyCoalesce, []frame{typing("t1", 2), msg("a")}, typing("t2", 2), true, []string{"t2", "a"}},
+		{"coalesce keeps other users", PolicyCoalesce, []frame{typing("t1", 2), msg("a")}, typing("t3", 3), true, []string{"a", "t3"}},
+		{"coalesce falls back to disconnect", PolicyCoalesce, []frame{msg("a"), msg("b")}, msg("c"), false, []string{"a", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newSendQueue(2, tt.policy)
+			for _, f := range tt.fill {
+				if !q.push(f) {
+					t.Fatalf("push(%s) rejected before queue was full", f.data)
+				}
+			}
+			if ok := q.push(tt.push); ok != tt.wantOK {
+				t.Errorf("push() = %v, want %v", ok, tt.wantOK)
+			}
+			if got := queued(q); !equalStrings(got, tt.want) {
+				t.Errorf("queue = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendQueue_TryPushNeverDisconnects(t *testing.T) {
+	q := newSendQueue(1, PolicyDisconnect)
+	q.push(frame{data: []byte("a")})
+	q.tryPush(frame{data: []byte("pong")})
+	if got := queued(q); !equalStrings(got, []string{"a"}) {
+		t.Errorf("queue = %v, want [a]", got)
+	}
+}
+
+func TestSendQueue_TryPushKeepsAcks(t *testing.T) {
+	q := newSendQueue(2, PolicyDropTyping)
+	q.tryPush(frame{data: []byte("ack")})
+	q.push(frame{data: []byte("typing"), ephemeral: true})
+	q.tryPush(frame{data: []byte("error")})
+	q.push(frame{data: []byte("typing2"), ephemeral: true})
+	if got := queued(q); !equalStrings(got, []string{"ack", "error"}) {
+		t.Errorf("queue = %v, want [ack error]", got)
+	}
+}
+
+func TestRoomHub_SlowConsumerDisconnected(t *testing.T) {
+	rh := startTestRoomHub(t, 1)
+	slow := &Client{userID: 1, uname: "slow", send: newSendQueue(1, PolicyDisconnect), done: make(chan struct{})}
+	fast := &Client{userID: 2, uname: "fast", send: newSendQueue(256, PolicyDisconnect), done: make(chan struct{})}
+	rh.register <- slow
+	rh.register <- fast
+
+	rh.publish(frame{data: []byte("m1"), roomID: 1})
+	rh.publish(frame{data: []byte("m2"), roomID: 1})
+
+	select {
+	case <-slow.done:
+	case <-time.After(time.Second):
+		t.Fatal("slow consumer was not disconnected")
+	}
+	if slow.closeCode != CloseSlowConsumer {
+		t.Errorf("closeCode = %d, want %d", slow.closeCode, CloseSlowConsumer)
+	}
+	for _, want := range []string{"m1", "m2"} {
+		for {
+			f, ok := recvFrame(fast.send, time.Second)
+			if !ok {
+				t.Fatalf("fast client did not receive %s", want)
+			}
+			if string(f.data) == want {
+				break
+			}
+		}
+	}
+	select {
+	case <-fast.done:
+		t.Error("fast client should stay connected")
+	default:
+	}
+}
+
+func TestRoomHub_PublishDoesNotBlock(t *testing.T) {
+	// 未启动 run 的房间不会消费积压，publish 仍然必须立即返回。
+	rh := NewRoomHub(1)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < roomBacklog+10; i++ {
+			rh.publish(frame{data: []byte("x"), roomID: 1})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked on a full room backlog")
+	}
+}
@@ -0,0 +1,278 @@
+package ws
+
+import (
+	"sync"
+	"time"
+
+	"chatroom/internal/config"
+	"chatroom/internal/metrics"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+)
+
+// frameClass 是上行帧的限流类别，每类使用独立的令牌桶。
+type frameClass int
+
+const (
+	classMessage frameClass = iota
+	classTyping
+	classOther
+	numFrameClasses
+)
+
+// verdict 是一帧的限流结果。
+type verdict int
+
+const (
+	verdictAllow verdict = iota
+	// verdictWarn 表示帧超限被丢弃。
+	verdictWarn
+	// verdictMute 表示本次超限触发了临时禁言，verdictMuted 表示用户正处于禁言期。
+	verdictMute
+	verdictMuted
+	// verdictDisconnect 表示屡次禁言后仍超限，应断开连接。
+	verdictDisconnect
+)
+
+// rateLimits 是上行帧限流的配置。
+type rateLimits struct {
+	class [numFrameClasses]bucketSpec
+	conn  bucketSpec
+	// window 内超限 muteAfter 次触发 muteFor 的禁言；strikeWindow 内被禁言 disconnectAfter 次则断开连接。
+	window          time.Duration
+	muteAfter       int
+	muteFor         time.Duration
+	disconnectAfter int
+	strikeWindow    time.Duration
+}
+
+type bucketSpec struct {
+	rps   rate.Limit
+	burst int
+}
+
+func newBucketSpec(rps, burst int) bucketSpec {
+	return bucketSpec{rps: rate.Limit(rps), burst: max(burst, rps)}
+}
+
+// limiter 创建令牌桶，速率为零的类别不限速。
+func (s bucketSpec) limiter() *rate.Limiter {
+	if s.rps <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	return rate.NewLimiter(s.rps, s.burst)
+}
+
+// userLimiter 是某个用户在本实例所有连接共享的限流状态。状态不在实例间共享，
+// 同一用户连到多个实例时，每个实例各自按完整的额度限流。
+type userLimiter struct {
+	mu          sync.Mutex
+	buckets     [numFrameClasses]*rate.Limiter
+	refs        int
+	violations  int
+	windowStart time.Time
+	mutedUntil  time.Time
+	strikes     int
+	lastStrike  time.Time
+}
+
+// rateLimiter 按用户管理令牌桶，最后一个连接断开且没有处罚在身时释放。
+type rateLimiter struct {
+	limits rateLimits
+	mu     sync.Mutex
+	users  map[uint]*userLimiter
+}
+
+func newRateLimiter(cfg config.Config) *rateLimiter {
+	window := time.Minute
+	muteFor := time.Duration(cfg.WSRateMuteSeconds) * time.Second
+	return &rateLimiter{
+		limits: rateLimits{
+			class: [numFrameClasses]bucketSpec{
+				classMessage: newBucketSpec(cfg.WSRateMessageRPS, cfg.WSRateMessageBurst),
+				classTyping:  newBucketSpec(cfg.WSRateTypingRPS, cfg.WSRateTypingBurst),
+				classOther:   newBucketSpec(cfg.WSRateOtherRPS, cfg.WSRateOtherBurst),
+			},
+			conn:            newBucketSpec(cfg.WSRateConnRPS, cfg.WSRateConnBurst),
+			window:          window,
+			muteAfter:       max(cfg.WSRateMuteAfter, 1),
+			muteFor:         muteFor,
+			disconnectAfter: max(cfg.WSRateDisconnectAfter, 1),
+			strikeWindow:    10 * (muteFor + window),
+		},
+		users: make(map[uint]*userLimiter),
+	}
+}
+
+// enabled 表示是否配置了限流，速率为零的配置关闭整个功能。
+func (rl *rateLimiter) enabled() bool {
+	return rl.limits.conn.rps > 0 && rl.limits.class[classMessage].rps > 0
+}
+
+// attach 为连接启用限流，未配置限流时不做任何事。
+func (rl *rateLimiter) attach(c *Client) {
+	if !rl.enabled() {
+		return
+	}
+	c.rl, c.rlUser, c.connLimit = rl, rl.acquire(c.userID), rl.limits.conn.limiter()
+}
+
+// detach 在连接断开时释放用户的限流状态。
+func (rl *rateLimiter) detach(c *Client) {
+	if c.rl != nil {
+		rl.release(c.userID)
+	}
+}
+
+// acquire 返回用户的共享限流状态，每个连接建立时调用一次。
+func (rl *rateLimiter) acquire(userID uint) *userLimiter {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	u, ok := rl.users[userID]
+	if !ok {
+		u = &userLimiter{}
+		for i := range u.buckets {
+			u.buckets[i] = rl.limits.class[i].limiter()
+		}
+		rl.users[userID] = u
+	}
+	u.refs++
+	return u
+}
+
+// release 在连接断开时调用；处罚尚未结束时保留状态，避免通过重连绕过禁言。
+func (rl *rateLimiter) release(userID uint) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	u, ok := rl.users[userID]
+	if !ok {
+		return
+	}
+	u.refs--
+	if u.refs > 0 {
+		return
+	}
+	if wait := u.penaltyLeft(time.Now(), rl.limits.strikeWindow); wait > 0 {
+		// 由定时器持有一个引用，处罚结束后再释放。
+		u.refs++
+		time.AfterFunc(wait, func() { rl.release(userID) })
+		return
+	}
+	delete(rl.users, userID)
+}
+
+// penaltyLeft 返回禁言或累计处罚还要持续多久。
+func (u *userLimiter) penaltyLeft(now time.Time, strikeWindow time.Duration) time.Duration {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	left := u.mutedUntil.Sub(now)
+	if u.strikes > 0 {
+		left = max(left, u.lastStrike.Add(strikeWindow).Sub(now))
+	}
+	return left
+}
+
+// check 判断一帧是否放行；conn 是连接自身的令牌桶，retry 是禁言剩余时间。
+func (rl *rateLimiter) check(u *userLimiter, conn *rate.Limiter, class frameClass, now time.Time) (v verdict, retry time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if class != classOther && now.Before(u.mutedUntil) {
+		return verdictMuted, u.mutedUntil.Sub(now)
+	}
+	if take(now, conn, u.buckets[class]) {
+		return verdictAllow, 0
+	}
+	if now.Sub(u.windowStart) > rl.limits.window {
+		u.windowStart, u.violations = now, 0
+	}
+	u.violations++
+	if u.violations < rl.limits.muteAfter {
+		return verdictWarn, 0
+	}
+	u.violations = 0
+	if now.Sub(u.lastStrike) > rl.limits.strikeWindow {
+		u.strikes = 0
+	}
+	u.strikes++
+	u.lastStrike = now
+	if u.strikes > rl.limits.disconnectAfter {
+		return verdictDisconnect, 0
+	}
+	u.mutedUntil = now.Add(rl.limits.muteFor)
+	return verdictMute, rl.limits.muteFor
+}
+
+// take 从连接与用户的令牌桶各取一个令牌；任一桶不足时两者都不扣除，
+// 某一类帧超限不会消耗连接上其它类别帧的额度。
+func take(now time.Time, conn, user *rate.Limiter) bool {
+	rc := conn.ReserveN(now, 1)
+	if !rc.OK() || rc.DelayFrom(now) > 0 {
+		rc.CancelAt(now)
+		return false
+	}
+	ru := user.ReserveN(now, 1)
+	if !ru.OK() || ru.DelayFrom(now) > 0 {
+		ru.CancelAt(now)
+		rc.CancelAt(now)
+		return false
+	}
+	return true
+}
+
+// frameClass 返回上行帧的限流类别；v1 协议中未知类型按聊天消息处理。
+func (c *Client) frameClass(typ string) frameClass {
+	switch typ {
+	case TypeMessage:
+		return classMessage
+	case TypeTyping:
+		return classTyping
+	case TypePing, TypeActive, TypeStatus, TypeSubscribe, TypeUnsubscribe, TypeResume, TypeAuth, TypeReauth:
+		return classOther
+	}
+	if c.protocol < ProtocolV2 {
+		return classMessage
+	}
+	return classOther
+}
+
+// allowFrame 对上行帧限流，超限的帧被丢弃并回复错误事件，屡次违规时断开连接。
+func (c *Client) allowFrame(typ string) bool {
+	if c.rl == nil {
+		return true
+	}
+	v, retry := c.rl.check(c.rlUser, c.connLimit, c.frameClass(typ), time.Now())
+	switch v {
+	case verdictAllow:
+		return true
+	case verdictWarn:
+		metrics.WsRateLimited.WithLabelValues("dropped").Inc()
+		c.sendRateError(ErrCodeRateLimited, 0)
+	case verdictMuted:
+		metrics.WsRateLimited.WithLabelValues("dropped").Inc()
+		c.sendRateError(ErrCodeRateMuted, retry)
+	case verdictMute:
+		metrics.WsRateLimited.WithLabelValues("muted").Inc()
+		log.Warn().Uint("user_id", c.userID).Dur("mute_for", retry).Msg("ws rate limit mute")
+		c.sendRateError(ErrCodeRateMuted, retry)
+	case verdictDisconnect:
+		metrics.WsRateLimited.WithLabelValues("disconnected").Inc()
+		log.Warn().Uint("user_id", c.userID).Msg("ws rate limit disconnect")
+		c.closeWith(CloseRateLimited, "rate limit exceeded")
+	}
+	return false
+}
+
+// sendRateError 回复限流错误，retry 非零时告知客户端多久后可以重试。
+func (c *Client) sendRateError(code ErrorCode, retry time.Duration) {
+	c.sendRetryError(0, code, retry)
+}
+
+// sendRetryError 回复错误事件，retry 非零时以 retry_after（秒，向上取整）告知客户端多久后可以重试。
+func (c *Client) sendRetryError(roomID uint, code ErrorCode, retry time.Duration) {
+	evt := ErrorEvent{Type: TypeError, RoomID: roomID, Code: code, Message: localize(code, c.lang), RetryAfter: int((retry + time.Second - 1) / time.Second)}
+	if c.protocol < ProtocolV2 {
+		evt.Content = evt.Message
+	}
+	c.trySend(marshalEvent(evt))
+}
@@ -0,0 +1,155 @@
+package ws
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"chatroom/internal/config"
+)
+
+func rateTestConfig(cfg *config.Config) {
+	cfg.WSRateMessageRPS, cfg.WSRateMessageBurst = 1, 1
+	cfg.WSRateTypingRPS, cfg.WSRateTypingBurst = 1, 1
+	cfg.WSRateOtherRPS, cfg.WSRateOtherBurst = 100, 100
+	cfg.WSRateConnRPS, cfg.WSRateConnBurst = 100, 100
+	cfg.WSRateMuteAfter = 2
+	cfg.WSRateMuteSeconds = 30
+	cfg.WSRateDisconnectAfter = 1
+}
+
+func TestRateLimiter_Escalation(t *testing.T) {
+	var cfg config.Config
+	rateTestConfig(&cfg)
+	rl := newRateLimiter(cfg)
+	u := rl.acquire(1)
+	conn := rl.limits.conn.limiter()
+	now := time.Now()
+
+	steps := []struct {
+		class frameClass
+		want  verdict
+	}{
+		{classMessage, verdictAllow},
+		{classMessage, verdictWarn},
+		{classMessage, verdictMute},
+		// 禁言期间消息与输入提示直接拒绝，其它帧不受影响。
+		{classTyping, verdictMuted},
+		{classOther, verdictAllow},
+	}
+	for i, st := range steps {
+		if v, _ := rl.check(u, conn, st.class, now); v != st.want {
+			t.Fatalf("step %d: check() = %v, want %v", i, v, st.want)
+		}
+	}
+
+	// 禁言结束后再次触发禁言即断开。
+	now = now.Add(31 * time.Second)
+	for i, want := range []verdict{verdictAllow, verdictWarn, verdictDisconnect} {
+		if v, _ := rl.check(u, conn, classMessage, now); v != want {
+			t.Fatalf("after mute step %d: check() = %v, want %v", i, v, want)
+		}
+	}
+}
+
+func TestRateLimiter_SharedAcrossConnections(t *testing.T) {
+	var cfg config.Config
+	rateTestConfig(&cfg)
+	rl := newRateLimiter(cfg)
+	a, b := rl.acquire(1), rl.acquire(1)
+	if a != b {
+		t.Fatal("acquire() returned different state for the same user")
+	}
+	now := time.Now()
+	if v, _ := rl.check(a, rl.limits.conn.limiter(), classMessage, now); v != verdictAllow {
+		t.Fatalf("first connection check() = %v, want allow", v)
+	}
+	if v, _ := rl.check(b, rl.limits.conn.limiter(), classMessage, now); v != verdictWarn {
+		t.Errorf("second connection check() = %v, want warn", v)
+	}
+
+	// 有处罚在身时断开全部连接也保留状态，重连不能绕过禁言。
+	rl.check(b, rl.limits.conn.limiter(), classMessage, now)
+	rl.release(1)
+	rl.release(1)
+	if rl.acquire(1) != a {
+		t.Error("limiter state was dropped while the user was muted")
+	}
+}
+
+func TestRateLimiter_DeniedFrameKeepsConnBudget(t *testing.T) {
+	var cfg config.Config
+	rateTestConfig(&cfg)
+	cfg.WSRateConnRPS, cfg.WSRateConnBurst = 2, 2
+	cfg.WSRateMuteAfter = 10
+	rl := newRateLimiter(cfg)
+	u := rl.acquire(1)
+	conn := rl.limits.conn.limiter()
+	now := time.Now()
+
+	// 输入提示超限被拒绝时不扣除连接的令牌，同一连接上的消息仍可发送。
+	for i, want := range []verdict{verdictAllow, verdictWarn, verdictWarn, verdictWarn} {
+		if v, _ := rl.check(u, conn, classTyping, now); v != want {
+			t.Fatalf("typing step %d: check() = %v, want %v", i, v, want)
+		}
+	}
+	if v, _ := rl.check(u, conn, classMessage, now); v != verdictAllow {
+		t.Errorf("message check() = %v, want allow", v)
+	}
+}
+
+func TestRateLimiter_ZeroRates(t *testing.T) {
+	var cfg config.Config
+	rateTestConfig(&cfg)
+	cfg.WSRateTypingRPS = 0
+	rl := newRateLimiter(cfg)
+	if !rl.enabled() {
+		t.Fatal("enabled() = false, want true")
+	}
+	u, conn, now := rl.acquire(1), rl.limits.conn.limiter(), time.Now()
+	for i := 0; i < 5; i++ {
+		if v, _ := rl.check(u, conn, classTyping, now); v != verdictAllow {
+			t.Fatalf("typing frame %d: check() = %v, want allow", i, v)
+		}
+	}
+
+	cfg.WSRateMessageRPS = 0
+	if newRateLimiter(cfg).enabled() {
+		t.Error("enabled() with zero message rate = true, want false")
+	}
+}
+
+func TestServe_RateLimitMutes(t *testing.T) {
+	env := newTestEnv(t, rateTestConfig)
+	conn := env.dial(fmt.Sprintf("room_id=%d&token=%s&protocol=2", env.roomID, env.token))
+
+	for i := 0; i < 3; i++ {
+		if err := conn.WriteJSON(map[string]string{"type": "message", "content": fmt.Sprintf("m%d", i)}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	// 消息落库后才广播，可能晚于限流错误到达。
+	var delivered int
+	var codes []interface{}
+	for delivered == 0 || len(codes) < 2 {
+		evt := readEvent(t, conn)
+		switch evt["type"] {
+		case "message":
+			delivered++
+		case "error":
+			codes = append(codes, evt["code"])
+			if evt["code"] == string(ErrCodeRateMuted) && evt["retry_after"] != float64(30) {
+				t.Errorf("rate_muted = %v, want retry_after 30", evt)
+			}
+		}
+	}
+	if codes[0] != string(ErrCodeRateLimited) || codes[1] != string(ErrCodeRateMuted) {
+		t.Errorf("error codes = %v, want rate_limited then rate_muted", codes)
+	}
+
+	var count int64
+	env.db.Table("messages").Count(&count)
+	if count != 1 {
+		t.Errorf("stored messages = %d, want 1", count)
+	}
+}
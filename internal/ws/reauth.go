@@ -0,0 +1,99 @@
+package ws
+
+import (
+	"errors"
+	"time"
+
+	"chatroom/internal/models"
+
+	"github.com/rs/zerolog/log"
+)
+
+// defaultReauthLead 是未配置时令牌过期前多久下发 reauth_required。
+const defaultReauthLead = 60 * time.Second
+
+// watchExpiry 记录连接凭证的过期时间，并在过期前提醒客户端续期；零值表示不限制。
+func (c *Client) watchExpiry(expiresAt time.Time) {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	c.authExpiry = expiresAt
+	c.authWarned = false
+	if c.authTimer != nil {
+		c.authTimer.Stop()
+		c.authTimer = nil
+	}
+	if !expiresAt.IsZero() {
+		c.authTimer = time.AfterFunc(c.untilCheck(), c.checkExpiry)
+	}
+}
+
+// untilCheck 返回距离下一次检查的时间：尚未提醒时在过期前 reauthLead 检查，否则在过期时检查。调用方需持有 authMu。
+func (c *Client) untilCheck() time.Duration {
+	d := time.Until(c.authExpiry)
+	if !c.authWarned {
+		d -= c.reauthLead
+	}
+	return max(d, 0)
+}
+
+// stopExpiry 在连接断开时停止过期检查。
+func (c *Client) stopExpiry() {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	if c.authTimer != nil {
+		c.authTimer.Stop()
+	}
+}
+
+// checkExpiry 由定时器触发：用户已被删除或令牌已过期时断开连接，临近过期时下发 reauth_required。
+func (c *Client) checkExpiry() {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	if !c.userExists() {
+		c.closeWith(CloseUnauthorized, errUserNotFound.Error())
+		return
+	}
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	if c.authTimer == nil {
+		return
+	}
+	if !time.Now().Before(c.authExpiry) {
+		log.Info().Uint("user_id", c.userID).Msg("ws token expired")
+		c.closeWith(CloseTokenExpired, "token expired")
+		return
+	}
+	if !c.authWarned && time.Until(c.authExpiry) <= c.reauthLead {
+		c.authWarned = true
+		c.trySend(marshalEvent(ReauthEvent{Type: TypeReauthRequired, ExpiresAt: c.authExpiry}))
+	}
+	c.authTimer.Reset(c.untilCheck())
+}
+
+// userExists 确认连接所属用户仍然存在，查询失败时按存在处理，避免数据库抖动断开所有连接。
+func (c *Client) userExists() bool {
+	var count int64
+	if err := c.db.Model(&models.User{}).Where("id = ?", c.userID).Count(&count).Error; err != nil {
+		log.Warn().Err(err).Uint("user_id", c.userID).Msg("ws check user")
+		return true
+	}
+	return count > 0
+}
+
+// handleReauth 用客户端发来的新令牌延长连接的有效期，令牌必须属于当前用户。
+func (c *Client) handleReauth(in InboundMessage) {
+	id, err := c.authn.fromToken(in.Token)
+	if errors.Is(err, errUserNotFound) && !c.userExists() {
+		c.closeWith(CloseUnauthorized, errUserNotFound.Error())
+		return
+	}
+	if err != nil || id.user.ID != c.userID {
+		c.sendError(0, ErrCodeReauthFailed)
+		return
+	}
+	c.watchExpiry(id.expiresAt)
+	c.trySend(marshalEvent(ReauthEvent{Type: TypeReauthed, ExpiresAt: id.expiresAt}))
+}
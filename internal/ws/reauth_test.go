@@ -0,0 +1,122 @@
+package ws
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"chatroom/internal/auth"
+	"chatroom/internal/config"
+	"chatroom/internal/models"
+	"chatroom/internal/service"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+)
+
+// shortToken 签发一个 ttl 后过期的访问令牌。
+func (e *testEnv) shortToken(userID uint, ttl time.Duration) string {
+	e.t.Helper()
+	now := time.Now()
+	claims := auth.Claims{
+		UserID: userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatUint(uint64(userID), 10),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(e.cfg.JWTSecret))
+	if err != nil {
+		e.t.Fatalf("failed to sign token: %v", err)
+	}
+	return token
+}
+
+func TestServe_ReauthExtendsConnection(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) { cfg.WSReauthLeadSeconds = 60 })
+	conn := env.dial(fmt.Sprintf("room_id=%d&token=%s", env.roomID, env.shortToken(env.userID, 3*time.Second)))
+
+	// 令牌有效期短于提醒提前量，连接建立后立即收到提醒。
+	readUntil(t, conn, "reauth_required")
+
+	_, otherToken := env.createUser("bob")
+	if err := conn.WriteJSON(map[string]string{"type": "reauth", "token": otherToken}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if evt := readUntil(t, conn, "error"); evt["code"] != string(ErrCodeReauthFailed) {
+		t.Errorf("error = %v, want reauth_failed for another user's token", evt)
+	}
+
+	if err := conn.WriteJSON(map[string]string{"type": "reauth", "token": env.token}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	evt := readUntil(t, conn, "reauthed")
+	expiresAt, err := time.Parse(time.RFC3339, evt["expires_at"].(string))
+	if err != nil || time.Until(expiresAt) < 10*time.Minute {
+		t.Fatalf("reauthed = %v, want expiry of the fresh token", evt)
+	}
+
+	// 原令牌过期后连接仍然可用。
+	time.Sleep(3500 * time.Millisecond)
+	if err := conn.WriteJSON(map[string]string{"type": "ping"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	readUntil(t, conn, "pong")
+}
+
+func TestServe_ExpiredTokenCloses(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) { cfg.WSReauthLeadSeconds = 1 })
+	conn := env.dial(fmt.Sprintf("room_id=%d&token=%s", env.roomID, env.shortToken(env.userID, 2*time.Second)))
+
+	readUntil(t, conn, "reauth_required")
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, CloseTokenExpired) {
+				t.Errorf("read error = %v, want close %d", err, CloseTokenExpired)
+			}
+			return
+		}
+	}
+}
+
+func TestServe_TicketKeepsTokenExpiry(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) { cfg.WSReauthLeadSeconds = 1 })
+	// 即将过期的令牌换取的票据不能延长会话，连接随令牌一起到期。
+	dto, err := service.NewTicketService(env.db).Issue(env.userID, env.roomID, time.Now().Add(2*time.Second))
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	conn := env.dial("ticket=" + dto.Ticket)
+	readUntil(t, conn, "reauth_required")
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, CloseTokenExpired) {
+				t.Errorf("read error = %v, want close %d", err, CloseTokenExpired)
+			}
+			return
+		}
+	}
+}
+
+func TestServe_DeletedUserCloses(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) { cfg.WSReauthLeadSeconds = 1 })
+	conn := env.dial(fmt.Sprintf("room_id=%d&token=%s", env.roomID, env.shortToken(env.userID, 2*time.Second)))
+	readUntil(t, conn, "join")
+
+	if err := env.db.Delete(&models.User{}, env.userID).Error; err != nil {
+		t.Fatalf("delete user: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, CloseUnauthorized) {
+				t.Errorf("read error = %v, want close %d", err, CloseUnauthorized)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package ws
+
+import (
+	"encoding/json"
+
+	"github.com/rs/zerolog/log"
+)
+
+// defaultResumeLimit 是未配置时单次续传最多补发的消息条数。
+const defaultResumeLimit = 200
+
+// holdRoom 让 writePump 暂存该房间的实时帧，必须在加入 RoomHub 之前调用。
+func (c *Client) holdRoom(roomID uint) {
+	c.mu.Lock()
+	c.holding[roomID] = true
+	c.mu.Unlock()
+	select {
+	case c.control <- roomControl{roomID: roomID, hold: true}:
+	case <-c.done:
+	}
+}
+
+// releaseRoom 提交续传批次并放行该房间的实时帧，每次 holdRoom 之后只有第一次调用生效。
+func (c *Client) releaseRoom(roomID uint, batch resumeBatch) bool {
+	c.mu.Lock()
+	held := c.holding[roomID]
+	delete(c.holding, roomID)
+	c.mu.Unlock()
+	if !held {
+		return false
+	}
+	select {
+	case c.control <- roomControl{roomID: roomID, batch: batch}:
+	case <-c.done:
+	}
+	return true
+}
+
+// buildResume 从数据库查询 lastSeenID 之后的消息作为续传批次。
+// 缺失消息超过上限或查询失败时只返回 resync_required，由客户端通过 REST 全量同步。
+func (c *Client) buildResume(roomID, lastSeenID uint) resumeBatch {
+	if lastSeenID == 0 {
+		return resumeBatch{}
+	}
+	limit := c.resumeLimit
+	if limit <= 0 {
+		limit = defaultResumeLimit
+	}
+	msgs, err := c.msgSvc.ListAfterID(roomID, lastSeenID, limit+1)
+	if err != nil {
+		log.Error().Err(err).Uint("room_id", roomID).Uint("user_id", c.userID).Msg("ws resume")
+		return resumeBatch{frames: []frame{{data: resyncRequired(roomID, lastSeenID), roomID: roomID}}}
+	}
+	if len(msgs) > limit {
+		return resumeBatch{frames: []frame{{data: resyncRequired(roomID, lastSeenID), roomID: roomID}}}
+	}
+
+	batch := resumeBatch{frames: make([]frame, 0, len(msgs)+1)}
+	lastID := lastSeenID
+	for _, m := range msgs {
+		b, err := json.Marshal(m)
+		if err != nil {
+			continue
+		}
+		batch.frames = append(batch.frames, frame{data: b, roomID: roomID, seq: m.Seq})
+		if m.ID > lastID {
+			lastID = m.ID
+		}
+		if m.Seq > batch.lastSeq {
+			batch.lastSeq = m.Seq
+		}
+	}
+	if b := marshalEvent(ResumedEvent{Type: TypeResumed, RoomID: roomID, Count: len(msgs), LastID: lastID}); b != nil {
+		batch.frames = append(batch.frames, frame{data: b, roomID: roomID})
+	}
+	return batch
+}
+
+// resyncRequired 构造提示客户端全量同步历史消息的事件。
+func resyncRequired(roomID, lastSeenID uint) []byte {
+	return marshalEvent(ResyncRequiredEvent{Type: TypeResyncRequired, RoomID: roomID, LastSeenID: lastSeenID})
+}
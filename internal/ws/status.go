@@ -0,0 +1,217 @@
+package ws
+
+import (
+	"errors"
+	"time"
+
+	"chatroom/internal/presence"
+	"chatroom/internal/service"
+
+	"github.com/rs/zerolog/log"
+)
+
+// defaultIdleAfter 是未配置时连接无操作多久后视为空闲。
+const defaultIdleAfter = 5 * time.Minute
+
+// userActivity 记录用户在本实例上的连接数及其中空闲的连接数。
+type userActivity struct {
+	conns int
+	idle  int
+}
+
+func (a *userActivity) allIdle() bool { return a.conns > 0 && a.idle == a.conns }
+
+// connect 登记一个新连接；新连接是活跃的，返回用户整体空闲状态是否因此改变。
+func (h *Hub) connect(c *Client) bool {
+	h.amu.Lock()
+	defer h.amu.Unlock()
+	a := h.activity[c.userID]
+	if a == nil {
+		a = &userActivity{}
+		h.activity[c.userID] = a
+	}
+	before := a.allIdle()
+	a.conns++
+	return before != a.allIdle()
+}
+
+// disconnect 注销连接，返回用户整体空闲状态是否因此改变以及改变后的值。
+func (h *Hub) disconnect(c *Client, wasIdle bool) (changed, idle bool) {
+	h.amu.Lock()
+	defer h.amu.Unlock()
+	a := h.activity[c.userID]
+	if a == nil {
+		return false, false
+	}
+	before := a.allIdle()
+	a.conns--
+	if wasIdle {
+		a.idle--
+	}
+	if a.conns <= 0 {
+		delete(h.activity, c.userID)
+		return before, false
+	}
+	return before != a.allIdle(), a.allIdle()
+}
+
+// markIdle 更新连接的空闲标记，返回用户整体空闲状态是否因此改变以及改变后的值。
+func (h *Hub) markIdle(c *Client, idle bool) (changed, all bool) {
+	h.amu.Lock()
+	defer h.amu.Unlock()
+	a := h.activity[c.userID]
+	if a == nil {
+		return false, false
+	}
+	before := a.allIdle()
+	if idle {
+		a.idle++
+	} else {
+		a.idle--
+	}
+	return before != a.allIdle(), a.allIdle()
+}
+
+// userIdle 报告用户是否所有连接都已空闲；配置了 presence 时汇总所有实例。
+func (h *Hub) userIdle(userID uint) bool {
+	if h.tracker != nil {
+		idle, err := h.tracker.AllIdle(userID)
+		if err == nil {
+			return idle
+		}
+		log.Warn().Err(err).Uint("user_id", userID).Msg("ws presence idle")
+	}
+	h.amu.Lock()
+	defer h.amu.Unlock()
+	a := h.activity[userID]
+	return a != nil && a.allIdle()
+}
+
+// roomsOf 返回用户所在的房间：本实例上的房间，加上 presence 记录的其它实例上的房间。
+func (h *Hub) roomsOf(userID uint) []uint {
+	seen := make(map[uint]bool)
+	var ids []uint
+	h.mu.RLock()
+	for id, room := range h.rooms {
+		if room.hasUser(userID) {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	h.mu.RUnlock()
+	if h.tracker != nil {
+		remote, err := h.tracker.RoomsOf(userID)
+		if err != nil {
+			log.Warn().Err(err).Uint("user_id", userID).Msg("ws presence rooms")
+		}
+		for _, id := range remote {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// StatusChanged 向用户所在的每个房间推送 presence 事件，实现 service.StatusNotifier。
+func (h *Hub) StatusChanged(userID uint, username string, st presence.UserStatus) {
+	idle := h.userIdle(userID)
+	status, text, expiresAt := st.Effective(idle, time.Now())
+	for _, id := range h.roomsOf(userID) {
+		evt := PresenceEvent{
+			Type: TypePresence, RoomID: id, UserID: userID, Username: username,
+			Status: status, StatusText: text, StatusExpiresAt: expiresAt, Idle: idle,
+		}
+		h.GetRoom(id).publish(frame{data: marshalEvent(evt), roomID: id, ephemeral: true, key: coalesceKey("presence", id, userID)})
+	}
+}
+
+// hasUser 报告用户在本实例上是否有连接在房间内。
+func (rh *RoomHub) hasUser(userID uint) bool {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	return rh.users[userID] != nil
+}
+
+// touch 记录一次客户端操作，空闲的连接恢复为活跃。
+func (c *Client) touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+	if c.idleTimer != nil {
+		c.idleTimer.Reset(c.idleAfter)
+	}
+	if c.idle.Load() {
+		c.setIdle(false)
+	}
+}
+
+// checkIdle 由空闲计时器触发，期间有过操作则忽略。
+func (c *Client) checkIdle() {
+	if time.Since(time.Unix(0, c.lastActive.Load())) < c.idleAfter {
+		return
+	}
+	c.setIdle(true)
+}
+
+// setIdle 切换连接的空闲标记，用户整体状态因此改变时通知其所在房间。
+func (c *Client) setIdle(idle bool) {
+	c.idleMu.Lock()
+	defer c.idleMu.Unlock()
+	if c.idle.Load() == idle {
+		return
+	}
+	c.idle.Store(idle)
+	if changed, all := c.hub.markIdle(c, idle); changed {
+		c.hub.tracker.SetIdle(c.userID, all)
+		c.announceStatus()
+	}
+}
+
+// disconnectActivity 在连接断开时注销空闲统计。
+func (c *Client) disconnectActivity() {
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
+	c.idleMu.Lock()
+	defer c.idleMu.Unlock()
+	changed, all := c.hub.disconnect(c, c.idle.Load())
+	if changed {
+		c.hub.tracker.SetIdle(c.userID, all)
+		if all {
+			c.announceStatus()
+		}
+	}
+}
+
+// announceStatus 读取用户的手动状态并推送 presence 事件。
+func (c *Client) announceStatus() {
+	username, st, err := c.statusSvc.Load(c.userID)
+	if err != nil {
+		log.Warn().Err(err).Uint("user_id", c.userID).Msg("ws load status")
+		return
+	}
+	c.hub.StatusChanged(c.userID, username, st)
+}
+
+// handleStatus 处理客户端设置手动状态的请求，成功后由 StatusChanged 推送 presence 事件。
+func (c *Client) handleStatus(in InboundMessage) {
+	_, err := c.statusSvc.Set(c.userID, service.SetStatusInput{
+		Status:    in.Status,
+		Text:      in.StatusText,
+		ExpiresIn: time.Duration(in.ExpiresIn) * time.Second,
+	})
+	if err == nil {
+		return
+	}
+	switch {
+	case errors.Is(err, service.ErrInvalidStatus):
+		c.sendError(0, ErrCodeInvalidStatus)
+	case errors.Is(err, service.ErrStatusTextTooLong):
+		c.sendError(0, ErrCodeStatusTextTooLong)
+	case errors.Is(err, service.ErrInvalidStatusExpiry):
+		c.sendError(0, ErrCodeInvalidStatusExpiry)
+	default:
+		log.Error().Err(err).Uint("user_id", c.userID).Msg("ws set status")
+		c.sendError(0, ErrCodeStatusFailed)
+	}
+}
@@ -0,0 +1,150 @@
+package ws
+
+import (
+	"errors"
+
+	"chatroom/internal/metrics"
+	"chatroom/internal/models"
+)
+
+// errRoomStopped 表示房间已停止，无法再注册。
+var errRoomStopped = errors.New("room stopped")
+
+// join 把客户端注册到房间；房间已停止或连接数已达上限时返回错误。
+func (c *Client) join(rh *RoomHub) error {
+	if !rh.admit() {
+		metrics.WsConnectionsRejected.WithLabelValues(limitRoom).Inc()
+		return errRoomFull
+	}
+	select {
+	case rh.register <- c:
+	case <-rh.stop:
+		rh.unadmit()
+		return errRoomStopped
+	}
+	c.mu.Lock()
+	c.rooms[rh.roomID] = rh
+	c.mu.Unlock()
+	return nil
+}
+
+// joinRoom 把客户端注册到房间；空闲的 RoomHub 恰好被回收时换用新实例重试。
+func (c *Client) joinRoom(roomID uint) error {
+	for {
+		err := c.join(c.hub.GetRoom(roomID))
+		if !errors.Is(err, errRoomStopped) || c.hub.closed() {
+			return err
+		}
+	}
+}
+
+// joinError 把 join 的错误映射为错误码。
+func joinError(err error) ErrorCode {
+	if errors.Is(err, errRoomFull) {
+		return ErrCodeRoomFull
+	}
+	return ErrCodeRoomUnavailable
+}
+
+// leave 把客户端从房间注销，返回被注销的 RoomHub，未订阅时返回 nil。
+func (c *Client) leave(roomID uint) *RoomHub {
+	c.mu.Lock()
+	rh := c.rooms[roomID]
+	delete(c.rooms, roomID)
+	c.mu.Unlock()
+	if rh == nil {
+		return nil
+	}
+	// 仍在等待续传的房间先放行，避免 writePump 一直暂存该房间的帧。
+	c.releaseRoom(roomID, resumeBatch{})
+	select {
+	case rh.unregister <- c:
+	case <-rh.stop:
+	}
+	return rh
+}
+
+// leaveAll 在连接断开时注销所有订阅。
+func (c *Client) leaveAll() {
+	c.mu.Lock()
+	ids := make([]uint, 0, len(c.rooms))
+	for id := range c.rooms {
+		ids = append(ids, id)
+	}
+	c.mu.Unlock()
+	for _, id := range ids {
+		c.leave(id)
+	}
+}
+
+// targetRoom 返回帧所指向的已订阅房间；未携带 room_id 且只订阅了一个房间时默认使用该房间。
+func (c *Client) targetRoom(roomID uint) *RoomHub {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if roomID != 0 {
+		return c.rooms[roomID]
+	}
+	if len(c.rooms) == 1 {
+		for _, rh := range c.rooms {
+			return rh
+		}
+	}
+	return nil
+}
+
+// handleSubscribe 订阅房间，携带 last_seen_id 时先补发错过的消息。
+func (c *Client) handleSubscribe(in InboundMessage) {
+	if in.RoomID == 0 {
+		c.sendError(0, ErrCodeRoomIDRequired)
+		return
+	}
+	if c.boundRoom != 0 && in.RoomID != c.boundRoom {
+		c.sendError(in.RoomID, ErrCodeRoomNotAllowed)
+		return
+	}
+	c.mu.Lock()
+	_, subscribed := c.rooms[in.RoomID]
+	full := len(c.rooms) >= c.maxRooms
+	c.mu.Unlock()
+	if subscribed {
+		c.trySend(subscriptionEvent(TypeSubscribed, in.RoomID))
+		return
+	}
+	if full {
+		c.sendError(in.RoomID, ErrCodeTooManyRooms)
+		return
+	}
+	var room models.Room
+	if err := c.db.Select("id").First(&room, in.RoomID).Error; err != nil {
+		c.sendError(in.RoomID, ErrCodeRoomNotFound)
+		return
+	}
+
+	if code := c.sanctionError(room.ID, false); code != "" {
+		c.sendError(room.ID, code)
+		return
+	}
+	c.holdRoom(room.ID)
+	if err := c.joinRoom(room.ID); err != nil {
+		c.releaseRoom(room.ID, resumeBatch{})
+		c.sendError(room.ID, joinError(err))
+		return
+	}
+	batch := c.buildResume(room.ID, in.LastSeenID)
+	batch.frames = append([]frame{{data: subscriptionEvent(TypeSubscribed, room.ID), roomID: room.ID}}, batch.frames...)
+	c.releaseRoom(room.ID, batch)
+}
+
+// handleUnsubscribe 取消订阅房间。
+func (c *Client) handleUnsubscribe(in InboundMessage) {
+	if c.leave(in.RoomID) == nil {
+		c.sendError(in.RoomID, ErrCodeNotSubscribed)
+		return
+	}
+	c.trySend(subscriptionEvent(TypeUnsubscribed, in.RoomID))
+}
+
+// subscriptionEvent 构造 subscribed / unsubscribed 确认事件。
+func subscriptionEvent(typ string, roomID uint) []byte {
+	return marshalEvent(SubscriptionEvent{Type: typ, RoomID: roomID})
+}
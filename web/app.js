@@ -165,6 +165,7 @@ class ChatSocket {
     this.heartbeatTimeout = null;
     this.messageQueue = []; // Offline message queue
     this.lastPong = Date.now();
+    this.restartDelay = null;
   }
 
   connect(roomId) {
@@ -173,7 +174,8 @@ class ChatSocket {
     this.currentRoomId = roomId;
     this.shouldReconnect = true;
     const proto = location.protocol === 'https:' ? 'wss:' : 'ws:';
-    const url = `${proto}//${location.host}/ws?room_id=${roomId}&token=${encodeURIComponent(State.accessToken)}`;
+    // 令牌通过首帧 auth 发送，避免出现在 URL 与访问日志中。
+    const url = `${proto}//${location.host}/ws?room_id=${roomId}`;
     
     UI.setConnectionStatus('connecting');
     
@@ -187,6 +189,7 @@ class ChatSocket {
 
     this.ws.onopen = () => {
       console.log('WS Connected to room:', roomId);
+      this.ws.send(JSON.stringify({ type: 'auth', token: State.accessToken }));
       this.reconnectAttempts = 0;
       this.lastPong = Date.now();
       UI.setConnectionStatus('connected');
@@ -196,6 +199,8 @@ class ChatSocket {
 
     this.ws.onclose = (e) => {
       console.log('WS Closed:', e.code, e.reason);
+      // 4003：被管理员踢出或封禁，重连也会被拒绝。
+      if (e.code === 4003) this.shouldReconnect = false;
       this.stopHeartbeat();
       UI.setConnectionStatus('disconnected');
       if (this.shouldReconnect) this.scheduleReconnect();
@@ -258,7 +263,8 @@ class ChatSocket {
       Toast.error("连接失败，请检查网络后刷新页面");
       return;
     }
-    const delay = Math.min(15000, 1000 * Math.pow(1.5, this.reconnectAttempts));
+    const delay = this.restartDelay ?? Math.min(15000, 1000 * Math.pow(1.5, this.reconnectAttempts));
+    this.restartDelay = null;
     this.reconnectAttempts++;
     console.log(`Reconnecting in ${delay}ms (attempt ${this.reconnectAttempts})`);
     UI.setConnectionStatus('reconnecting', this.reconnectAttempts);
@@ -310,7 +316,26 @@ class ChatSocket {
         UI.updateOnlineUsers(msg.users || []);
         break;
       case 'error':
-        Toast.error(msg.content || "发生错误");
+        // 慢速模式与限流会通过 retry_after 告知还需等待的秒数。
+        Toast.error(msg.retry_after ? `${msg.content || msg.message}（${msg.retry_after} 秒后可再发送）` : (msg.content || "发生错误"));
+        break;
+      case 'reauth_required':
+        // 令牌即将过期：刷新后通过 reauth 帧延长当前连接。
+        API.refreshToken().then(() => {
+          if (this.ws && this.ws.readyState === WebSocket.OPEN) {
+            this.ws.send(JSON.stringify({ type: 'reauth', token: State.accessToken }));
+          }
+        });
+        break;
+      case 'kicked':
+        Toast.error(msg.reason ? `你已被移出房间：${msg.reason}` : '你已被移出房间');
+        break;
+      case 'message_updated':
+        UI.updateMessage(msg);
+        break;
+      case 'server_restarting':
+        // 服务端停服排空：按建议的时长错峰重连，而不是立即重试。
+        this.restartDelay = msg.reconnect_after_ms;
         break;
       case 'join':
       case 'leave':
@@ -640,7 +665,6 @@ const UI = {
     if (type !== 'message') return;
 
     const user = m.username || m.Username || m.user || m.User || 'Unknown';
-    const content = m.content || m.Content || '';
     const rawDate = m.created_at || m.CreatedAt || Date.now();
     const dateObj = new Date(rawDate);
     const ts = dateObj.toLocaleTimeString([], { hour: '2-digit', minute: '2-digit' });
@@ -693,7 +717,8 @@ const UI = {
     bubble.classList.add('px-4', 'py-2.5', 'max-w-full', 'break-words', 'text-sm', 'leading-relaxed');
     
     // Process content for mentions and links
-    bubble.innerHTML = this.processMessageContent(content);
+    bubble.classList.add('msg-body');
+    this.renderBody(bubble, m);
 
     msgArea.appendChild(bubble);
 
@@ -708,6 +733,14 @@ const UI = {
         <svg class="w-3.5 h-3.5" fill="none" stroke="currentColor" viewBox="0 0 24 24"><path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M14.828 14.828a4 4 0 01-5.656 0M9 10h.01M15 10h.01M21 12a9 9 0 11-18 0 9 9 0 0118 0z"/></svg>
       </button>
     `;
+    if (!isMe && msgId) {
+      const report = document.createElement('button');
+      report.className = 'p-1 text-gray-600 hover:text-gray-400 rounded transition-colors';
+      report.title = '举报';
+      report.innerHTML = '<svg class="w-3.5 h-3.5" fill="none" stroke="currentColor" viewBox="0 0 24 24"><path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M3 21V4m0 0h13l-2 4 2 4H3"/></svg>';
+      report.addEventListener('click', () => Actions.reportMessage(msgId));
+      actions.appendChild(report);
+    }
     msgArea.appendChild(actions);
 
     wrapper.appendChild(msgArea);
@@ -720,6 +753,22 @@ const UI = {
     }
   },
 
+  // renderBody 渲染消息正文，被隐藏的消息只显示占位。
+  renderBody(bubble, m) {
+    if (m.hidden) {
+      bubble.innerHTML = '<span class="italic text-gray-500">该消息已被隐藏</span>';
+      return;
+    }
+    bubble.innerHTML = this.processMessageContent(m.content || m.Content || '');
+  },
+
+  // updateMessage 处理 message_updated 事件，目前用于消息被隐藏或恢复。
+  updateMessage(m) {
+    const wrapper = document.querySelector(`[data-msg-id="${Number(m.id)}"]`);
+    const bubble = wrapper?.querySelector('.msg-body');
+    if (bubble) this.renderBody(bubble, m);
+  },
+
   processMessageContent(content) {
     // Escape HTML first
     let safe = this.escape(content);
@@ -836,6 +885,18 @@ const UI = {
 // --- 6. Actions / Controllers ---
 
 const Actions = {
+  async reportMessage(id) {
+    const categories = { '1': 'spam', '2': 'harassment', '3': 'hate', '4': 'sexual', '5': 'violence', '6': 'other' };
+    const pick = prompt('举报原因：1 垃圾广告  2 骚扰  3 仇恨言论  4 色情  5 暴力  6 其他', '1');
+    if (!pick || !categories[pick.trim()]) return;
+    try {
+      await API.request(`/api/v1/messages/${Number(id)}/report`, 'POST', { category: categories[pick.trim()] }, true);
+      Toast.success('已提交举报');
+    } catch (err) {
+      Toast.error(err.status === 409 ? '你已经举报过这条消息' : '举报失败');
+    }
+  },
+
   async register() {
     const u = $('reg-username').value.trim();
     const p = $('reg-password').value;